@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"github.com/QuestScreen/api/modules"
+	"gopkg.in/yaml.v3"
 )
 
 // SystemTemplate is a template to create a system from.
@@ -70,6 +71,30 @@ type Plugin struct {
 	// SceneTemplates defines templates for creating scenes.
 	// These scenes can be referenced from GroupTemplates.
 	SceneTemplates []SceneTemplate
+	// SchemaVersion is the version of the YAML structure of the persisted
+	// configs and states of this plugin's modules. It must be increased whenever
+	// that structure changes incompatibly, with a migration from the previous
+	// version. 0 is treated as 1. Templates must always use the current version.
+	SchemaVersion int
+	// Migrations upgrade module configs and states that have been persisted
+	// with an older SchemaVersion.
+	Migrations []Migration
+}
+
+// Migration upgrades the persisted config and state of a module from one schema
+// version of its plugin to the next.
+type Migration struct {
+	// Module is the ID of the module inside its plugin.
+	Module string
+	// From is the schema version this migration upgrades from. It upgrades to
+	// From + 1.
+	From int
+	// Config upgrades a module config, given as mapping from config item names
+	// to their values. Config may be nil if the config is unchanged.
+	Config func(node *yaml.Node) error
+	// State upgrades a module state in the structure written by the module's
+	// state. State may be nil if the state is unchanged.
+	State func(node *yaml.Node) error
 }
 
 type jsonTemplate struct {
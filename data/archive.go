@@ -0,0 +1,259 @@
+package data
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maximum total size of the files contained in an imported archive.
+const maxImportSize = 1 << 30
+
+// addDirToArchive adds all files below the given directory of the data dir to
+// the archive. Paths inside the archive are relative to the data dir.
+func (p Persistence) addDirToArchive(w *zip.Writer, subdirs ...string) error {
+	root := p.d.owner.DataDir()
+	return filepath.Walk(p.d.owner.DataDir(subdirs...),
+		func(filePath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			// skip backups and unfinished writes.
+			if strings.HasSuffix(info.Name(), backupSuffix) ||
+				strings.HasPrefix(info.Name(), ".") {
+				return nil
+			}
+			rel, err := filepath.Rel(root, filePath)
+			if err != nil {
+				return err
+			}
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(rel)
+			header.Method = zip.Deflate
+			out, err := w.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			in, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			_, err = io.Copy(out, in)
+			return err
+		})
+}
+
+// ExportGroup writes a zip archive containing the given group to w.
+// The archive contains the group's directory including its scenes, heroes,
+// state and resource files. If the group references a system, that system's
+// directory is included as well.
+func (p Persistence) ExportGroup(g Group, w io.Writer) error {
+	value := g.(*group)
+	zw := zip.NewWriter(w)
+	if err := p.addDirToArchive(zw, "groups", value.id); err != nil {
+		return err
+	}
+	if value.systemIndex != -1 {
+		if err := p.addDirToArchive(
+			zw, "systems", p.d.systems[value.systemIndex].id); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// extractArchive extracts the given zip archive into the directory at target.
+// Only files below groups/<id> and systems/<id> are allowed, with at most one
+// id each. Returns the ids of the contained group and system; the latter is
+// empty if the archive does not contain a system.
+func extractArchive(zr *zip.Reader, target string) (groupID, systemID string,
+	err error) {
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+		if total > maxImportSize {
+			return "", "", errors.New("archive content too large")
+		}
+		name := path.Clean(f.Name)
+		parts := strings.SplitN(name, "/", 3)
+		if len(parts) < 2 || parts[1] == ".." || path.IsAbs(name) ||
+			strings.HasPrefix(name, "../") {
+			return "", "", fmt.Errorf("illegal path in archive: %s", f.Name)
+		}
+		switch parts[0] {
+		case "groups":
+			if groupID == "" {
+				groupID = parts[1]
+			} else if groupID != parts[1] {
+				return "", "", errors.New("archive contains more than one group")
+			}
+		case "systems":
+			if systemID == "" {
+				systemID = parts[1]
+			} else if systemID != parts[1] {
+				return "", "", errors.New("archive contains more than one system")
+			}
+		default:
+			return "", "", fmt.Errorf("unexpected path in archive: %s", f.Name)
+		}
+
+		filePath := filepath.Join(target, filepath.FromSlash(name))
+		if f.FileInfo().IsDir() {
+			if err = os.MkdirAll(filePath, 0755); err != nil {
+				return
+			}
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return
+		}
+		if err = extractFile(f, filePath); err != nil {
+			return
+		}
+	}
+	if groupID == "" {
+		err = errors.New("archive does not contain a group")
+	}
+	return
+}
+
+func extractFile(f *zip.File, filePath string) error {
+	in, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, io.LimitReader(in, int64(f.UncompressedSize64)))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sortInLastSystem moves the last system in the list of systems to its sorted
+// position and updates the system references of all groups.
+func (p Persistence) sortInLastSystem() {
+	systemIDs := make([]string, len(p.d.groups))
+	for i, g := range p.d.groups {
+		if g.systemIndex != -1 {
+			systemIDs[i] = p.d.systems[g.systemIndex].id
+		}
+	}
+	insertSorted(systemSortInterface{p.d.systems, p.d.numPluginSystems})
+	for i, g := range p.d.groups {
+		if g.systemIndex != -1 {
+			g.systemIndex, _ = p.d.SystemByID(systemIDs[i])
+		}
+	}
+}
+
+// ImportGroup adds the group contained in the given zip archive, which must
+// have the structure created by ExportGroup. The group's data is validated
+// the same way as when loading groups at startup.
+//
+// If the group's ID collides with an existing group, a new ID is generated.
+// If the group references a system that does not exist, the system contained
+// in the archive is imported as well; an existing system with the referenced
+// ID is used as-is.
+//
+// Returns the imported group.
+func (p Persistence) ImportGroup(archive []byte) (Group, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+	// extract into the data dir so that the final move does not cross
+	// file system boundaries.
+	tmp, err := ioutil.TempDir(p.d.owner.DataDir(), ".import")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	groupID, systemID, err := extractArchive(zr, tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	groupPath := filepath.Join(tmp, "groups", groupID)
+	configPath := filepath.Join(groupPath, "config.yaml")
+	var data persistedGroup
+	if err := p.loadDocument(groupDocument, fileInput(configPath), configPath,
+		&data, false); err != nil {
+		return nil, err
+	}
+	var newSystem *system
+	if data.System != "" {
+		if _, s := p.d.SystemByID(data.System); s == nil {
+			if data.System != systemID {
+				return nil, fmt.Errorf(
+					"group references system \"%s\" which is neither available nor contained in the archive",
+					data.System)
+			}
+			sysConfigPath := filepath.Join(tmp, "systems", systemID, "config.yaml")
+			newSystem, err = p.loadSystem(
+				systemID, fileInput(sysConfigPath), sysConfigPath)
+			if err != nil {
+				return nil, err
+			}
+			// loadGroup resolves the system reference. the system stays at the
+			// end of the list until the import succeeded.
+			p.d.systems = append(p.d.systems, newSystem)
+		}
+	}
+	removeNewSystem := func() {
+		if newSystem != nil {
+			p.d.systems[len(p.d.systems)-1] = nil
+			p.d.systems = p.d.systems[:len(p.d.systems)-1]
+		}
+	}
+
+	id := genID(groupID, "group", groupIDs{p.d.groups})
+	heroes := p.loadHeroes(groupPath)
+	g, order, err := p.loadGroup(heroes, id, fileInput(configPath), configPath)
+	if err != nil {
+		removeNewSystem()
+		return nil, err
+	}
+	g.scenes = p.loadScenes(&g.heroes, groupPath, order)
+	if len(g.scenes) == 0 {
+		removeNewSystem()
+		return nil, errors.New("archive does not contain any valid scenes")
+	}
+
+	if newSystem != nil {
+		if err := os.Rename(filepath.Join(tmp, "systems", systemID),
+			p.d.owner.DataDir("systems", systemID)); err != nil {
+			removeNewSystem()
+			return nil, err
+		}
+	}
+	if err := os.Rename(groupPath, p.d.owner.DataDir("groups", id)); err != nil {
+		if newSystem != nil {
+			os.RemoveAll(p.d.owner.DataDir("systems", systemID))
+			removeNewSystem()
+		}
+		return nil, err
+	}
+
+	p.d.groups = append(p.d.groups, g)
+	insertSorted(groupSortInterface{p.d.groups})
+	if newSystem != nil {
+		p.sortInLastSystem()
+	}
+	return g, nil
+}
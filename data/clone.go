@@ -0,0 +1,215 @@
+package data
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/QuestScreen/QuestScreen/shared"
+	"github.com/QuestScreen/api/server"
+)
+
+// GroupCloneOptions selects the parts of a group that are copied by CloneGroup
+// in addition to the group's configuration and scenes.
+type GroupCloneOptions struct {
+	Heroes, State, Resources bool
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// copyDir copies the directory at src with all its content to dst, skipping
+// backups and unfinished writes. Does nothing if src does not exist.
+func copyDir(src, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(src,
+		func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, filePath)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dst, rel)
+			if info.IsDir() {
+				return os.MkdirAll(target, 0755)
+			}
+			if strings.HasSuffix(info.Name(), backupSuffix) ||
+				strings.HasPrefix(info.Name(), ".") {
+				return nil
+			}
+			return copyFile(filePath, target)
+		})
+}
+
+// CloneSystem creates a copy of the system at the given index with the given
+// name. The module configs are copied by persisting and loading them.
+func (p Persistence) CloneSystem(index int, name string) server.Error {
+	raw, err := p.systemYAML(p.d.systems[index])
+	if err != nil {
+		return &server.InternalError{
+			Description: "failed to serialize system", Inner: err}
+	}
+	id := genID(name, "system", systemIDs{p.d.systems})
+	s, err := p.loadSystem(id, byteInput(raw), clonePath)
+	if err != nil {
+		return &server.InternalError{
+			Description: "failed to copy system", Inner: err}
+	}
+	s.name = name
+	if err := p.WriteSystem(s); err != nil {
+		return &server.InternalError{
+			Description: "failed to write system", Inner: err}
+	}
+	p.d.systems = append(p.d.systems, s)
+	p.sortInLastSystem()
+	return nil
+}
+
+// cloneScene creates a copy of the given scene with the given ID inside the
+// given group. The copy is neither written nor added to the group.
+func (p Persistence) cloneScene(g *group, value *scene, id string) (scene,
+	error) {
+	raw, err := p.sceneYAML(value)
+	if err != nil {
+		return scene{}, err
+	}
+	return p.loadScene(&g.heroes, id, byteInput(raw), clonePath)
+}
+
+// CloneScene creates a copy of the scene at the given index in the given group
+// with the given name and appends it to the group's scenes.
+func (p Persistence) CloneScene(g Group, index int, name string) error {
+	gr := g.(*group)
+	if index < 0 || index >= g.NumScenes() {
+		return errors.New("index out of range")
+	}
+	id := genID(name, "scene", sceneIDs{gr.scenes})
+	s, err := p.cloneScene(gr, &gr.scenes[index], id)
+	if err != nil {
+		return err
+	}
+	s.name = name
+	if err = p.writeScene(gr, &s); err != nil {
+		return err
+	}
+	gr.scenes = append(gr.scenes, s)
+	return p.writeGroup(gr)
+}
+
+// CloneGroup creates a copy of the group at the given index with the given
+// name. The group's config and all its scenes are copied; opts selects what is
+// copied additionally. Scenes and heroes keep their IDs so that the copied
+// state stays valid.
+//
+// Returns the created group.
+func (p Persistence) CloneGroup(index int, name string,
+	opts GroupCloneOptions) (Group, error) {
+	src := p.d.groups[index]
+	id := genID(name, "group", groupIDs{p.d.groups})
+	var heroes []hero
+	if opts.Heroes {
+		heroes = append(heroes, src.heroes.data...)
+		for i := range heroes {
+			attributes := make(map[string]string, len(heroes[i].attributes))
+			for key, value := range heroes[i].attributes {
+				attributes[key] = value
+			}
+			heroes[i].attributes = attributes
+		}
+	}
+	raw, err := p.groupYAML(src)
+	if err != nil {
+		return nil, err
+	}
+	g, _, err := p.loadGroup(heroes, id, byteInput(raw), clonePath)
+	if err != nil {
+		return nil, err
+	}
+	g.name = name
+	dirPath := p.d.owner.DataDir("groups", id)
+	if err = os.MkdirAll(filepath.Join(dirPath, "scenes"), 0755); err != nil {
+		return nil, err
+	}
+	if err = p.copyGroupContent(src, g, opts); err != nil {
+		os.RemoveAll(dirPath)
+		return nil, err
+	}
+	p.d.groups = append(p.d.groups, g)
+	insertSorted(groupSortInterface{p.d.groups})
+	return g, nil
+}
+
+// copyGroupContent writes the config and scenes of the cloned group g and
+// copies the parts of src selected by opts into g's directory.
+func (p Persistence) copyGroupContent(src, g *group,
+	opts GroupCloneOptions) error {
+	g.scenes = make([]scene, 0, len(src.scenes))
+	for i := range src.scenes {
+		s, err := p.cloneScene(g, &src.scenes[i], src.scenes[i].id)
+		if err != nil {
+			return err
+		}
+		if err = p.writeScene(g, &s); err != nil {
+			return err
+		}
+		g.scenes = append(g.scenes, s)
+	}
+	if err := p.writeGroup(g); err != nil {
+		return err
+	}
+	srcPath := p.d.owner.DataDir("groups", src.id)
+	dstPath := p.d.owner.DataDir("groups", g.id)
+	if opts.Heroes {
+		if err := copyDir(filepath.Join(srcPath, "heroes"),
+			filepath.Join(dstPath, "heroes")); err != nil {
+			return err
+		}
+	}
+	if opts.State {
+		statePath := filepath.Join(dstPath, "state.yaml")
+		if p.d.State.group == Group(src) {
+			// the state file may lag behind the loaded state.
+			raw, err := p.d.State.buildYaml()
+			if err != nil {
+				return err
+			}
+			if err = writeFile(statePath, raw); err != nil {
+				return err
+			}
+		} else if err := copyFile(filepath.Join(srcPath, "state.yaml"),
+			statePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if opts.Resources {
+		for i := shared.FirstModule; i < p.d.owner.NumModules(); i++ {
+			moduleID := p.d.owner.ModuleID(i)
+			if err := copyDir(filepath.Join(srcPath, moduleID),
+				filepath.Join(dstPath, moduleID)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
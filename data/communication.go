@@ -24,8 +24,14 @@ type Communication struct {
 func (c Communication) systems() []shared.System {
 	ret := make([]shared.System, 0, len(c.d.systems))
 	for i := range c.d.systems {
-		ret = append(ret, shared.System{Name: c.d.systems[i].name,
-			ID: c.d.systems[i].id})
+		s := c.d.systems[i]
+		attributes := make([]shared.HeroAttribute, len(s.heroAttributes))
+		for j := range s.heroAttributes {
+			attributes[j] = shared.HeroAttribute{
+				ID: s.heroAttributes[j].id, Name: s.heroAttributes[j].name}
+		}
+		ret = append(ret, shared.System{Name: s.name, ID: s.id,
+			HeroAttributes: attributes})
 	}
 	return ret
 }
@@ -46,10 +52,17 @@ func (c Communication) scenes(g *group) []shared.Scene {
 
 func (c Communication) heroes(hl *heroList) []shared.Hero {
 	ret := make([]shared.Hero, 0, len(hl.data))
+	schema := hl.attributes()
 	for i := range hl.data {
 		h := &hl.data[i]
-		ret = append(ret,
-			shared.Hero{Name: h.name, ID: h.id, Description: h.description})
+		attributes := make([]shared.HeroAttributeValue, len(schema))
+		for j := range schema {
+			attributes[j] = shared.HeroAttributeValue{ID: schema[j].id,
+				Name: schema[j].name, Value: h.attributes[schema[j].id]}
+		}
+		ret = append(ret, shared.Hero{Name: h.name, ID: h.id,
+			Description: h.description, Portrait: h.portrait != "",
+			Attributes: attributes})
 	}
 	return ret
 }
@@ -108,8 +121,25 @@ func (c Communication) plugins(a app.App) []shared.Plugin {
 
 		ret = append(ret, descr)
 	}
+	user := shared.Plugin{Name: "User Templates", ID: shared.UserTemplatesID}
+	for _, t := range c.d.groupTemplates {
+		user.GroupTemplates = append(user.GroupTemplates, shared.TemplateDescr{
+			Name: t.Name, Description: t.Description, ID: t.id,
+		})
+	}
+	for _, t := range c.d.sceneTemplates {
+		user.SceneTemplates = append(user.SceneTemplates, shared.TemplateDescr{
+			Name: t.Name, Description: t.Description, ID: t.id,
+		})
+	}
 
-	return ret
+	return append(ret, user)
+}
+
+// ViewTemplates returns a serializable view of all templates, which is the
+// list of plugins as given in the static data.
+func (c Communication) ViewTemplates(a app.App) []shared.Plugin {
+	return c.plugins(a)
 }
 
 // StaticData returns a serializable view of all static data (i.e. data that
@@ -159,15 +189,30 @@ func (c Communication) UpdateSystemConfig(raw []byte, s System) server.Error {
 	return c.loadModuleConfigs(raw, s.(*system).modules)
 }
 
-// UpdateSystem updates a system's name from a given JSON input.
+// UpdateSystem updates a system's name and, if given, its hero attributes from
+// a given JSON input.
 func (c Communication) UpdateSystem(raw []byte, s System) server.Error {
 	data := struct {
-		Name comms.ValidatedString `json:"name"`
+		Name           comms.ValidatedString   `json:"name"`
+		HeroAttributes *[]shared.HeroAttribute `json:"heroAttributes"`
 	}{Name: comms.ValidatedString{MinLen: 1, MaxLen: -1}}
 	if err := comms.ReceiveData(raw, &data); err != nil {
 		return &server.BadRequest{Inner: err, Message: "received invalid data"}
 	}
-	s.(*system).name = data.Name.Value
+	sys := s.(*system)
+	if data.HeroAttributes != nil {
+		persisted := make([]persistedHeroAttribute, len(*data.HeroAttributes))
+		for i, attr := range *data.HeroAttributes {
+			persisted[i] = persistedHeroAttribute{ID: attr.ID, Name: attr.Name}
+		}
+		attributes, err := loadHeroAttributes(persisted)
+		if err != nil {
+			return &server.BadRequest{Inner: err,
+				Message: "received invalid hero attributes"}
+		}
+		sys.heroAttributes = attributes
+	}
+	sys.name = data.Name.Value
 	// TODO: sort system list anew
 	return nil
 }
@@ -206,11 +251,14 @@ func (c Communication) ViewGroups() interface{} {
 	return c.groups()
 }
 
-// UpdateHero updates a hero's name and description form a given JSON input.
+// UpdateHero updates a hero's name, description and attributes form a given
+// JSON input. Attributes must be declared by the system of the hero's group;
+// an empty value removes the attribute's value.
 func (c Communication) UpdateHero(raw []byte, h groups.Hero) server.Error {
 	value := struct {
 		Name        comms.ValidatedString `json:"name"`
 		Description string                `json:"description"`
+		Attributes  map[string]string     `json:"attributes"`
 	}{
 		Name: comms.ValidatedString{MinLen: 1, MaxLen: -1},
 	}
@@ -218,8 +266,32 @@ func (c Communication) UpdateHero(raw []byte, h groups.Hero) server.Error {
 		return &server.BadRequest{Inner: err, Message: "received invalid data"}
 	}
 	he := h.(*hero)
+	schema := he.list.attributes()
+	for id := range value.Attributes {
+		found := false
+		for i := range schema {
+			if schema[i].id == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &server.BadRequest{
+				Message: fmt.Sprintf("unknown hero attribute \"%s\"", id)}
+		}
+	}
 	he.name = value.Name.Value
 	he.description = value.Description
+	if len(value.Attributes) > 0 && he.attributes == nil {
+		he.attributes = make(map[string]string)
+	}
+	for id, attr := range value.Attributes {
+		if attr == "" {
+			delete(he.attributes, id)
+		} else {
+			he.attributes[id] = attr
+		}
+	}
 	return nil
 }
 
@@ -305,7 +377,6 @@ func (c Communication) UpdateScene(raw []byte, g Group, s Scene) server.Error {
 	for i := range modules {
 		sc.modules[i].enabled = modules[i]
 	}
-	// TODO: sort scene list anew
 	return nil
 }
 
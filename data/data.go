@@ -2,6 +2,8 @@ package data
 
 import (
 	"log"
+	"net/url"
+	"path/filepath"
 	"reflect"
 
 	"github.com/QuestScreen/QuestScreen/app"
@@ -17,10 +19,18 @@ type System interface {
 	ID() string
 }
 
+// heroAttribute is an attribute of heroes declared by a system.
+type heroAttribute struct {
+	id, name string
+}
+
 type system struct {
 	name    string
 	id      string
 	modules []interface{}
+	layouts [][]layoutVariant
+	// attributes of the heroes of groups using this system, in display order.
+	heroAttributes []heroAttribute
 }
 
 func (s *system) Name() string {
@@ -31,11 +41,16 @@ func (s *system) ID() string {
 	return s.id
 }
 
-// implements api.Hero
+// implements groups.Hero and the extended Hero of plugins/base/shared
 type hero struct {
 	name        string
 	id          string
 	description string
+	// file name of the portrait inside the hero's directory, empty if none.
+	portrait string
+	// attribute ID -> value
+	attributes map[string]string
+	list       *heroList
 }
 
 func (h *hero) Name() string {
@@ -50,9 +65,27 @@ func (h *hero) Description() string {
 	return h.description
 }
 
+func (h *hero) Portrait() *url.URL {
+	if h.portrait == "" {
+		return nil
+	}
+	return &url.URL{Scheme: "file", Path: filepath.ToSlash(h.list.d.owner.DataDir(
+		"groups", h.list.group.id, "heroes", h.id, h.portrait))}
+}
+
+func (h *hero) NumAttributes() int {
+	return len(h.list.attributes())
+}
+
+func (h *hero) Attribute(index int) (name, value string) {
+	attr := h.list.attributes()[index]
+	return attr.name, h.attributes[attr.id]
+}
+
 type sceneModule struct {
 	enabled bool
 	config  interface{}
+	layouts []layoutVariant
 }
 
 // Scene describes a collection of modules that will be rendered together.
@@ -66,6 +99,8 @@ type scene struct {
 	name    string
 	id      string
 	modules []sceneModule
+	// nil if the group's transition is to be used.
+	transition *shared.SceneTransition
 }
 
 func (s *scene) Name() string {
@@ -81,7 +116,23 @@ func (s *scene) UsesModule(moduleIndex shared.ModuleIndex) bool {
 }
 
 type heroList struct {
-	data []hero
+	data  []hero
+	group *group
+	d     *Data
+}
+
+// attributes returns the hero attributes declared by the group's system.
+func (hl *heroList) attributes() []heroAttribute {
+	if hl.group.systemIndex == -1 {
+		return nil
+	}
+	return hl.d.systems[hl.group.systemIndex].heroAttributes
+}
+
+// add appends the given hero to the list.
+func (hl *heroList) add(h hero) {
+	h.list = hl
+	hl.data = append(hl.data, h)
 }
 
 func (hl *heroList) Hero(index int) groups.Hero {
@@ -118,8 +169,11 @@ type group struct {
 	id          string
 	systemIndex int
 	modules     []interface{}
+	layouts     [][]layoutVariant
 	heroes      heroList
 	scenes      []scene
+	// nil if scenes are shown immediately.
+	transition *shared.SceneTransition
 }
 
 func (g *group) Name() string {
@@ -159,9 +213,15 @@ func (g *group) Heroes() groups.HeroList {
 type Data struct {
 	owner            app.App
 	baseConfigs      []interface{}
+	baseLayouts      [][]layoutVariant
 	systems          []*system
 	groups           []*group
 	numPluginSystems int
+	// templates saved by the user.
+	groupTemplates []userGroupTemplate
+	sceneTemplates []userSceneTemplate
+	// undo histories of all groups that have been active, by group ID.
+	histories map[string]*history
 	State
 }
 
@@ -225,6 +285,7 @@ func (d *Data) GroupByID(id string) (index int, value Group) {
 func (d *Data) LoadPersisted(owner app.App) (Persistence, Communication) {
 	p := Persistence{d}
 	d.owner = owner
+	d.histories = make(map[string]*history)
 	basePath := d.owner.DataDir("base", "config.yaml")
 	ret, err := p.loadBase(basePath)
 	if err != nil {
@@ -233,6 +294,7 @@ func (d *Data) LoadPersisted(owner app.App) (Persistence, Communication) {
 	d.baseConfigs = ret
 	p.loadSystems()
 	p.loadGroups()
+	p.loadTemplates()
 	return p, Communication{d}
 }
 
@@ -250,62 +312,83 @@ func confValue(conf interface{}) *reflect.Value {
 
 // MergeConfig merges the item's default configuration with the values
 // configured in its base config and the current system, group and scene config.
+// On each level, the values of a layout variant matching the given aspect ratio
+// of the target output take precedence over the level's plain values.
 // It returns the resulting configuration.
 //
 // systemIndex may be -1 (for groups without a defined system), groupIndex and
 // sceneIndex may not.
 func (d *Data) MergeConfig(moduleIndex shared.ModuleIndex,
-	systemIndex int, groupIndex int, sceneIndex int) interface{} {
-	var configStack [5]*reflect.Value
+	systemIndex int, groupIndex int, sceneIndex int,
+	aspect float64) interface{} {
+	configStack := make([]*reflect.Value, 0, 9)
+	push := func(conf interface{}) {
+		if conf != nil {
+			configStack = append(configStack, confValue(conf))
+		}
+	}
 	module := d.owner.ModuleAt(moduleIndex)
 
 	defaultValues := module.DefaultConfig
 	configType := reflect.TypeOf(defaultValues).Elem()
 
 	{
-		conf := d.groups[groupIndex].scenes[sceneIndex].modules[moduleIndex].config
-		if conf == nil {
-			configStack[0] = nil
-		} else {
-			configStack[0] = confValue(conf)
-		}
+		conf := &d.groups[groupIndex].scenes[sceneIndex].modules[moduleIndex]
+		push(matchingLayout(conf.layouts, aspect))
+		push(conf.config)
 	}
 	{
-		conf := d.groups[groupIndex].modules[moduleIndex]
-		if conf == nil {
+		g := d.groups[groupIndex]
+		if g.modules[moduleIndex] == nil {
 			panic("group config missing for " + module.ID)
 		}
-		configStack[1] = confValue(conf)
+		push(moduleLayout(g.layouts, moduleIndex, aspect))
+		push(g.modules[moduleIndex])
 	}
 	if systemIndex != -1 {
-		conf := d.systems[systemIndex].modules[moduleIndex]
-		if conf == nil {
+		s := d.systems[systemIndex]
+		if s.modules[moduleIndex] == nil {
 			panic("system config missing for " + module.ID)
 		}
-		configStack[2] = confValue(conf)
+		push(moduleLayout(s.layouts, moduleIndex, aspect))
+		push(s.modules[moduleIndex])
 	}
 
 	baseConf := d.baseConfigs[moduleIndex]
 	if baseConf == nil {
 		panic("base config missing for " + module.ID)
 	}
+	push(moduleLayout(d.baseLayouts, moduleIndex, aspect))
 	baseValue := reflect.ValueOf(baseConf).Elem()
-	configStack[3] = &baseValue
+	configStack = append(configStack, &baseValue)
 
 	defaultValue := reflect.ValueOf(defaultValues).Elem()
-	configStack[4] = &defaultValue
+	configStack = append(configStack, &defaultValue)
 
 	result := reflect.New(configType)
 	for i := 0; i < configType.NumField(); i++ {
-		for j := 0; j < 5; j++ {
-			if configStack[j] != nil {
-				field := configStack[j].Field(i)
-				if !field.IsNil() {
-					result.Elem().Field(i).Set(field)
-					break
-				}
+		for _, level := range configStack {
+			field := level.Field(i)
+			if !field.IsNil() {
+				result.Elem().Field(i).Set(field)
+				break
 			}
 		}
 	}
 	return result.Interface()
 }
+
+// SceneTransition returns the transition to use when changing to the given
+// scene of the given group. The scene's transition takes precedence over the
+// group's. If neither defines one, the scene is shown immediately.
+func (d *Data) SceneTransition(groupIndex int,
+	sceneIndex int) shared.SceneTransition {
+	g := d.groups[groupIndex]
+	if t := g.scenes[sceneIndex].transition; t != nil {
+		return *t
+	}
+	if g.transition != nil {
+		return *g.transition
+	}
+	return shared.SceneTransition{Style: shared.CutTransition}
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"github.com/QuestScreen/QuestScreen/shared"
+	"github.com/QuestScreen/api/server"
+	"gopkg.in/yaml.v3"
+)
+
+// maximum number of changes that can be undone per group.
+const maxHistorySize = 64
+
+// historyEntry holds the state of the session before (or, on the redo stack,
+// after) a change was made. The active scene is identified by its ID so that
+// the entry stays valid if scenes are reordered.
+type historyEntry struct {
+	sceneID string
+	// module whose state has been changed, or -1 if only the active scene
+	// has been changed.
+	module shared.ModuleIndex
+	// persisted state of module.
+	state yaml.Node
+}
+
+type history struct {
+	undo, redo []historyEntry
+}
+
+// pushEntry appends e to the given stack, dropping its oldest entry if the
+// stack is full.
+func pushEntry(stack *[]historyEntry, e historyEntry) {
+	if len(*stack) == maxHistorySize {
+		copy(*stack, (*stack)[1:])
+		*stack = (*stack)[:maxHistorySize-1]
+	}
+	*stack = append(*stack, e)
+}
+
+// Checkpoint holds the state of the session at some point in time.
+// It is created before a change is made and recorded afterwards if the change
+// was successful.
+type Checkpoint struct {
+	entry historyEntry
+}
+
+// HistoryStep describes the change caused by an undo or redo operation.
+type HistoryStep struct {
+	s    *State
+	redo bool
+	// Scene is the index of the scene that will be active after the step.
+	Scene int
+	// Module is the index of the module whose state will be replaced,
+	// or -1 if no module state will be replaced.
+	Module shared.ModuleIndex
+}
+
+func (s *State) checkpoint(sceneIndex int,
+	moduleIndex shared.ModuleIndex) (historyEntry, error) {
+	ret := historyEntry{sceneID: s.group.Scene(sceneIndex).ID(),
+		module: moduleIndex}
+	if moduleIndex != -1 {
+		if err := ret.state.Encode(s.scenes[sceneIndex][moduleIndex].Persist(
+			s.a.ServerContext(moduleIndex))); err != nil {
+			return ret, err
+		}
+	}
+	return ret, nil
+}
+
+// Checkpoint captures the active scene and the state of the given module in
+// it. moduleIndex may be -1 if only the active scene is to be captured.
+func (s *State) Checkpoint(moduleIndex shared.ModuleIndex) (Checkpoint,
+	server.Error) {
+	entry, err := s.checkpoint(s.activeScene, moduleIndex)
+	if err != nil {
+		return Checkpoint{}, &server.InternalError{
+			Description: "unable to capture state of module " +
+				s.a.ModuleID(moduleIndex), Inner: err}
+	}
+	return Checkpoint{entry}, nil
+}
+
+// Record adds the given checkpoint to the undo history of the active group.
+// This discards all changes that could have been redone.
+func (s *State) Record(cp Checkpoint) {
+	pushEntry(&s.history.undo, cp.entry)
+	s.history.redo = s.history.redo[:0]
+}
+
+func (s *State) nextStep(redo bool) (HistoryStep, server.Error) {
+	stack, name := &s.history.undo, "undo"
+	if redo {
+		stack, name = &s.history.redo, "redo"
+	}
+	// entries whose scene has been deleted or does not use the module anymore
+	// are dropped from the stack so that Apply always takes the returned entry.
+	for len(*stack) > 0 {
+		e := &(*stack)[len(*stack)-1]
+		index, scene := s.group.SceneByID(e.sceneID)
+		if scene != nil && (e.module == -1 || scene.UsesModule(e.module)) {
+			return HistoryStep{s: s, redo: redo, Scene: index, Module: e.module}, nil
+		}
+		*stack = (*stack)[:len(*stack)-1]
+	}
+	return HistoryStep{}, &server.BadRequest{Message: "nothing to " + name}
+}
+
+// NextUndo returns the change that would be caused by undoing the most recently
+// recorded change. Returns an error if there is nothing to undo.
+func (s *State) NextUndo() (HistoryStep, server.Error) {
+	return s.nextStep(false)
+}
+
+// NextRedo returns the change that would be caused by redoing the most recently
+// undone change. Returns an error if there is nothing to redo.
+func (s *State) NextRedo() (HistoryStep, server.Error) {
+	return s.nextStep(true)
+}
+
+// Apply executes the step, recreating the affected module state from its
+// recorded data. The previous state is put onto the opposite stack so that the
+// step can be reverted.
+func (hs HistoryStep) Apply() server.Error {
+	s := hs.s
+	from, to := &s.history.undo, &s.history.redo
+	if hs.redo {
+		from, to = to, from
+	}
+	e := (*from)[len(*from)-1]
+	// a module change is reverted in the scene it has been made in, while a
+	// scene change is reverted by going back to the currently active scene.
+	sceneIndex := hs.Scene
+	if hs.Module == -1 {
+		sceneIndex = s.activeScene
+	}
+	inverse, err := s.checkpoint(sceneIndex, hs.Module)
+	if err != nil {
+		return &server.InternalError{
+			Description: "unable to capture state of module " +
+				s.a.ModuleID(hs.Module), Inner: err}
+	}
+	if hs.Module != -1 {
+		state, err := s.a.ModuleAt(hs.Module).CreateState(&e.state,
+			s.a.ServerContext(hs.Module), s.a.MessageSenderFor(hs.Module))
+		if err != nil {
+			return &server.InternalError{
+				Description: "unable to restore state of module " +
+					s.a.ModuleID(hs.Module), Inner: err}
+		}
+		s.scenes[hs.Scene][hs.Module] = state
+	}
+	*from = (*from)[:len(*from)-1]
+	pushEntry(to, inverse)
+	s.activeScene = hs.Scene
+	return nil
+}
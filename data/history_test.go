@@ -0,0 +1,116 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/QuestScreen/QuestScreen/app"
+	"github.com/QuestScreen/QuestScreen/shared"
+	"github.com/QuestScreen/api/modules"
+	"github.com/QuestScreen/api/server"
+	"gopkg.in/yaml.v3"
+)
+
+// testState is a module state that persists as its value.
+type testState struct {
+	value string
+}
+
+func (ts *testState) Send(ctx server.Context) interface{} {
+	return ts.value
+}
+
+func (ts *testState) Persist(ctx server.Context) interface{} {
+	return ts.value
+}
+
+func (ts *testState) CreateRendererData(ctx server.Context) interface{} {
+	return nil
+}
+
+// testApp implements the parts of app.App used by the history.
+type testApp struct {
+	app.App
+	modules []modules.Module
+}
+
+func (ta *testApp) ModuleAt(index shared.ModuleIndex) *modules.Module {
+	return &ta.modules[index]
+}
+
+func (ta *testApp) ModuleID(index shared.ModuleIndex) string {
+	return ta.modules[index].ID
+}
+
+func (ta *testApp) ServerContext(index shared.ModuleIndex) server.Context {
+	return nil
+}
+
+func (ta *testApp) MessageSenderFor(index shared.ModuleIndex) server.MessageSender {
+	return nil
+}
+
+func newTestModule(id string, created *[]string) modules.Module {
+	return modules.Module{ID: id, CreateState: func(input *yaml.Node,
+		ctx server.Context, ms server.MessageSender) (modules.State, error) {
+		var value string
+		if err := input.Decode(&value); err != nil {
+			return nil, err
+		}
+		*created = append(*created, id+":"+value)
+		return &testState{value: value}, nil
+	}}
+}
+
+func TestUndoSkipsDeletedScene(t *testing.T) {
+	var created []string
+	a := &testApp{modules: []modules.Module{
+		newTestModule("first", &created), newTestModule("second", &created)}}
+	g := &group{id: "g", scenes: []scene{
+		{id: "a", modules: []sceneModule{{enabled: true}, {enabled: true}}},
+		{id: "b", modules: []sceneModule{{enabled: true}, {enabled: true}}}}}
+	s := &State{a: a, group: g, history: &history{}, scenes: [][]modules.State{
+		{&testState{"a0"}, &testState{"a1"}},
+		{&testState{"b0"}, &testState{"b1"}}}}
+
+	// record A: change of the first module in scene a
+	cp, err := s.Checkpoint(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Record(cp)
+	// record B: change of the second module in scene b
+	s.activeScene = 1
+	if cp, err = s.Checkpoint(1); err != nil {
+		t.Fatal(err)
+	}
+	s.Record(cp)
+	// delete scene b
+	g.scenes = g.scenes[:1]
+	s.scenes = s.scenes[:1]
+	s.activeScene = 0
+
+	step, err := s.NextUndo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Scene != 0 || step.Module != 0 {
+		t.Fatalf("expected step to scene 0, module 0; got scene %d, module %d",
+			step.Scene, step.Module)
+	}
+	if err = step.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 1 || created[0] != "first:a0" {
+		t.Errorf("expected state first:a0 to be restored, got %v", created)
+	}
+	if len(s.history.undo) != 0 {
+		t.Errorf("expected empty undo stack, got %d entries",
+			len(s.history.undo))
+	}
+	if len(s.history.redo) != 1 || s.history.redo[0].sceneID != "a" {
+		t.Errorf("expected redo entry for scene a, got %v", s.history.redo)
+	}
+	if _, err = s.NextUndo(); err == nil {
+		t.Error("expected nothing to undo")
+	}
+}
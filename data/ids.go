@@ -29,9 +29,15 @@ type idCollection interface {
 	length() int
 }
 
+// reservingIDCollection is implemented by collections whose items must not use
+// certain IDs, since those would collide with other API paths.
+type reservingIDCollection interface {
+	reserved(id string) bool
+}
+
 // genID generates an ID from the given name, ensuring that it is unique within
-// the collection. If the normalized name is empty, baseName will be used
-// instead as a base.
+// the collection and not reserved by it. If the normalized name is empty,
+// baseName will be used instead as a base.
 func genID(name string, baseName string, collection idCollection) string {
 	base := strings.ToLower(normalize(name))
 	id := base
@@ -43,6 +49,11 @@ func genID(name string, baseName string, collection idCollection) string {
 	}
 idCheckLoop:
 	for {
+		if r, ok := collection.(reservingIDCollection); ok && r.reserved(id) {
+			num++
+			id = base + strconv.Itoa(num)
+			continue
+		}
 		for i := 0; i < collection.length(); i++ {
 			if collection.id(i) == id {
 				num++
@@ -79,6 +90,12 @@ func (g groupIDs) length() int {
 	return len(g.data)
 }
 
+// reserved returns true for "import", which is the path of the endpoint for
+// importing groups.
+func (g groupIDs) reserved(id string) bool {
+	return id == "import"
+}
+
 type sceneIDs struct {
 	data []scene
 }
@@ -91,6 +108,12 @@ func (s sceneIDs) length() int {
 	return len(s.data)
 }
 
+// reserved returns true for "order", which is the path of the endpoint for
+// reordering a group's scenes.
+func (s sceneIDs) reserved(id string) bool {
+	return id == "order"
+}
+
 type heroIDs struct {
 	data []hero
 }
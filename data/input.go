@@ -1,7 +1,11 @@
 package data
 
 import (
+	"fmt"
 	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
 )
 
 type inputProvider func() ([]byte, error)
@@ -17,3 +21,32 @@ func byteInput(input []byte) inputProvider {
 		return input, nil
 	}
 }
+
+// unmarshalPersisted loads the given input, which is a document of the given
+// kind, into target like strictUnmarshalYAML does. Documents persisted with an
+// older schema are migrated first. If loading fails and a backup of the file
+// at path exists, the backup is loaded instead and a warning is issued to the
+// user.
+func (p Persistence) unmarshalPersisted(kind documentKind,
+	input inputProvider, path string, target interface{}) error {
+	err := p.loadDocument(kind, input, path, target, true)
+	if err == nil {
+		return nil
+	}
+	backupPath := path + backupSuffix
+	if _, statErr := os.Stat(backupPath); statErr != nil {
+		return err
+	}
+	// discard anything that has been loaded from the broken input.
+	value := reflect.ValueOf(target).Elem()
+	value.Set(reflect.Zero(value.Type()))
+	if backupErr := p.loadDocument(kind,
+		fileInput(backupPath), backupPath, target, false); backupErr != nil {
+		return err
+	}
+	msg := fmt.Sprintf("%s could not be loaded, using previous version %s instead. error was: %s",
+		path, backupPath, err.Error())
+	log.Println(msg)
+	p.d.owner.MessageSenderFor(-1).Warning(msg)
+	return nil
+}
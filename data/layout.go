@@ -0,0 +1,199 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/QuestScreen/QuestScreen/shared"
+	"github.com/QuestScreen/api/groups"
+	"gopkg.in/yaml.v3"
+)
+
+// layoutsKey is the key in a module's config mapping that holds its layout
+// variants.
+const layoutsKey = "layouts"
+
+// relative tolerance when matching an aspect ratio against a single value.
+const aspectTolerance = 0.01
+
+// aspectRange is a range of aspect ratios (width / height). min is inclusive,
+// max is exclusive. A missing bound is 0 or +Inf, respectively.
+type aspectRange struct {
+	min, max float64
+	source   string
+}
+
+func (ar aspectRange) contains(aspect float64) bool {
+	return aspect >= ar.min && aspect < ar.max
+}
+
+// parseAspect parses an aspect ratio given as "W:H" or as decimal number.
+func parseAspect(input string) (float64, error) {
+	input = strings.TrimSpace(input)
+	var ret float64
+	if colon := strings.IndexByte(input, ':'); colon != -1 {
+		w, err := strconv.ParseFloat(strings.TrimSpace(input[:colon]), 64)
+		if err != nil {
+			return 0, err
+		}
+		h, err := strconv.ParseFloat(strings.TrimSpace(input[colon+1:]), 64)
+		if err != nil {
+			return 0, err
+		}
+		if h <= 0 {
+			return 0, errors.New("height must be positive")
+		}
+		ret = w / h
+	} else {
+		var err error
+		if ret, err = strconv.ParseFloat(input, 64); err != nil {
+			return 0, err
+		}
+	}
+	if ret <= 0 || math.IsInf(ret, 0) || math.IsNaN(ret) {
+		return 0, errors.New("aspect ratio must be positive")
+	}
+	return ret, nil
+}
+
+// parseAspectRange parses a range of aspect ratios like "4:3-16:9". Either
+// bound may be omitted ("-1:1" matches all portrait outputs). A single value
+// like "4:3" matches that aspect ratio.
+func parseAspectRange(input string) (aspectRange, error) {
+	ret := aspectRange{min: 0, max: math.Inf(1), source: input}
+	dash := strings.IndexByte(input, '-')
+	if dash == -1 {
+		value, err := parseAspect(input)
+		if err != nil {
+			return ret, fmt.Errorf("invalid aspect ratio \"%s\": %s", input,
+				err.Error())
+		}
+		ret.min = value * (1 - aspectTolerance)
+		ret.max = value * (1 + aspectTolerance)
+		return ret, nil
+	}
+	if lower := strings.TrimSpace(input[:dash]); lower != "" {
+		var err error
+		if ret.min, err = parseAspect(lower); err != nil {
+			return ret, fmt.Errorf("invalid lower bound in \"%s\": %s", input,
+				err.Error())
+		}
+	}
+	if upper := strings.TrimSpace(input[dash+1:]); upper != "" {
+		var err error
+		if ret.max, err = parseAspect(upper); err != nil {
+			return ret, fmt.Errorf("invalid upper bound in \"%s\": %s", input,
+				err.Error())
+		}
+	}
+	if ret.min >= ret.max {
+		return ret, fmt.Errorf("empty aspect ratio range \"%s\"", input)
+	}
+	return ret, nil
+}
+
+// layoutVariant is a module config whose values take precedence over the
+// values of the config it is defined in if the aspect ratio of the output the
+// module is rendered to lies within the variant's range.
+//
+// Layout variants are only defined in the YAML files; the web client edits the
+// plain values. They are written back unchanged when the config is persisted.
+type layoutVariant struct {
+	aspect aspectRange
+	config interface{}
+}
+
+type persistedLayout struct {
+	Aspect string
+	Config map[string]yaml.Node
+}
+
+type persistingLayout struct {
+	Aspect string
+	Config map[string]interface{} `yaml:",omitempty"`
+}
+
+// loadLayouts loads the layout variants of a module from the given node, which
+// must be a sequence of persistedLayout values. Invalid variants are logged
+// and skipped.
+func (p Persistence) loadLayouts(heroes groups.HeroList,
+	moduleIndex shared.ModuleIndex, node *yaml.Node,
+	path string) []layoutVariant {
+	mod := p.d.owner.ModuleAt(moduleIndex)
+	var raw []persistedLayout
+	if err := node.Decode(&raw); err != nil {
+		log.Printf("%s [module %s] invalid layouts: %s\n", path, mod.ID,
+			err.Error())
+		return nil
+	}
+	var ret []layoutVariant
+	for i := range raw {
+		aspect, err := parseAspectRange(raw[i].Aspect)
+		if err != nil {
+			log.Printf("%s [module %s] layout %d: %s\n", path, mod.ID, i+1,
+				err.Error())
+			continue
+		}
+		target := reflect.New(configType(mod)).Interface()
+		if raw[i].Config == nil {
+			raw[i].Config = make(map[string]yaml.Node)
+		}
+		if p.loadModuleConfigInto(heroes, moduleIndex, target, raw[i].Config,
+			path, mod.ID) {
+			ret = append(ret, layoutVariant{aspect: aspect, config: target})
+		}
+	}
+	return ret
+}
+
+// takeLayouts removes the layout variants from the given module config values
+// and loads them.
+func (p Persistence) takeLayouts(heroes groups.HeroList,
+	moduleIndex shared.ModuleIndex, values map[string]yaml.Node,
+	path string) []layoutVariant {
+	node, ok := values[layoutsKey]
+	if !ok {
+		return nil
+	}
+	delete(values, layoutsKey)
+	return p.loadLayouts(heroes, moduleIndex, &node, path)
+}
+
+func (p Persistence) persistingLayouts(moduleIndex shared.ModuleIndex,
+	variants []layoutVariant) []persistingLayout {
+	if len(variants) == 0 {
+		return nil
+	}
+	ret := make([]persistingLayout, len(variants))
+	for i := range variants {
+		ret[i] = persistingLayout{Aspect: variants[i].aspect.source,
+			Config: p.persistingModuleConfig(moduleIndex, variants[i].config)}
+	}
+	return ret
+}
+
+// matchingLayout returns the config of the first variant matching the given
+// aspect ratio, or nil if there is none.
+func matchingLayout(variants []layoutVariant, aspect float64) interface{} {
+	for i := range variants {
+		if variants[i].aspect.contains(aspect) {
+			return variants[i].config
+		}
+	}
+	return nil
+}
+
+// moduleLayout returns the matching config of the given module from a list of
+// variants indexed by module, which may be nil.
+func moduleLayout(layouts [][]layoutVariant, moduleIndex shared.ModuleIndex,
+	aspect float64) interface{} {
+	if layouts == nil {
+		return nil
+	}
+	return matchingLayout(layouts[moduleIndex], aspect)
+}
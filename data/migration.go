@@ -0,0 +1,314 @@
+package data
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/QuestScreen/QuestScreen/app"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentSchemaVersion is the version of the structure of the files in the
+// data directory as written by this version of QuestScreen. Files without a
+// version are at version 1.
+const CurrentSchemaVersion = 1
+
+// templatePath is given as path when loading a plugin's template. Templates
+// are never migrated since they are part of the plugin.
+const templatePath = "<template>"
+
+// clonePath is given as path when loading a copy of existing data. The copy
+// has just been serialized and is thus always up to date.
+const clonePath = "<clone>"
+
+// documentKind identifies the kind of a persisted file.
+type documentKind int
+
+const (
+	baseDocument documentKind = iota
+	systemDocument
+	groupDocument
+	sceneDocument
+	heroDocument
+	groupStateDocument
+)
+
+// schemaVersions holds the schema versions a persisted file has been written
+// with. It is inlined into the structure of each persisted file.
+type schemaVersions struct {
+	Version int `yaml:"version"`
+	// plugin ID -> schema version of the plugin's module configs and states.
+	// only given in files containing module configs or states.
+	PluginVersions map[string]int `yaml:"pluginVersions,omitempty"`
+}
+
+// migration upgrades a document of a certain kind from one schema version to
+// the next. The document's root mapping is modified in place.
+type migration struct {
+	kind  documentKind
+	from  int
+	apply func(root *yaml.Node) error
+}
+
+// migrations is the registry of migration steps of the core data structure.
+// Whenever CurrentSchemaVersion is increased, a step from the previous version
+// must be added for each kind of document.
+var migrations []migration
+
+func findMigration(kind documentKind, from int) *migration {
+	for i := range migrations {
+		if migrations[i].kind == kind && migrations[i].from == from {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
+
+func pluginSchemaVersion(plugin *app.Plugin) int {
+	if plugin.SchemaVersion == 0 {
+		return 1
+	}
+	return plugin.SchemaVersion
+}
+
+// currentVersions returns the schema versions to write into a file. Plugin
+// versions are only given if withPlugins is true.
+func currentVersions(owner app.App, withPlugins bool) schemaVersions {
+	ret := schemaVersions{Version: CurrentSchemaVersion}
+	if withPlugins {
+		ret.PluginVersions = make(map[string]int)
+		for i := 0; i < owner.NumPlugins(); i++ {
+			ret.PluginVersions[owner.PluginID(i)] =
+				pluginSchemaVersion(owner.Plugin(i))
+		}
+	}
+	return ret
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// mappingValues calls fn for each value of the given mapping node.
+func mappingValues(node *yaml.Node, fn func(value *yaml.Node) error) error {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if err := fn(node.Content[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateModuleConfig applies fn to the given module config and to the configs
+// of its layout variants. The layout variants are hidden from fn.
+func migrateModuleConfig(config *yaml.Node, layouts *yaml.Node,
+	fn func(node *yaml.Node) error) error {
+	if config != nil && config.Kind == yaml.MappingNode {
+		var layoutsPair []*yaml.Node
+		for i := 0; i+1 < len(config.Content); i += 2 {
+			if config.Content[i].Value == layoutsKey {
+				layoutsPair = config.Content[i : i+2 : i+2]
+				layouts = config.Content[i+1]
+				config.Content = append(config.Content[:i:i], config.Content[i+2:]...)
+				break
+			}
+		}
+		err := fn(config)
+		config.Content = append(config.Content, layoutsPair...)
+		if err != nil {
+			return err
+		}
+	}
+	if layouts != nil && layouts.Kind == yaml.SequenceNode {
+		for _, variant := range layouts.Content {
+			if c := mappingValue(variant, "config"); c != nil {
+				if err := fn(c); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// migrateModule applies the given migration of a plugin to all configs and
+// states of the module with the given ID in the document.
+func migrateModule(kind documentKind, root *yaml.Node, moduleID string,
+	m *app.Migration) error {
+	switch kind {
+	case baseDocument, systemDocument, groupDocument:
+		if m.Config != nil {
+			return migrateModuleConfig(mappingValue(
+				mappingValue(root, "modules"), moduleID), nil, m.Config)
+		}
+	case sceneDocument:
+		if m.Config != nil {
+			module := mappingValue(mappingValue(root, "modules"), moduleID)
+			return migrateModuleConfig(mappingValue(module, "config"),
+				mappingValue(module, layoutsKey), m.Config)
+		}
+	case groupStateDocument:
+		if m.State != nil {
+			return mappingValues(mappingValue(root, "scenes"),
+				func(scene *yaml.Node) error {
+					if state := mappingValue(scene, moduleID); state != nil {
+						return m.State(state)
+					}
+					return nil
+				})
+		}
+	}
+	return nil
+}
+
+// migrate upgrades the given document to the current schema versions.
+// Returns the upgraded document and whether it has been changed.
+func (p Persistence) migrate(kind documentKind, raw []byte) ([]byte, bool,
+	error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, false, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		// nothing to migrate; errors will be reported when loading.
+		return raw, false, nil
+	}
+	root := doc.Content[0]
+	var versions schemaVersions
+	if err := root.Decode(&versions); err != nil {
+		return nil, false, err
+	}
+	changed := false
+
+	version := versions.Version
+	if version == 0 {
+		version = 1
+	}
+	if version > CurrentSchemaVersion {
+		return nil, false, fmt.Errorf(
+			"written by a newer version of QuestScreen (schema version %d, supported: %d)",
+			version, CurrentSchemaVersion)
+	}
+	for ; version < CurrentSchemaVersion; version++ {
+		m := findMigration(kind, version)
+		if m == nil {
+			return nil, false, fmt.Errorf("no migration from schema version %d",
+				version)
+		}
+		if err := m.apply(root); err != nil {
+			return nil, false, fmt.Errorf(
+				"while migrating from schema version %d: %s", version, err.Error())
+		}
+		changed = true
+	}
+
+	withPlugins := kind != heroDocument
+	if withPlugins {
+		for i := 0; i < p.d.owner.NumPlugins(); i++ {
+			plugin := p.d.owner.Plugin(i)
+			pluginID := p.d.owner.PluginID(i)
+			current := pluginSchemaVersion(plugin)
+			version := versions.PluginVersions[pluginID]
+			if version == 0 {
+				version = 1
+			}
+			if version > current {
+				return nil, false, fmt.Errorf(
+					"written by a newer version of plugin %s (schema version %d, supported: %d)",
+					pluginID, version, current)
+			}
+			for ; version < current; version++ {
+				for j := range plugin.Migrations {
+					m := &plugin.Migrations[j]
+					if m.From != version {
+						continue
+					}
+					if err := migrateModule(kind, root, pluginID+"."+m.Module,
+						m); err != nil {
+						return nil, false, fmt.Errorf(
+							"while migrating module %s.%s from schema version %d: %s",
+							pluginID, m.Module, version, err.Error())
+					}
+				}
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return raw, false, nil
+	}
+
+	current := currentVersions(p.d.owner, withPlugins)
+	setMappingValue(root, "version", &yaml.Node{Kind: yaml.ScalarNode,
+		Tag: "!!int", Value: strconv.Itoa(current.Version)})
+	if withPlugins {
+		var pluginVersions yaml.Node
+		if err := pluginVersions.Encode(current.PluginVersions); err != nil {
+			return nil, false, err
+		}
+		setMappingValue(root, "pluginVersions", &pluginVersions)
+	}
+	ret, err := yaml.Marshal(&doc)
+	return ret, true, err
+}
+
+// loadDocument loads the given input into target after migrating it to the
+// current schema versions. If the document at path has been migrated and
+// persist is true, the original document is kept as backup and the migrated
+// document is written to path.
+func (p Persistence) loadDocument(kind documentKind, input inputProvider,
+	path string, target interface{}, persist bool) error {
+	raw, err := input()
+	if err != nil {
+		return err
+	}
+	if path != templatePath && path != clonePath {
+		migrated, changed, err := p.migrate(kind, raw)
+		if err != nil {
+			return err
+		}
+		if changed && persist {
+			var versions schemaVersions
+			yaml.Unmarshal(raw, &versions)
+			if versions.Version == 0 {
+				versions.Version = 1
+			}
+			backupPath := fmt.Sprintf("%s.v%d%s", path, versions.Version,
+				backupSuffix)
+			if err := writeFile(backupPath, raw); err != nil {
+				return fmt.Errorf("unable to back up before migration: %s",
+					err.Error())
+			}
+			if err := writeFile(path, migrated); err != nil {
+				return err
+			}
+			log.Printf("%s: migrated to current schema, original kept as %s\n",
+				path, backupPath)
+		}
+		raw = migrated
+	}
+	return strictUnmarshalYAML(byteInput(raw), target)
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// backupSuffix is appended to the path of a persisted file to get the path of
+// its previous version.
+const backupSuffix = ".bak"
+
+// writeFile replaces the content of the file at path with the given content.
+// The content is written to a temporary file which is synced and then renamed
+// to path so that the file is never left in a partially written state. The
+// previous version of the file, if any, is kept as backup.
+func writeFile(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0644)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Rename(path, path+backupSuffix); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes sure that renames inside the given directory are persisted.
+// This is not supported on all platforms, so errors are ignored.
+func syncDir(path string) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	_ = dir.Sync()
+	dir.Close()
+}
@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -29,11 +30,13 @@ type Persistence struct {
 }
 
 type persistedBaseConfig struct {
-	Modules map[string]map[string]yaml.Node
+	schemaVersions `yaml:",inline"`
+	Modules        map[string]map[string]yaml.Node
 }
 
 type persistingBaseConfig struct {
-	Modules map[string]map[string]interface{}
+	schemaVersions `yaml:",inline"`
+	Modules        map[string]map[string]interface{}
 }
 
 // persistedSystem is the structure system configuration
@@ -42,52 +45,112 @@ type persistingBaseConfig struct {
 // keys in YAML mappings rather than using a list which
 // maps config items by position to a module / setting.
 type persistedSystem struct {
-	Name string
+	schemaVersions `yaml:",inline"`
+	Name           string
 	// module name -> (setting name -> value)
-	Modules map[string]map[string]yaml.Node
+	Modules        map[string]map[string]yaml.Node
+	HeroAttributes []persistedHeroAttribute `yaml:"heroAttributes"`
 }
 
 type persistingSystem struct {
-	Name    string
-	Modules map[string]map[string]interface{}
+	schemaVersions `yaml:",inline"`
+	Name           string
+	Modules        map[string]map[string]interface{}
+	HeroAttributes []persistedHeroAttribute `yaml:"heroAttributes,omitempty"`
+}
+
+// persistedHeroAttribute is the YAML structure of a heroAttribute.
+type persistedHeroAttribute struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
 }
 
 // persistedGroup is yamlSystem for groups
 type persistedGroup struct {
-	Name    string
-	System  string
-	Modules map[string]map[string]yaml.Node
+	schemaVersions `yaml:",inline"`
+	Name           string
+	System         string
+	Transition     *persistedSceneTransition
+	Modules        map[string]map[string]yaml.Node
+	// IDs of the group's scenes in the order in which they are listed.
+	Scenes []string
 }
 
 type persistingGroup struct {
-	Name    string
-	System  string
-	Modules map[string]map[string]interface{}
+	schemaVersions `yaml:",inline"`
+	Name           string
+	System         string
+	Transition     *persistedSceneTransition `yaml:",omitempty"`
+	Modules        map[string]map[string]interface{}
+	Scenes         []string `yaml:",omitempty"`
+}
+
+// persistedSceneTransition is the YAML structure of a shared.SceneTransition.
+type persistedSceneTransition struct {
+	Style    string
+	Duration time.Duration
 }
 
 type yamlHero struct {
-	Name        string
-	Description string
+	schemaVersions `yaml:",inline"`
+	Name           string
+	Description    string
+	// file name of the portrait inside the hero's directory.
+	Portrait string `yaml:",omitempty"`
+	// attribute ID -> value
+	Attributes map[string]string `yaml:",omitempty"`
 }
 
 type persistedSceneModule struct {
 	Enabled bool
 	Config  map[string]yaml.Node
+	Layouts yaml.Node
 }
 
 type persistedScene struct {
-	Name    string
-	Modules map[string]persistedSceneModule
+	schemaVersions `yaml:",inline"`
+	Name           string
+	Transition     *persistedSceneTransition
+	Modules        map[string]persistedSceneModule
 }
 
 type persistingSceneModule struct {
 	Enabled bool
-	Config  interface{} // will be serialized to mapping since config must be a struct.
+	// will be serialized to mapping since config must be a struct.
+	Config  interface{}
+	Layouts []persistingLayout `yaml:",omitempty"`
 }
 
 type persistingScene struct {
-	Name    string
-	Modules map[string]persistingSceneModule
+	schemaVersions `yaml:",inline"`
+	Name           string
+	Transition     *persistedSceneTransition `yaml:",omitempty"`
+	Modules        map[string]persistingSceneModule
+}
+
+func loadSceneTransition(
+	value *persistedSceneTransition) (*shared.SceneTransition, error) {
+	if value == nil {
+		return nil, nil
+	}
+	style, err := shared.ParseSceneTransitionStyle(value.Style)
+	if err != nil {
+		return nil, err
+	}
+	if value.Duration < 0 {
+		return nil, fmt.Errorf("negative scene transition duration %v",
+			value.Duration)
+	}
+	return &shared.SceneTransition{Style: style, Duration: value.Duration}, nil
+}
+
+func persistingSceneTransition(
+	value *shared.SceneTransition) *persistedSceneTransition {
+	if value == nil {
+		return nil
+	}
+	return &persistedSceneTransition{Style: value.Style.String(),
+		Duration: value.Duration}
 }
 
 func yamlName(f reflect.StructField) string {
@@ -162,8 +225,10 @@ func configType(mod *modules.Module) reflect.Type {
 }
 
 func (p Persistence) loadModuleConfigs(heroes groups.HeroList,
-	raw map[string]map[string]yaml.Node, path string) ([]interface{}, error) {
+	raw map[string]map[string]yaml.Node, path string) ([]interface{},
+	[][]layoutVariant, error) {
 	ret := make([]interface{}, p.d.owner.NumModules())
+	layouts := make([][]layoutVariant, p.d.owner.NumModules())
 	unknowns := ""
 	for name, rawItems := range raw {
 		mod, index := findModule(p.d.owner, name)
@@ -175,6 +240,7 @@ func (p Persistence) loadModuleConfigs(heroes groups.HeroList,
 			continue
 		}
 
+		layouts[index] = p.takeLayouts(heroes, index, rawItems, path)
 		target := reflect.New(configType(mod)).Interface()
 		if p.loadModuleConfigInto(heroes, index, target, rawItems, path, mod.ID) {
 			ret[index] = target
@@ -187,41 +253,56 @@ func (p Persistence) loadModuleConfigs(heroes groups.HeroList,
 		}
 	}
 	if len(unknowns) > 0 {
-		return ret, errors.New("Unknown module(s): " + unknowns)
+		return ret, layouts, errors.New("Unknown module(s): " + unknowns)
 	}
-	return ret, nil
+	return ret, layouts, nil
+}
+
+// persistingModuleConfig returns the values of the given module config that
+// are set, mapped by their YAML names. Returns nil if no value is set.
+func (p Persistence) persistingModuleConfig(moduleIndex shared.ModuleIndex,
+	moduleConfig interface{}) map[string]interface{} {
+	var fields map[string]interface{}
+	value := reflect.ValueOf(moduleConfig)
+	valueType := reflect.TypeOf(moduleConfig)
+	for valueType.Kind() == reflect.Interface ||
+		valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+		value = value.Elem()
+	}
+	if valueType.Kind() != reflect.Struct || value.Kind() != reflect.Struct {
+		panic("value type is not a struct!")
+	}
+	for j := 0; j < valueType.NumField(); j++ {
+		fieldName := yamlName(valueType.Field(j))
+		if fieldName == "-" {
+			continue
+		}
+		fieldVal := value.Field(j)
+		if !fieldVal.IsNil() {
+			if fields == nil {
+				fields = make(map[string]interface{})
+			}
+			fields[fieldName] =
+				fieldVal.Interface().(config.Item).Persist(
+					p.d.owner.ServerContext(moduleIndex))
+		}
+	}
+	return fields
 }
 
 func (p Persistence) persistingModuleConfigs(heroes groups.HeroList,
-	moduleConfigs []interface{}) map[string]map[string]interface{} {
+	moduleConfigs []interface{},
+	layouts [][]layoutVariant) map[string]map[string]interface{} {
 	ret := make(map[string]map[string]interface{})
 	for i := shared.FirstModule; i < p.d.owner.NumModules(); i++ {
-		var fields map[string]interface{}
-
-		moduleConfig := moduleConfigs[i]
-		value := reflect.ValueOf(moduleConfig)
-		valueType := reflect.TypeOf(moduleConfig)
-		for valueType.Kind() == reflect.Interface ||
-			valueType.Kind() == reflect.Ptr {
-			valueType = valueType.Elem()
-			value = value.Elem()
-		}
-		if valueType.Kind() != reflect.Struct || value.Kind() != reflect.Struct {
-			panic("value type is not a struct!")
-		}
-		for j := 0; j < valueType.NumField(); j++ {
-			fieldName := yamlName(valueType.Field(j))
-			if fieldName == "-" {
-				continue
-			}
-			fieldVal := value.Field(j)
-			if !fieldVal.IsNil() {
+		fields := p.persistingModuleConfig(i, moduleConfigs[i])
+		if layouts != nil {
+			if variants := p.persistingLayouts(i, layouts[i]); variants != nil {
 				if fields == nil {
 					fields = make(map[string]interface{})
 				}
-				fields[fieldName] =
-					fieldVal.Interface().(config.Item).Persist(
-						p.d.owner.ServerContext(i))
+				fields[layoutsKey] = variants
 			}
 		}
 		if fields != nil {
@@ -244,19 +325,25 @@ func strictUnmarshalYAML(input inputProvider, target interface{}) error {
 func (p Persistence) loadBase(path string) ([]interface{}, error) {
 	var data persistedBaseConfig
 	if len(path) != 0 {
-		if err := strictUnmarshalYAML(fileInput(path), &data); err != nil {
+		if err := p.unmarshalPersisted(
+			baseDocument, fileInput(path), path, &data); err != nil {
 			data.Modules = make(map[string]map[string]yaml.Node)
 		}
 	} else {
 		data.Modules = make(map[string]map[string]yaml.Node)
 	}
 
-	return p.loadModuleConfigs(nil, data.Modules, path)
+	configs, layouts, err := p.loadModuleConfigs(nil, data.Modules, path)
+	p.d.baseLayouts = layouts
+	return configs, err
 }
 
 // WriteBase writes the current base configuration to the file system.
 func (p Persistence) WriteBase() error {
-	data := persistingBaseConfig{Modules: p.persistingModuleConfigs(nil, p.d.baseConfigs)}
+	data := persistingBaseConfig{
+		schemaVersions: currentVersions(p.d.owner, true),
+		Modules: p.persistingModuleConfigs(nil, p.d.baseConfigs,
+			p.d.baseLayouts)}
 	dirPath := p.d.owner.DataDir("base")
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
 		return err
@@ -266,43 +353,80 @@ func (p Persistence) WriteBase() error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(path, raw, 0644)
+	return writeFile(path, raw)
 }
 
 func (p Persistence) loadSystem(
 	id string, input inputProvider, path string) (*system, error) {
 	var data persistedSystem
-	if err := strictUnmarshalYAML(input, &data); err != nil {
+	if err := p.unmarshalPersisted(systemDocument, input, path, &data); err != nil {
 		return nil, err
 	}
-	moduleConfigs, err := p.loadModuleConfigs(nil, data.Modules, path)
+	attributes, err := loadHeroAttributes(data.HeroAttributes)
+	if err != nil {
+		return nil, err
+	}
+	moduleConfigs, layouts, err := p.loadModuleConfigs(nil, data.Modules, path)
 	return &system{
-		name:    data.Name,
-		id:      id,
-		modules: moduleConfigs}, err
+		name:           data.Name,
+		id:             id,
+		modules:        moduleConfigs,
+		layouts:        layouts,
+		heroAttributes: attributes}, err
+}
+
+func loadHeroAttributes(data []persistedHeroAttribute) ([]heroAttribute,
+	error) {
+	ret := make([]heroAttribute, len(data))
+	for i := range data {
+		if data[i].ID == "" {
+			return nil, fmt.Errorf("hero attribute #%d has no id", i+1)
+		}
+		for j := 0; j < i; j++ {
+			if ret[j].id == data[i].ID {
+				return nil, fmt.Errorf("duplicate hero attribute id \"%s\"",
+					data[i].ID)
+			}
+		}
+		ret[i] = heroAttribute{id: data[i].ID, name: data[i].Name}
+	}
+	return ret, nil
+}
+
+func (p Persistence) systemYAML(value *system) ([]byte, error) {
+	return yaml.Marshal(persistingSystem{
+		schemaVersions: currentVersions(p.d.owner, true),
+		Name:           value.name,
+		Modules:        p.persistingModuleConfigs(nil, value.modules, value.layouts),
+		HeroAttributes: persistingHeroAttributes(value.heroAttributes),
+	})
+}
+
+func persistingHeroAttributes(value []heroAttribute) []persistedHeroAttribute {
+	ret := make([]persistedHeroAttribute, len(value))
+	for i := range value {
+		ret[i] = persistedHeroAttribute{ID: value[i].id, Name: value[i].name}
+	}
+	return ret
 }
 
 // WriteSystem writes the given system to the file system.
 func (p Persistence) WriteSystem(s System) error {
 	value := s.(*system)
-	data := persistingSystem{
-		Name:    value.name,
-		Modules: p.persistingModuleConfigs(nil, value.modules),
-	}
 	dirPath := p.d.owner.DataDir("systems", value.id)
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
 		return err
 	}
 	path := filepath.Join(dirPath, "config.yaml")
-	raw, err := yaml.Marshal(data)
+	raw, err := p.systemYAML(value)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(path, raw, 0644)
+	return writeFile(path, raw)
 }
 
 func (p Persistence) createSystem(tmpl *app.SystemTemplate) (*system, error) {
-	s, err := p.loadSystem(tmpl.ID, byteInput(tmpl.Config), "<template>")
+	s, err := p.loadSystem(tmpl.ID, byteInput(tmpl.Config), templatePath)
 	if err != nil {
 		return nil, err
 	}
@@ -364,11 +488,14 @@ func (p Persistence) DeleteSystem(index int) server.Error {
 	return nil
 }
 
+// loadGroup loads the group config from the given input. It does not load
+// the group's scenes; instead, it returns the IDs of the scenes in the order
+// given in the config.
 func (p Persistence) loadGroup(heroes []hero, id string,
-	input inputProvider, path string) (*group, error) {
+	input inputProvider, path string) (*group, []string, error) {
 	var data persistedGroup
-	if err := strictUnmarshalYAML(input, &data); err != nil {
-		return nil, err
+	if err := p.unmarshalPersisted(groupDocument, input, path, &data); err != nil {
+		return nil, nil, err
 	}
 	systemIndex := -1
 	if data.System != "" {
@@ -379,43 +506,63 @@ func (p Persistence) loadGroup(heroes []hero, id string,
 			}
 		}
 		if systemIndex == -1 {
-			return nil,
+			return nil, nil,
 				fmt.Errorf("Group config references unknown system \"%s\"", data.System)
 		}
 	}
+	transition, err := loadSceneTransition(data.Transition)
+	if err != nil {
+		return nil, nil, err
+	}
 	ret := &group{
 		name:        data.Name,
 		id:          id,
 		systemIndex: systemIndex,
-		heroes:      heroList{data: heroes},
+		heroes:      heroList{data: heroes, d: p.d},
+		transition:  transition,
+	}
+	ret.heroes.group = ret
+	for i := range ret.heroes.data {
+		ret.heroes.data[i].list = &ret.heroes
 	}
 
-	moduleConfigs, err := p.loadModuleConfigs(&ret.heroes, data.Modules, path)
+	moduleConfigs, layouts, err := p.loadModuleConfigs(&ret.heroes, data.Modules, path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	ret.modules = moduleConfigs
-	return ret, nil
+	ret.layouts = layouts
+	return ret, data.Scenes, nil
 }
 
-func (p Persistence) writeGroup(value *group) error {
+func (p Persistence) groupYAML(value *group) ([]byte, error) {
 	data := persistingGroup{
-		Name:    value.name,
-		Modules: p.persistingModuleConfigs(nil, value.modules),
+		schemaVersions: currentVersions(p.d.owner, true),
+		Name:           value.name,
+		Transition:     persistingSceneTransition(value.transition),
+		Modules:        p.persistingModuleConfigs(nil, value.modules, value.layouts),
+		Scenes:         make([]string, len(value.scenes)),
+	}
+	for i := range value.scenes {
+		data.Scenes[i] = value.scenes[i].id
 	}
 	if value.systemIndex != -1 {
 		data.System = p.d.systems[value.systemIndex].id
 	}
+	return yaml.Marshal(data)
+}
+
+func (p Persistence) writeGroup(value *group) error {
 	dirPath := p.d.owner.DataDir("groups", value.id)
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
 		return err
 	}
 	path := filepath.Join(dirPath, "config.yaml")
-	raw, err := yaml.Marshal(data)
+	raw, err := p.groupYAML(value)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(path, raw, 0644)
+	return writeFile(path, raw)
 }
 
 // WriteGroup writes the group config to the file system.
@@ -433,7 +580,7 @@ func (p Persistence) CreateGroup(
 		return errors.New("missing group template")
 	}
 	id := genID(name, "group", groupIDs{p.d.groups})
-	g, err := p.loadGroup(nil, id, byteInput(tmpl.Config), "<template>")
+	g, _, err := p.loadGroup(nil, id, byteInput(tmpl.Config), templatePath)
 	if err != nil {
 		return errors.New("could not load group config template:\n  " + err.Error())
 	}
@@ -465,6 +612,7 @@ func (p Persistence) DeleteGroup(index int) {
 	if err := os.RemoveAll(path); err != nil {
 		log.Printf("[del group] while deleting %s\n  %s\n", path, err.Error())
 	}
+	delete(p.d.histories, g.id)
 	copy(p.d.groups[index:], p.d.groups[index+1:])
 	p.d.groups[len(p.d.groups)-1] = nil
 	p.d.groups = p.d.groups[:len(p.d.groups)-1]
@@ -473,11 +621,16 @@ func (p Persistence) DeleteGroup(index int) {
 func (p Persistence) loadScene(heroes groups.HeroList, id string,
 	input inputProvider, path string) (scene, error) {
 	var data persistedScene
-	if err := strictUnmarshalYAML(input, &data); err != nil {
+	if err := p.unmarshalPersisted(sceneDocument, input, path, &data); err != nil {
+		return scene{}, err
+	}
+	transition, err := loadSceneTransition(data.Transition)
+	if err != nil {
 		return scene{}, err
 	}
 	ret := scene{name: data.Name, id: id,
-		modules: make([]sceneModule, p.d.owner.NumModules())}
+		modules:    make([]sceneModule, p.d.owner.NumModules()),
+		transition: transition}
 	for name, value := range data.Modules {
 		mod, index := findModule(p.d.owner, name)
 		if mod == nil {
@@ -486,29 +639,40 @@ func (p Persistence) loadScene(heroes groups.HeroList, id string,
 		target := reflect.New(configType(mod)).Interface()
 		if p.loadModuleConfigInto(heroes, index, target, value.Config, path, mod.ID) {
 			ret.modules[index] = sceneModule{enabled: value.Enabled, config: target}
+			if value.Layouts.Kind != 0 {
+				ret.modules[index].layouts = p.loadLayouts(
+					heroes, index, &value.Layouts, path)
+			}
 		}
 	}
 	return ret, nil
 }
 
-func (p Persistence) writeScene(g *group, value *scene) error {
+func (p Persistence) sceneYAML(value *scene) ([]byte, error) {
 	data := persistingScene{
-		Name: value.name, Modules: make(map[string]persistingSceneModule)}
+		schemaVersions: currentVersions(p.d.owner, true),
+		Name:           value.name, Modules: make(map[string]persistingSceneModule),
+		Transition: persistingSceneTransition(value.transition)}
 	for i := shared.FirstModule; i < p.d.owner.NumModules(); i++ {
 		moduleData := &value.modules[i]
 		data.Modules[p.d.owner.ModuleID(i)] = persistingSceneModule{
-			Enabled: moduleData.enabled, Config: moduleData.config}
+			Enabled: moduleData.enabled, Config: moduleData.config,
+			Layouts: p.persistingLayouts(i, moduleData.layouts)}
 	}
+	return yaml.Marshal(data)
+}
+
+func (p Persistence) writeScene(g *group, value *scene) error {
 	dirPath := p.d.owner.DataDir("groups", g.id, "scenes", value.id)
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
 		return err
 	}
 	path := filepath.Join(dirPath, "config.yaml")
-	raw, err := yaml.Marshal(data)
+	raw, err := p.sceneYAML(value)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(path, raw, 0644)
+	return writeFile(path, raw)
 }
 
 // WriteScene writes the given scene of the given group to the file system.
@@ -521,7 +685,7 @@ func (p Persistence) CreateScene(g Group, name string, tmpl *app.SceneTemplate)
 	gr := g.(*group)
 	id := genID(name, "scene", sceneIDs{gr.scenes})
 	heroes := g.Heroes()
-	s, err := p.loadScene(heroes, id, byteInput(tmpl.Config), "<template>")
+	s, err := p.loadScene(heroes, id, byteInput(tmpl.Config), templatePath)
 	if err != nil {
 		return err
 	}
@@ -530,7 +694,7 @@ func (p Persistence) CreateScene(g Group, name string, tmpl *app.SceneTemplate)
 		return err
 	}
 	gr.scenes = append(gr.scenes, s)
-	return nil
+	return p.writeGroup(gr)
 }
 
 // DeleteScene deletes the scene with the given id from the given group.
@@ -547,7 +711,54 @@ func (p Persistence) DeleteScene(g Group, index int) error {
 	copy(gr.scenes[index:], gr.scenes[index+1:])
 	gr.scenes[len(gr.scenes)-1].modules = nil
 	gr.scenes = gr.scenes[:len(gr.scenes)-1]
-	return nil
+	return p.writeGroup(gr)
+}
+
+// ReorderScenes sorts the scenes of the given group according to the given
+// list of scene IDs, which must contain each scene of the group exactly once.
+// If the group's state is loaded, it is reordered accordingly.
+//
+// Returns the new index of each scene, indexed by its previous index.
+func (p Persistence) ReorderScenes(g Group, order []string) ([]int,
+	server.Error) {
+	gr := g.(*group)
+	if len(order) != len(gr.scenes) {
+		return nil, &server.BadRequest{Message: fmt.Sprintf(
+			"expected %d scene IDs, got %d", len(gr.scenes), len(order))}
+	}
+	moved := make([]int, len(gr.scenes))
+	for i := range moved {
+		moved[i] = -1
+	}
+	for i, id := range order {
+		index, s := g.SceneByID(id)
+		if s == nil {
+			return nil, &server.NotFound{Name: id}
+		}
+		if moved[index] != -1 {
+			return nil, &server.BadRequest{Message: "duplicate scene ID: " + id}
+		}
+		moved[index] = i
+	}
+	scenes := make([]scene, len(gr.scenes))
+	for i := range gr.scenes {
+		scenes[moved[i]] = gr.scenes[i]
+	}
+	gr.scenes = scenes
+	if p.d.State.group == g {
+		states := make([][]modules.State, len(p.d.State.scenes))
+		for i := range p.d.State.scenes {
+			states[moved[i]] = p.d.State.scenes[i]
+		}
+		p.d.State.scenes = states
+		p.d.State.activeScene = moved[p.d.State.activeScene]
+		p.WriteState()
+	}
+	if err := p.writeGroup(gr); err != nil {
+		return nil, &server.InternalError{
+			Description: "failed to write group", Inner: err}
+	}
+	return moved, nil
 }
 
 // CreateHero creates a new hero in the given group with the given name and
@@ -562,21 +773,24 @@ func (p Persistence) CreateHero(g Group, heroes groups.HeroList,
 	if err := p.writeHero(gr, &h); err != nil {
 		return err
 	}
-	hl.data = append(hl.data, h)
+	hl.add(h)
 	return nil
 }
 
 func (p Persistence) loadHero(id string, path string) (hero, error) {
 	var data yamlHero
-	if err := strictUnmarshalYAML(fileInput(path), &data); err != nil {
+	if err := p.unmarshalPersisted(
+		heroDocument, fileInput(path), path, &data); err != nil {
 		return hero{}, err
 	}
-	return hero{name: data.Name, id: id, description: data.Description}, nil
+	return hero{name: data.Name, id: id, description: data.Description,
+		portrait: data.Portrait, attributes: data.Attributes}, nil
 }
 
 func (p Persistence) writeHero(g *group, h *hero) error {
-	data := yamlHero{
-		Name: h.name, Description: h.description}
+	data := yamlHero{schemaVersions: currentVersions(p.d.owner, false),
+		Name: h.name, Description: h.description, Portrait: h.portrait,
+		Attributes: h.attributes}
 	dirPath := p.d.owner.DataDir("groups", g.id, "heroes", h.id)
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
 		return err
@@ -586,7 +800,7 @@ func (p Persistence) writeHero(g *group, h *hero) error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(path, raw, 0644)
+	return writeFile(path, raw)
 }
 
 // WriteHero writes the given hero of the given group to the file system
@@ -594,6 +808,43 @@ func (p Persistence) WriteHero(g Group, h groups.Hero) error {
 	return p.writeHero(g.(*group), h.(*hero))
 }
 
+// HeroPortraitPath returns the path to the portrait file of the given hero, or
+// the empty string if the hero has no portrait.
+func (p Persistence) HeroPortraitPath(g Group, h groups.Hero) string {
+	value := h.(*hero)
+	if value.portrait == "" {
+		return ""
+	}
+	return p.d.owner.DataDir("groups", g.(*group).id, "heroes", value.id,
+		value.portrait)
+}
+
+// SetHeroPortrait replaces the portrait of the given hero in the given group.
+// The portrait file is named "portrait" with the given suffix. If content is
+// nil, the hero's portrait is removed.
+func (p Persistence) SetHeroPortrait(g Group, h groups.Hero, suffix string,
+	content []byte) error {
+	gr := g.(*group)
+	value := h.(*hero)
+	dirPath := p.d.owner.DataDir("groups", gr.id, "heroes", value.id)
+	if value.portrait != "" {
+		path := filepath.Join(dirPath, value.portrait)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[hero portrait] while deleting %s\n  %s\n", path,
+				err.Error())
+		}
+		value.portrait = ""
+	}
+	if content != nil {
+		name := "portrait" + suffix
+		if err := writeFile(filepath.Join(dirPath, name), content); err != nil {
+			return err
+		}
+		value.portrait = name
+	}
+	return p.writeHero(gr, value)
+}
+
 // DeleteHero deletes the hero with the given index from the given group.
 func (p Persistence) DeleteHero(g Group, heroes groups.HeroList, index int) error {
 	gr := g.(*group)
@@ -684,11 +935,11 @@ func (p Persistence) loadGroups() {
 			path := filepath.Join(groupsDir, file.Name())
 			configPath := filepath.Join(path, "config.yaml")
 			heroes := p.loadHeroes(path)
-			g, err := p.loadGroup(heroes, file.Name(), fileInput(configPath), configPath)
+			g, order, err := p.loadGroup(heroes, file.Name(), fileInput(configPath), configPath)
 			if err != nil {
 				log.Println(configPath+":", err)
 			} else {
-				g.scenes = p.loadScenes(&g.heroes, path)
+				g.scenes = p.loadScenes(&g.heroes, path, order)
 				if len(g.scenes) == 0 {
 					log.Println(path + ": no valid scenes available")
 				} else {
@@ -701,7 +952,26 @@ func (p Persistence) loadGroups() {
 	sort.Sort(groupSortInterface{p.d.groups})
 }
 
-func (p Persistence) loadScenes(heroes *heroList, groupPath string) []scene {
+// orderScenes sorts the given scenes according to the given list of scene IDs.
+// Scenes not contained in the list are placed after the listed scenes, keeping
+// their relative order.
+func orderScenes(scenes []scene, order []string) {
+	position := func(s *scene) int {
+		for i := range order {
+			if order[i] == s.id {
+				return i
+			}
+		}
+		return len(order)
+	}
+	sort.SliceStable(scenes, func(i, j int) bool {
+		return position(&scenes[i]) < position(&scenes[j])
+	})
+}
+
+// loadScenes loads the scenes of the group at groupPath in the given order.
+func (p Persistence) loadScenes(heroes *heroList, groupPath string,
+	order []string) []scene {
 	ret := make([]scene, 0, 16)
 	scenesDir := filepath.Join(groupPath, "scenes")
 	files, err := ioutil.ReadDir(scenesDir)
@@ -719,6 +989,7 @@ func (p Persistence) loadScenes(heroes *heroList, groupPath string) []scene {
 			}
 		}
 	}
+	orderScenes(ret, order)
 	return ret
 }
 
@@ -744,21 +1015,24 @@ func (p Persistence) loadHeroes(groupPath string) []hero {
 }
 
 type persistedGroupState struct {
-	ActiveScene string `yaml:"activeScene"`
+	schemaVersions `yaml:",inline"`
+	ActiveScene    string `yaml:"activeScene"`
 	// scene name -> (module name -> module config)
 	Scenes map[string]map[string]yaml.Node
 }
 
 type persistingGroupState struct {
-	ActiveScene string `yaml:"activeScene"`
-	Scenes      map[string]map[string]interface{}
+	schemaVersions `yaml:",inline"`
+	ActiveScene    string `yaml:"activeScene"`
+	Scenes         map[string]map[string]interface{}
 }
 
 // LoadState loads the given YAML input into a State object and stores that
 // into the linked data object.
 func (p Persistence) LoadState(g Group, path string) (*State, error) {
 	var data persistedGroupState
-	if err := strictUnmarshalYAML(fileInput(path), &data); err != nil {
+	if err := p.unmarshalPersisted(
+		groupStateDocument, fileInput(path), path, &data); err != nil {
 		log.Println(path + ": unable to load, loading default. error was:")
 		log.Println("  " + err.Error())
 		data.ActiveScene = g.Scene(0).ID()
@@ -768,6 +1042,11 @@ func (p Persistence) LoadState(g Group, path string) (*State, error) {
 	p.d.State.path = path
 	p.d.State.a = p.d.owner
 	p.d.State.group = g
+	p.d.State.history = p.d.histories[g.ID()]
+	if p.d.State.history == nil {
+		p.d.State.history = &history{}
+		p.d.histories[g.ID()] = p.d.State.history
+	}
 	for i := 0; i < g.NumScenes(); i++ {
 		if g.Scene(i).ID() == data.ActiveScene {
 			p.d.activeScene = i
@@ -867,8 +1146,9 @@ func (p Persistence) LoadState(g Group, path string) (*State, error) {
 
 func (s *State) buildYaml() ([]byte, error) {
 	structure := persistingGroupState{
-		ActiveScene: s.group.Scene(s.activeScene).ID(),
-		Scenes:      make(map[string]map[string]interface{})}
+		schemaVersions: currentVersions(s.a, true),
+		ActiveScene:    s.group.Scene(s.activeScene).ID(),
+		Scenes:         make(map[string]map[string]interface{})}
 	for i := 0; i < s.group.NumScenes(); i++ {
 		sceneDescr := s.group.Scene(i)
 		data := make(map[string]interface{})
@@ -893,7 +1173,9 @@ func (p Persistence) WriteState() {
 		go func(content []byte, s *State) {
 			s.writeMutex.Lock()
 			defer s.writeMutex.Unlock()
-			ioutil.WriteFile(s.path, content, 0644)
+			if err := writeFile(s.path, content); err != nil {
+				log.Printf("%s[w]: %s", s.path, err)
+			}
 		}(raw, &p.d.State)
 	}
 }
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WriteSnapshot writes a zip archive containing the whole data directory to
+// w. Top-level files and directories whose name is in exclude are skipped,
+// as are hidden ones.
+func (p Persistence) WriteSnapshot(w io.Writer, exclude ...string) error {
+	files, err := ioutil.ReadDir(p.d.owner.DataDir())
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+OUTER:
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		for _, name := range exclude {
+			if file.Name() == name {
+				continue OUTER
+			}
+		}
+		if file.IsDir() {
+			// explicitly add the directory so that RestoreSnapshot knows about it
+			// even if it's empty.
+			if _, err := zw.Create(file.Name() + "/"); err != nil {
+				return err
+			}
+		}
+		if err := p.addDirToArchive(zw, file.Name()); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// RestoreSnapshot replaces the content of the data directory with the content
+// of the snapshot at the given path, which must have been created by
+// WriteSnapshot. Top-level files and directories that are not contained in
+// the snapshot are left untouched.
+//
+// The loaded data is not updated; LoadPersisted must be called afterwards.
+func (p Persistence) RestoreSnapshot(snapshotPath string) error {
+	zr, err := zip.OpenReader(snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tmp, err := ioutil.TempDir(p.d.owner.DataDir(), ".restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	extracted := filepath.Join(tmp, "new")
+	replaced := filepath.Join(tmp, "old")
+	if err := os.Mkdir(extracted, 0755); err != nil {
+		return err
+	}
+	if err := os.Mkdir(replaced, 0755); err != nil {
+		return err
+	}
+	topLevel := make(map[string]struct{})
+	for _, f := range zr.File {
+		name := path.Clean(f.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("illegal path in archive: %s", f.Name)
+		}
+		topLevel[strings.SplitN(name, "/", 2)[0]] = struct{}{}
+		filePath := filepath.Join(extracted, filepath.FromSlash(name))
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+		if err := extractFile(f, filePath); err != nil {
+			return err
+		}
+	}
+
+	// move the current content away first so that it can be put back if
+	// anything fails.
+	for name := range topLevel {
+		cur := p.d.owner.DataDir(name)
+		if _, err := os.Stat(cur); err == nil {
+			if err := os.Rename(cur, filepath.Join(replaced, name)); err != nil {
+				p.rollbackSnapshot(replaced)
+				return err
+			}
+		}
+	}
+	for name := range topLevel {
+		if err := os.Rename(filepath.Join(extracted, name),
+			p.d.owner.DataDir(name)); err != nil {
+			p.rollbackSnapshot(replaced)
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackSnapshot moves all items in the given directory back into the data
+// directory, replacing anything that has been restored in the meantime.
+func (p Persistence) rollbackSnapshot(replaced string) {
+	files, _ := ioutil.ReadDir(replaced)
+	for _, file := range files {
+		target := p.d.owner.DataDir(file.Name())
+		os.RemoveAll(target)
+		os.Rename(filepath.Join(replaced, file.Name()), target)
+	}
+}
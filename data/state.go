@@ -19,6 +19,7 @@ type State struct {
 	writeMutex  sync.Mutex
 	a           app.App
 	group       Group
+	history     *history
 }
 
 // SetScene sets the scene index.
@@ -0,0 +1,310 @@
+package data
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/QuestScreen/QuestScreen/app"
+	"github.com/QuestScreen/api/server"
+	"gopkg.in/yaml.v3"
+)
+
+// userGroupTemplate is a group template that has been saved by the user.
+type userGroupTemplate struct {
+	app.GroupTemplate
+	id string
+	// scene templates referenced by the group template's Scenes.
+	scenes []app.SceneTemplate
+}
+
+// userSceneTemplate is a scene template that has been saved by the user.
+type userSceneTemplate struct {
+	app.SceneTemplate
+	id string
+}
+
+// persistedTemplate is the structure of a user template's file. Config
+// contains the group's or scene's config in the same structure as the config
+// file of a group or scene, including schema versions.
+type persistedTemplate struct {
+	Name        string
+	Description string
+	Config      yaml.Node
+	// only given for group templates.
+	Scenes []persistedTemplateScene `yaml:",omitempty"`
+}
+
+type persistedTemplateScene struct {
+	Name   string
+	Config yaml.Node
+}
+
+type groupTemplateIDs struct {
+	data []userGroupTemplate
+}
+
+func (g groupTemplateIDs) id(index int) string {
+	return g.data[index].id
+}
+
+func (g groupTemplateIDs) length() int {
+	return len(g.data)
+}
+
+type sceneTemplateIDs struct {
+	data []userSceneTemplate
+}
+
+func (s sceneTemplateIDs) id(index int) string {
+	return s.data[index].id
+}
+
+func (s sceneTemplateIDs) length() int {
+	return len(s.data)
+}
+
+// GroupTemplateByID returns the user group template with the given ID together
+// with the scene templates referenced by it, or nil if there is no such
+// template.
+func (d *Data) GroupTemplateByID(id string) (*app.GroupTemplate,
+	[]app.SceneTemplate) {
+	for i := range d.groupTemplates {
+		if t := &d.groupTemplates[i]; t.id == id {
+			return &t.GroupTemplate, t.scenes
+		}
+	}
+	return nil, nil
+}
+
+// SceneTemplateByID returns the user scene template with the given ID, or nil
+// if there is no such template.
+func (d *Data) SceneTemplateByID(id string) *app.SceneTemplate {
+	for i := range d.sceneTemplates {
+		if t := &d.sceneTemplates[i]; t.id == id {
+			return &t.SceneTemplate
+		}
+	}
+	return nil
+}
+
+// templateConfig returns the given config node as YAML document, migrated to
+// the current schema versions.
+func (p Persistence) templateConfig(kind documentKind,
+	node *yaml.Node) ([]byte, error) {
+	raw, err := yaml.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	migrated, _, err := p.migrate(kind, raw)
+	return migrated, err
+}
+
+// configNode returns the root node of the given YAML document.
+func configNode(raw []byte) (yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return doc, err
+	}
+	return *doc.Content[0], nil
+}
+
+func (p Persistence) loadGroupTemplate(id, path string) (userGroupTemplate,
+	error) {
+	var data persistedTemplate
+	if err := strictUnmarshalYAML(fileInput(path), &data); err != nil {
+		return userGroupTemplate{}, err
+	}
+	config, err := p.templateConfig(groupDocument, &data.Config)
+	if err != nil {
+		return userGroupTemplate{}, err
+	}
+	ret := userGroupTemplate{id: id, GroupTemplate: app.GroupTemplate{
+		Name: data.Name, Description: data.Description, Config: config}}
+	for i := range data.Scenes {
+		config, err := p.templateConfig(sceneDocument, &data.Scenes[i].Config)
+		if err != nil {
+			return userGroupTemplate{}, err
+		}
+		ret.scenes = append(ret.scenes, app.SceneTemplate{
+			Name: data.Scenes[i].Name, Config: config})
+		ret.Scenes = append(ret.Scenes, app.SceneTmplRef{
+			Name: data.Scenes[i].Name, PluginIndex: p.d.owner.NumPlugins(),
+			TmplIndex: i})
+	}
+	if len(ret.Scenes) == 0 {
+		return userGroupTemplate{}, errors.New("template contains no scenes")
+	}
+	return ret, nil
+}
+
+func (p Persistence) loadSceneTemplate(id, path string) (userSceneTemplate,
+	error) {
+	var data persistedTemplate
+	if err := strictUnmarshalYAML(fileInput(path), &data); err != nil {
+		return userSceneTemplate{}, err
+	}
+	config, err := p.templateConfig(sceneDocument, &data.Config)
+	if err != nil {
+		return userSceneTemplate{}, err
+	}
+	return userSceneTemplate{id: id, SceneTemplate: app.SceneTemplate{
+		Name: data.Name, Description: data.Description, Config: config}}, nil
+}
+
+// templateFiles calls fn for each template file in the given subdirectory of
+// the templates directory.
+func (p Persistence) templateFiles(subdir string,
+	fn func(id, path string) error) {
+	dir := p.d.owner.DataDir("templates", subdir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("while loading templates: " + err.Error())
+		}
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".yaml") {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		if err := fn(strings.TrimSuffix(file.Name(), ".yaml"), path); err != nil {
+			log.Println(path+":", err)
+		}
+	}
+}
+
+func (p Persistence) loadTemplates() {
+	p.d.groupTemplates = nil
+	p.d.sceneTemplates = nil
+	p.templateFiles("groups", func(id, path string) error {
+		t, err := p.loadGroupTemplate(id, path)
+		if err == nil {
+			p.d.groupTemplates = append(p.d.groupTemplates, t)
+		}
+		return err
+	})
+	p.templateFiles("scenes", func(id, path string) error {
+		t, err := p.loadSceneTemplate(id, path)
+		if err == nil {
+			p.d.sceneTemplates = append(p.d.sceneTemplates, t)
+		}
+		return err
+	})
+}
+
+func (p Persistence) writeTemplate(subdir, id string,
+	data *persistedTemplate) error {
+	dirPath := p.d.owner.DataDir("templates", subdir)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return err
+	}
+	raw, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dirPath, id+".yaml"), raw)
+}
+
+// SaveGroupTemplate saves the given group including its scenes as new group
+// template with the given name and description.
+func (p Persistence) SaveGroupTemplate(g Group,
+	name, description string) error {
+	gr := g.(*group)
+	raw, err := p.groupYAML(gr)
+	if err != nil {
+		return err
+	}
+	data := persistedTemplate{Name: name, Description: description}
+	if data.Config, err = configNode(raw); err != nil {
+		return err
+	}
+	for i := range gr.scenes {
+		raw, err := p.sceneYAML(&gr.scenes[i])
+		if err != nil {
+			return err
+		}
+		s := persistedTemplateScene{Name: gr.scenes[i].name}
+		if s.Config, err = configNode(raw); err != nil {
+			return err
+		}
+		data.Scenes = append(data.Scenes, s)
+	}
+	id := genID(name, "template", groupTemplateIDs{p.d.groupTemplates})
+	if err = p.writeTemplate("groups", id, &data); err != nil {
+		return err
+	}
+	t, err := p.loadGroupTemplate(id, p.d.owner.DataDir(
+		"templates", "groups", id+".yaml"))
+	if err != nil {
+		return err
+	}
+	p.d.groupTemplates = append(p.d.groupTemplates, t)
+	return nil
+}
+
+// SaveSceneTemplate saves the scene at the given index in the given group as
+// new scene template with the given name and description.
+func (p Persistence) SaveSceneTemplate(g Group, index int,
+	name, description string) error {
+	gr := g.(*group)
+	if index < 0 || index >= g.NumScenes() {
+		return errors.New("index out of range")
+	}
+	raw, err := p.sceneYAML(&gr.scenes[index])
+	if err != nil {
+		return err
+	}
+	data := persistedTemplate{Name: name, Description: description}
+	if data.Config, err = configNode(raw); err != nil {
+		return err
+	}
+	id := genID(name, "template", sceneTemplateIDs{p.d.sceneTemplates})
+	if err = p.writeTemplate("scenes", id, &data); err != nil {
+		return err
+	}
+	t, err := p.loadSceneTemplate(id, p.d.owner.DataDir(
+		"templates", "scenes", id+".yaml"))
+	if err != nil {
+		return err
+	}
+	p.d.sceneTemplates = append(p.d.sceneTemplates, t)
+	return nil
+}
+
+func (p Persistence) removeTemplate(subdir, id string) {
+	path := p.d.owner.DataDir("templates", subdir, id+".yaml")
+	if err := os.Remove(path); err != nil {
+		log.Printf("[del template] while deleting %s\n  %s\n", path, err.Error())
+	}
+}
+
+// DeleteGroupTemplate deletes the user group template with the given ID.
+func (p Persistence) DeleteGroupTemplate(id string) server.Error {
+	for i := range p.d.groupTemplates {
+		if p.d.groupTemplates[i].id == id {
+			p.removeTemplate("groups", id)
+			p.d.groupTemplates = append(
+				p.d.groupTemplates[:i], p.d.groupTemplates[i+1:]...)
+			return nil
+		}
+	}
+	return &server.NotFound{Name: id}
+}
+
+// DeleteSceneTemplate deletes the user scene template with the given ID.
+func (p Persistence) DeleteSceneTemplate(id string) server.Error {
+	for i := range p.d.sceneTemplates {
+		if p.d.sceneTemplates[i].id == id {
+			p.removeTemplate("scenes", id)
+			p.d.sceneTemplates = append(
+				p.d.sceneTemplates[:i], p.d.sceneTemplates[i+1:]...)
+			return nil
+		}
+	}
+	return &server.NotFound{Name: id}
+}
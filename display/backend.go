@@ -0,0 +1,60 @@
+package display
+
+import (
+	"image"
+
+	"github.com/QuestScreen/api/render"
+)
+
+// backend implements render.Renderer for an output. The default backend
+// renders via OpenGL into a window; in headless mode, a software renderer
+// draws into an in-memory framebuffer.
+type backend interface {
+	render.Renderer
+	// activate makes the backend the target of subsequent rendering
+	// operations. Must be called before rendering to the backend.
+	activate()
+	// clear fills the screen with white.
+	clear()
+	// present makes the rendered frame visible.
+	present()
+	// readPixels copies the rendered frame into dst, which has the size of the
+	// screen. Must be called before present.
+	readPixels(dst *image.RGBA)
+	// canvasCount returns the number of currently active canvases.
+	canvasCount() uint8
+	// close releases all resources held by the backend.
+	close()
+}
+
+// unitFor calculates the value of Renderer.Unit() for the given screen size.
+func unitFor(width, height int32) int32 {
+	if width < height {
+		return width / 144
+	}
+	return height / 144
+}
+
+// withBorders calculates the size of a canvas with the given inner size and
+// borders, and the content rectangle inside the canvas.
+func withBorders(innerWidth, innerHeight, unit int32,
+	borders render.Directions) (width, height int32, content render.Rectangle) {
+	width, height = innerWidth, innerHeight
+	content = render.Rectangle{
+		X: 0, Y: 0, Width: innerWidth, Height: innerHeight}
+	if borders&render.East != 0 {
+		width += unit
+	}
+	if borders&render.West != 0 {
+		width += unit
+		content.X += unit
+	}
+	if borders&render.North != 0 {
+		height += unit
+	}
+	if borders&render.South != 0 {
+		height += unit
+		content.Y += unit
+	}
+	return
+}
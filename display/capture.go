@@ -0,0 +1,58 @@
+package display
+
+import (
+	"image"
+	"sync"
+	"time"
+)
+
+// frameCapture holds the most recently rendered frame so that it can be
+// accessed outside of the rendering thread.
+//
+// Reading back a frame is expensive, so during transitions, frames are only
+// captured in the configured interval. The last frame of a transition, which
+// stays visible, is always captured.
+type frameCapture struct {
+	mutex sync.Mutex
+	frame *image.RGBA
+	// closed and replaced whenever a new frame has been captured.
+	updated  chan struct{}
+	interval time.Duration
+	last     time.Time
+}
+
+// SetCaptureInterval sets the minimum time between two frames being captured
+// while transitions are running. If 0, only frames that stay visible are
+// captured. Must be called before RenderLoop.
+func (d *Display) SetCaptureInterval(interval time.Duration) {
+	d.capture.interval = interval
+}
+
+// Frame returns the most recently rendered frame, and a channel that will be
+// closed as soon as a newer frame is available. The returned frame is nil if
+// nothing has been rendered yet and must not be modified.
+func (d *Display) Frame() (*image.RGBA, <-chan struct{}) {
+	d.capture.mutex.Lock()
+	defer d.capture.mutex.Unlock()
+	return d.capture.frame, d.capture.updated
+}
+
+// captureFrame reads back the frame rendered to the given output if necessary.
+// Must be called after rendering and before presenting the frame.
+func (d *Display) captureFrame(o *output, cur time.Time) {
+	c := &d.capture
+	if d.numTransitions > 0 &&
+		(c.interval == 0 || cur.Sub(c.last) < c.interval) {
+		return
+	}
+	size := o.OutputSize()
+	frame := image.NewRGBA(image.Rect(0, 0, int(size.Width), int(size.Height)))
+	o.readPixels(frame)
+
+	c.mutex.Lock()
+	c.frame = frame
+	close(c.updated)
+	c.updated = make(chan struct{})
+	c.last = cur
+	c.mutex.Unlock()
+}
@@ -1,17 +1,11 @@
 package display
 
 import (
-	"errors"
-	"fmt"
 	"log"
-	"sync/atomic"
 	"time"
 
 	"github.com/QuestScreen/QuestScreen/app"
 	"github.com/QuestScreen/QuestScreen/shared"
-	"github.com/QuestScreen/api/modules"
-	"github.com/QuestScreen/api/render"
-	"github.com/QuestScreen/api/server"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
@@ -21,28 +15,22 @@ type moduleState struct {
 	transitioning            bool
 }
 
-const (
-	noRequest uint32 = iota
-	activeRequest
-)
-
-// Display describes a display rendering scenes.
+// Display describes a display rendering scenes to one or more outputs.
+// The first output is the primary output, which shows the key actions popup
+// and is captured for previews.
 type Display struct {
 	Events
-	owner                app.App
-	r                    renderer
-	actions              []KeyAction
-	moduleRenderers      []modules.Renderer
-	Window               *sdl.Window
-	textureBuffer        uint32
-	moduleStates         []moduleState
-	numTransitions       int32
-	popupTexture         render.Image
-	welcomeTexture       render.Image
-	initial              bool
-	enabledModules       []bool
-	queuedEnabledModules []bool
-	request              uint32
+	owner   app.App
+	actions []KeyAction
+	outputs []*output
+	// Window is the window of the primary output; nil in headless mode.
+	Window         *sdl.Window
+	textureBuffer  uint32
+	numTransitions int32
+	queue          requestQueue
+	capture        frameCapture
+	bindings       []InputBinding
+	inputHandler   InputHandler
 }
 
 // KeyAction describes a key that closes the app with the given return value
@@ -52,36 +40,49 @@ type KeyAction struct {
 	Description string
 }
 
-// Init initializes the display. The renderer and window need to be generated
-// before since the app needs to load fonts based on the window size.
+// Init initializes the display with one output per given window, the first
+// one being the primary output. The windows need to be generated before since
+// the app needs to load fonts based on the window size. All windows must be
+// usable with the given OpenGL context.
 func (d *Display) Init(
-	owner app.App, events Events, fullscreen bool, port uint16,
-	actions []KeyAction, window *sdl.Window, debug bool) error {
+	owner app.App, events Events, welcome Welcome, actions []KeyAction,
+	windows []*sdl.Window, context sdl.GLContext, debug bool) error {
+	d.Window = windows[0]
+	sdl.ShowCursor(sdl.DISABLE)
+	gl := newGLShared(owner, context, debug)
+	backends := make([]backend, len(windows))
+	for i := range windows {
+		backends[i] = newGLRenderer(gl, windows[i])
+	}
+	return d.init(owner, events, welcome, actions, backends)
+}
+
+// InitHeadless initializes the display without a window. Rendering is done by
+// a software renderer into an in-memory framebuffer of the given size.
+func (d *Display) InitHeadless(owner app.App, events Events,
+	welcome Welcome, width, height int32) error {
+	return d.init(owner, events, welcome, nil,
+		[]backend{newSoftRenderer(owner, width, height)})
+}
+
+func (d *Display) init(owner app.App, events Events, welcome Welcome,
+	actions []KeyAction, backends []backend) error {
 	d.owner = owner
 	d.Events = events
 	d.actions = actions
-	d.Window = window
-	d.initial = true
-
-	sdl.ShowCursor(sdl.DISABLE)
-
-	width, height := window.GLGetDrawableSize()
-	d.r.init(width, height, len(owner.GetTextures()), debug)
 	d.numTransitions = 0
+	d.capture.updated = make(chan struct{})
 
-	dRect := d.OutputSize()
-	d.genPopup(dRect, actions)
-	if err := d.genWelcome(dRect, port); err != nil {
-		return err
-	}
-
-	d.moduleStates = make([]moduleState, d.owner.NumModules())
-
-	d.moduleRenderers = make([]modules.Renderer, d.owner.NumModules())
-	for i := shared.FirstModule; i < shared.ModuleIndex(len(d.moduleRenderers)); i++ {
+	d.outputs = make([]*output, len(backends))
+	for i := range backends {
+		backends[i].activate()
 		var err error
-		d.moduleRenderers[i], err = d.owner.ModuleAt(i).CreateRenderer(
-			d, owner.MessageSenderFor(i))
+		// only the primary output shows the key actions.
+		if i == 0 {
+			d.outputs[i], err = newOutput(owner, backends[i], welcome, actions)
+		} else {
+			d.outputs[i], err = newOutput(owner, backends[i], welcome, nil)
+		}
 		if err != nil {
 			return err
 		}
@@ -89,62 +90,36 @@ func (d *Display) Init(
 	return nil
 }
 
+// NumOutputs returns the number of outputs of the display.
+func (d *Display) NumOutputs() int {
+	return len(d.outputs)
+}
+
+// OutputAspect returns the aspect ratio (width / height) of the output at the
+// given index.
+func (d *Display) OutputAspect(index int) float64 {
+	size := d.outputs[index].OutputSize()
+	return float64(size.Width) / float64(size.Height)
+}
+
 func (d *Display) render(cur time.Time, popup bool) {
-	d.r.clear()
-	frame := d.OutputSize()
-	if d.initial {
-		d.welcomeTexture.Draw(d, frame, 255)
-	} else {
-		for i := shared.FirstModule; i < d.owner.NumModules(); i++ {
-			if d.enabledModules[i] {
-				ccount := d.r.canvasCount()
-				state := &d.moduleStates[i]
-				r := d.moduleRenderers[i]
-				if state.transitioning {
-					if cur.After(state.transEnd) {
-						r.FinishTransition(d)
-						d.numTransitions--
-						state.transitioning = false
-					} else {
-						r.TransitionStep(d, cur.Sub(state.transStart))
-					}
-				}
-				r.Render(d)
-				if ccount != d.r.canvasCount() {
-					panic("module " + d.owner.ModuleAt(i).Name + " failed to close all its canvases!")
-				}
+	for i, o := range d.outputs {
+		o.activate()
+		d.numTransitions += o.render(cur)
+		if i == 0 {
+			if popup && !o.popupTexture.IsEmpty() {
+				o.popupTexture.Draw(o, o.OutputSize(), 255)
 			}
+			d.captureFrame(o, cur)
 		}
+		o.present()
 	}
-
-	if popup && !d.popupTexture.IsEmpty() {
-		d.popupTexture.Draw(d, frame, 255)
-	}
-	d.Window.GLSwap()
 }
 
 func (d *Display) startTransition(moduleIndex shared.ModuleIndex) {
-	r := d.moduleRenderers[moduleIndex]
-	state := &d.moduleStates[moduleIndex]
-	if state.queuedData == nil {
-		panic("Trying to call InitTransition without data")
-	}
-	if state.transitioning {
-		r.FinishTransition(d)
-		d.numTransitions--
-		state.transitioning = false
-	}
-
-	transDur := r.InitTransition(d, state.queuedData)
-	state.queuedData = nil
-	if transDur == 0 {
-		r.FinishTransition(d)
-	} else if transDur > 0 {
-		d.numTransitions++
-
-		state.transStart = time.Now()
-		state.transEnd = state.transStart.Add(transDur)
-		state.transitioning = true
+	for _, o := range d.outputs {
+		o.activate()
+		d.numTransitions += o.startTransition(moduleIndex)
 	}
 }
 
@@ -170,6 +145,8 @@ func (d *Display) RenderLoop() int {
 		}
 		var event sdl.Event
 		if d.numTransitions > 0 {
+			// keep handling events, but do not block.
+			event = sdl.PollEvent()
 			/*waitTime := (time.Second / 80) - time.Now().Sub(curTime)
 			if waitTime > 0 {
 				event = sdl.WaitEventTimeout(int(waitTime / time.Millisecond))
@@ -183,6 +160,9 @@ func (d *Display) RenderLoop() int {
 			switch e := event.(type) {
 			case *sdl.KeyboardEvent:
 				if e.Type == sdl.KEYDOWN {
+					if !popup && d.triggerKey(e.Keysym.Sym, e.Repeat != 0) {
+						break
+					}
 					if popup {
 						for i := range d.actions {
 							if e.Keysym.Sym == d.actions[i].Key {
@@ -196,6 +176,9 @@ func (d *Display) RenderLoop() int {
 						popup = true
 					}
 				}
+			case *sdl.JoyDeviceAddedEvent, *sdl.JoyDeviceRemovedEvent,
+				*sdl.JoyButtonEvent:
+				d.handleJoystickEvent(event)
 			case *sdl.QuitEvent:
 				return 0
 			case *sdl.WindowEvent:
@@ -203,41 +186,13 @@ func (d *Display) RenderLoop() int {
 				case sdl.WINDOWEVENT_SHOWN, sdl.WINDOWEVENT_EXPOSED:
 					render = true
 				}
-			case *sdl.UserEvent:
-				switch e.Type {
-				case d.Events.ModuleUpdateID:
-					d.startTransition(shared.ModuleIndex(e.Code))
-				case d.Events.SceneChangeID:
-					d.enabledModules = d.queuedEnabledModules
-					d.queuedEnabledModules = nil
-					d.initial = false
-					fallthrough
-				case d.Events.ModuleConfigID:
-					for i := shared.FirstModule; i < d.owner.NumModules(); i++ {
-						r := d.moduleRenderers[i]
-						state := &d.moduleStates[i]
-						if state.queuedConfig != nil || state.queuedData != nil {
-							r.Rebuild(d, state.queuedData, state.queuedConfig)
-							state.queuedData = nil
-							state.queuedConfig = nil
-						}
-					}
-				case d.Events.HeroesChangedID:
-					for i := shared.FirstModule; i < d.owner.NumModules(); i++ {
-						state := &d.moduleStates[i]
-						if state.queuedData != nil {
-							r := d.moduleRenderers[i]
-							r.Rebuild(d, state.queuedData, nil)
-							state.queuedData = nil
-						}
-					}
-				case d.Events.LeaveGroupID:
-					d.initial = true
-				}
-				render = true
-				atomic.StoreUint32(&d.request, noRequest)
 			}
 		}
+		// committed requests push a user event to wake up the loop, but are
+		// processed here so that they are also handled during transitions.
+		if d.processRequests() {
+			render = true
+		}
 		if render && !inRender {
 			start = time.Now()
 			frameCount = 0
@@ -245,100 +200,10 @@ func (d *Display) RenderLoop() int {
 	}
 }
 
-// Request is a pending message to the display thread.
-// Successful generation of a request leads to exclusive access to the display's
-// communication channel.
-// A Request must be either committed or closed.
-type Request struct {
-	d         *Display
-	eventID   uint32
-	eventCode int32
-}
-
-var errMultipleModuleConfigs = errors.New("Cannot send multiple configs to same module in one request")
-var errMultipleModuleData = errors.New("Cannot send multiple data objects to same module in one request")
-var errMultipleEnabledModules = errors.New("Cannot send multiple enabledModules lists in one request")
-var errAlreadyCommitted = errors.New("Request has already been committed")
-
-// StartRequest starts a new request to the display thread.
-// Returns an error if there is already a pending request.
-func (d *Display) StartRequest(eventID uint32, eventCode int32) (Request,
-	server.Error) {
-	if eventID == sdl.FIRSTEVENT {
-		panic("illegal SDL event ID")
-	}
-	if atomic.CompareAndSwapUint32(&d.request, noRequest, activeRequest) {
-		return Request{d: d, eventID: eventID, eventCode: eventCode}, nil
-	}
-	return Request{}, &app.TooManyRequests{}
-}
-
-// SendModuleConfig queues the given config for the module at the given ID as
-// part of the request.
-func (r *Request) SendModuleConfig(index shared.ModuleIndex, config interface{}) error {
-	if index < 0 || index >= r.d.owner.NumModules() {
-		return fmt.Errorf("Module index %d outside of range 0..%d", index, len(r.d.moduleStates))
-	}
-	state := &r.d.moduleStates[index]
-	if state.queuedConfig != nil {
-		return errMultipleModuleConfigs
-	}
-	state.queuedConfig = config
-	return nil
-}
-
-// SendRendererData queues the given data for the module at the given ID as part
-// of the request. Whether the data is used for RebuiltState or InitTransition
-// depends on the event ID of the request.
-func (r *Request) SendRendererData(index shared.ModuleIndex, data interface{}) error {
-	if index < 0 || index >= r.d.owner.NumModules() {
-		return fmt.Errorf("Module index %d outside of range 0..%d", index, len(r.d.moduleStates))
-	}
-	state := &r.d.moduleStates[index]
-	if state.queuedData != nil {
-		return errMultipleModuleData
-	}
-	state.queuedData = data
-	return nil
-}
-
-// SendEnabledModulesList queues the list of enabled modules as part of the
-// request.
-func (r *Request) SendEnabledModulesList(value []bool) error {
-	if r.d.queuedEnabledModules != nil {
-		return errMultipleEnabledModules
-	}
-	r.d.queuedEnabledModules = value
-	return nil
-}
-
-// Commit sends the request to the display thread
-func (r *Request) Commit() error {
-	if r.eventID == sdl.FIRSTEVENT {
-		return errAlreadyCommitted
-	}
-	sdl.PushEvent(&sdl.UserEvent{Type: r.eventID, Code: r.eventCode})
-	r.eventID = sdl.FIRSTEVENT
-	return nil
-}
-
-// Close closes the request. If the request has not been committed, the queued
-// data will be erased. This function is idempotent.
-func (r *Request) Close() {
-	if r.eventID != sdl.FIRSTEVENT {
-		for i := range r.d.moduleStates {
-			state := r.d.moduleStates[i]
-			state.queuedData = nil
-			state.queuedConfig = nil
-		}
-		r.d.queuedEnabledModules = nil
-		r.eventID = sdl.FIRSTEVENT
-		atomic.StoreUint32(&r.d.request, noRequest)
-	}
-}
-
-// Destroy destroys window and renderer
+// Destroy destroys windows and renderers
 func (d *Display) Destroy() {
-	d.r.close()
-	d.Window.Destroy()
+	// the primary output is closed last since it may hold shared resources.
+	for i := len(d.outputs) - 1; i >= 0; i-- {
+		d.outputs[i].close()
+	}
 }
@@ -0,0 +1,81 @@
+package display
+
+import (
+	"log"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// InputBinding describes a key or gamepad button that triggers an action.
+type InputBinding struct {
+	// Key is the bound key. If it is sdl.K_UNKNOWN, Button is bound instead.
+	Key sdl.Keycode
+	// Button is the index of the bound button on any connected gamepad or
+	// joystick.
+	Button uint8
+}
+
+// InputHandler is called with the index of the binding that has been
+// triggered. It is called in its own goroutine so that it may issue requests
+// to the display.
+type InputHandler func(index int)
+
+// BindInputs sets the bindings the display reacts to. Keys that are bound are
+// no longer able to open the key actions popup.
+func (d *Display) BindInputs(bindings []InputBinding, handler InputHandler) {
+	d.bindings = bindings
+	d.inputHandler = handler
+	for i := range bindings {
+		if bindings[i].Key == sdl.K_UNKNOWN {
+			// connected devices are opened when SDL reports them as added.
+			if err := sdl.InitSubSystem(sdl.INIT_JOYSTICK); err != nil {
+				log.Println("unable to initialize joystick support: " + err.Error())
+			} else {
+				sdl.JoystickEventState(sdl.ENABLE)
+			}
+			break
+		}
+	}
+}
+
+// triggerKey triggers the binding of the given key unless the key press is a
+// repetition. Returns false if the key is not bound.
+func (d *Display) triggerKey(key sdl.Keycode, repeat bool) bool {
+	for i := range d.bindings {
+		if d.bindings[i].Key == key {
+			if !repeat {
+				go d.inputHandler(i)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Display) triggerButton(button uint8) {
+	for i := range d.bindings {
+		if d.bindings[i].Key == sdl.K_UNKNOWN && d.bindings[i].Button == button {
+			go d.inputHandler(i)
+			return
+		}
+	}
+}
+
+func (d *Display) handleJoystickEvent(event sdl.Event) {
+	switch e := event.(type) {
+	case *sdl.JoyDeviceAddedEvent:
+		if joy := sdl.JoystickOpen(int(e.Which)); joy == nil {
+			log.Printf("unable to open joystick %d: %v\n", e.Which, sdl.GetError())
+		} else {
+			log.Printf("opened joystick: %s\n", joy.Name())
+		}
+	case *sdl.JoyDeviceRemovedEvent:
+		if joy := sdl.JoystickFromInstanceID(e.Which); joy != nil {
+			joy.Close()
+		}
+	case *sdl.JoyButtonEvent:
+		if e.Type == sdl.JOYBUTTONDOWN {
+			d.triggerButton(e.Button)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package display
+
+import (
+	"time"
+
+	"github.com/QuestScreen/QuestScreen/app"
+	"github.com/QuestScreen/QuestScreen/shared"
+	"github.com/QuestScreen/api"
+	"github.com/QuestScreen/api/modules"
+	"github.com/QuestScreen/api/render"
+)
+
+// output is a screen the display renders to. Each output has its own set of
+// module renderers and enabled modules and can thus show a different scene.
+//
+// An output implements render.Renderer via its backend and is given to its
+// module renderers.
+type output struct {
+	backend
+	owner           app.App
+	moduleRenderers []modules.Renderer
+	moduleStates    []moduleState
+	popupTexture    render.Image
+	welcomeTexture  render.Image
+	initial         bool
+	enabledModules  []bool
+	prevScene       sceneSnapshot
+}
+
+// sceneSnapshot holds an image of the previous scene while the output
+// transitions to the next one.
+type sceneSnapshot struct {
+	image      render.Image
+	transition shared.SceneTransition
+	start      time.Time
+}
+
+func newOutput(owner app.App, b backend, welcome Welcome,
+	actions []KeyAction) (*output, error) {
+	o := &output{backend: b, owner: owner, initial: true}
+
+	oRect := o.OutputSize()
+	if actions != nil {
+		o.genPopup(oRect, actions)
+	}
+	if err := o.genWelcome(oRect, welcome); err != nil {
+		return nil, err
+	}
+
+	o.moduleStates = make([]moduleState, owner.NumModules())
+
+	o.moduleRenderers = make([]modules.Renderer, owner.NumModules())
+	for i := shared.FirstModule; i < shared.ModuleIndex(len(o.moduleRenderers)); i++ {
+		var err error
+		o.moduleRenderers[i], err = owner.ModuleAt(i).CreateRenderer(
+			o, owner.MessageSenderFor(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// render renders the output's current content. Returns the change in the
+// number of running transitions.
+func (o *output) render(cur time.Time) (transitionsDelta int32) {
+	o.clear()
+	frame := o.OutputSize()
+	defer func() {
+		transitionsDelta += o.drawPrevScene(cur, frame)
+	}()
+	if o.initial {
+		o.welcomeTexture.Draw(o, frame, 255)
+		return
+	}
+	for i := shared.FirstModule; i < o.owner.NumModules(); i++ {
+		if o.enabledModules[i] {
+			ccount := o.canvasCount()
+			state := &o.moduleStates[i]
+			r := o.moduleRenderers[i]
+			if state.transitioning {
+				if cur.After(state.transEnd) {
+					r.FinishTransition(o)
+					transitionsDelta--
+					state.transitioning = false
+				} else {
+					r.TransitionStep(o, cur.Sub(state.transStart))
+				}
+			}
+			r.Render(o)
+			if ccount != o.canvasCount() {
+				panic("module " + o.owner.ModuleAt(i).Name + " failed to close all its canvases!")
+			}
+		}
+	}
+	return
+}
+
+// drawPrevScene draws the previous scene above the current content if a scene
+// transition is running. Returns -1 if the scene transition has finished.
+func (o *output) drawPrevScene(cur time.Time, frame render.Rectangle) int32 {
+	p := &o.prevScene
+	if p.image.IsEmpty() {
+		return 0
+	}
+	elapsed := cur.Sub(p.start)
+	if elapsed >= p.transition.Duration {
+		o.FreeImage(&p.image)
+		return -1
+	}
+	pos := render.TransitionCurve{Duration: p.transition.Duration}.Cubic(elapsed)
+	switch p.transition.Style {
+	case shared.SlideTransition:
+		frame.X -= int32(pos * float32(frame.Width))
+		p.image.Draw(o, frame, 255)
+	default:
+		p.image.Draw(o, frame, uint8((1.0-pos)*255))
+	}
+	return 0
+}
+
+// startSceneTransition captures the currently shown content so that the output
+// can transition from it after the next scene has been built. Returns the
+// change in the number of running transitions.
+func (o *output) startSceneTransition(
+	t shared.SceneTransition) (transitionsDelta int32) {
+	if t.Style == shared.CutTransition || t.Duration <= 0 {
+		return
+	}
+	o.activate()
+	frame := o.OutputSize()
+	canvas, _ := o.CreateCanvas(frame.Width, frame.Height,
+		api.RGBA{R: 255, G: 255, B: 255, A: 255}.AsBackground(), render.Nowhere)
+	if o.initial {
+		o.welcomeTexture.Draw(o, frame, 255)
+	} else {
+		for i := shared.FirstModule; i < o.owner.NumModules(); i++ {
+			if o.enabledModules[i] {
+				o.moduleRenderers[i].Render(o)
+			}
+		}
+	}
+	// a running scene transition is part of the captured content.
+	cur := time.Now()
+	transitionsDelta = o.drawPrevScene(cur, frame) + 1
+	if !o.prevScene.image.IsEmpty() {
+		o.FreeImage(&o.prevScene.image)
+		transitionsDelta--
+	}
+	o.prevScene = sceneSnapshot{image: canvas.Finish(), transition: t,
+		start: cur}
+	return
+}
+
+// startTransition starts a transition of the module at the given index if
+// data has been queued for it. Returns the change in the number of running
+// transitions.
+func (o *output) startTransition(
+	moduleIndex shared.ModuleIndex) (transitionsDelta int32) {
+	r := o.moduleRenderers[moduleIndex]
+	state := &o.moduleStates[moduleIndex]
+	if state.queuedData == nil {
+		return
+	}
+	if state.transitioning {
+		r.FinishTransition(o)
+		transitionsDelta--
+		state.transitioning = false
+	}
+
+	transDur := r.InitTransition(o, state.queuedData)
+	state.queuedData = nil
+	if transDur == 0 {
+		r.FinishTransition(o)
+	} else if transDur > 0 {
+		transitionsDelta++
+
+		state.transStart = time.Now()
+		state.transEnd = state.transStart.Add(transDur)
+		state.transitioning = true
+	}
+	return
+}
+
+// rebuild rebuilds all modules for which data or a config has been queued.
+// If withConfig is false, queued configs are ignored.
+func (o *output) rebuild(withConfig bool) {
+	for i := shared.FirstModule; i < o.owner.NumModules(); i++ {
+		r := o.moduleRenderers[i]
+		state := &o.moduleStates[i]
+		if withConfig && (state.queuedConfig != nil || state.queuedData != nil) {
+			r.Rebuild(o, state.queuedData, state.queuedConfig)
+			state.queuedData = nil
+			state.queuedConfig = nil
+		} else if !withConfig && state.queuedData != nil {
+			r.Rebuild(o, state.queuedData, nil)
+			state.queuedData = nil
+		}
+	}
+}
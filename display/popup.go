@@ -21,20 +21,20 @@ func keyName(k sdl.Keycode) string {
 	}
 }
 
-func (d *Display) renderKeyOptions(frame render.Rectangle, actions []KeyAction) error {
+func (o *output) renderKeyOptions(frame render.Rectangle, actions []KeyAction) error {
 	actionImages := make([]render.Image, len(actions))
 
 	fontDesc := api.Font{FamilyIndex: 0, Size: api.ContentFont,
 		Style: api.RegularFont, Color: api.RGBA{R: 0, G: 0, B: 0, A: 200}}
 
-	bottomText := d.RenderText("any other key to close", fontDesc)
-	defer d.FreeImage(&bottomText)
+	bottomText := o.RenderText("any other key to close", fontDesc)
+	defer o.FreeImage(&bottomText)
 
 	fontDesc.Color.A = 230
 
 	maxHeight := bottomText.Height
 	for i := range actions {
-		actionImages[i] = d.RenderText(actions[i].Description, fontDesc)
+		actionImages[i] = o.RenderText(actions[i].Description, fontDesc)
 
 		if actionImages[i].Height > maxHeight {
 			maxHeight = actionImages[i].Height
@@ -49,21 +49,21 @@ func (d *Display) renderKeyOptions(frame render.Rectangle, actions []KeyAction)
 		row, frame = frame.Carve(render.North, maxHeight)
 		_, row = row.Carve(render.West, padding)
 		keyFrame, row := row.Carve(render.West, maxHeight)
-		keyFrame = keyFrame.Shrink(-2*d.r.unit, -2*d.r.unit)
-		keyFrame.Fill(d, api.RGBA{R: 0, G: 0, B: 0, A: 255})
-		keyFrame = keyFrame.Shrink(2*d.r.unit, 2*d.r.unit)
-		keyFrame.Fill(d, api.RGBA{R: 255, G: 255, B: 255, A: 255})
-		keyTex := d.RenderText(keyName(actions[i].Key), fontDesc)
+		keyFrame = keyFrame.Shrink(-2*o.Unit(), -2*o.Unit())
+		keyFrame.Fill(o, api.RGBA{R: 0, G: 0, B: 0, A: 255})
+		keyFrame = keyFrame.Shrink(2*o.Unit(), 2*o.Unit())
+		keyFrame.Fill(o, api.RGBA{R: 255, G: 255, B: 255, A: 255})
+		keyTex := o.RenderText(keyName(actions[i].Key), fontDesc)
 		keyArea := keyFrame.Position(keyTex.Width, keyTex.Height, render.Center,
 			render.Middle)
-		keyTex.Draw(d, keyArea, 255)
-		d.FreeImage(&keyTex)
+		keyTex.Draw(o, keyArea, 255)
+		o.FreeImage(&keyTex)
 
 		action := &actionImages[i]
 		actionArea := row.Position(action.Width, action.Height,
 			render.Center, render.Middle)
-		actionImages[i].Draw(d, actionArea, 255)
-		d.FreeImage(action)
+		actionImages[i].Draw(o, actionArea, 255)
+		o.FreeImage(action)
 	}
 	frame.Carve(render.North, padding)
 	bottomFrame, _ := frame.Carve(render.North, maxHeight)
@@ -72,26 +72,26 @@ func (d *Display) renderKeyOptions(frame render.Rectangle, actions []KeyAction)
 	if !bottomText.HasAlpha {
 		panic("bottom text has no alpha!")
 	}
-	bottomText.Draw(d, bottomArea, 255)
+	bottomText.Draw(o, bottomArea, 255)
 
 	return nil
 }
 
-func (d *Display) genPopup(frame render.Rectangle, actions []KeyAction) {
-	if d.owner.NumFontFamilies() == 0 {
+func (o *output) genPopup(frame render.Rectangle, actions []KeyAction) {
+	if o.owner.NumFontFamilies() == 0 {
 		return
 	}
-	canvas, _ := d.CreateCanvas(frame.Width, frame.Height,
+	canvas, _ := o.CreateCanvas(frame.Width, frame.Height,
 		api.RGBA{R: 0, G: 0, B: 0, A: 127}.AsBackground(), render.Nowhere)
 
 	defer canvas.Close()
 
 	frame = frame.Shrink(frame.Width/2, frame.Height/2)
-	frame.Fill(d, api.RGBA{R: 0, G: 0, B: 0, A: 255})
-	frame = frame.Shrink(2*d.r.unit, 2*d.r.unit)
-	frame.Fill(d, api.RGBA{R: 255, G: 255, B: 255, A: 255})
-	if err := d.renderKeyOptions(frame, actions); err != nil {
+	frame.Fill(o, api.RGBA{R: 0, G: 0, B: 0, A: 255})
+	frame = frame.Shrink(2*o.Unit(), 2*o.Unit())
+	frame.Fill(o, api.RGBA{R: 255, G: 255, B: 255, A: 255})
+	if err := o.renderKeyOptions(frame, actions); err != nil {
 		panic(err)
 	}
-	d.popupTexture = canvas.Finish()
+	o.popupTexture = canvas.Finish()
 }
@@ -3,10 +3,12 @@ package display
 // #include <renderer.h>
 import "C"
 import (
+	"image"
 	"log"
 	"net/url"
 	"unsafe"
 
+	"github.com/QuestScreen/QuestScreen/app"
 	"github.com/QuestScreen/api"
 	"github.com/QuestScreen/api/render"
 	"github.com/veandco/go-sdl2/img"
@@ -18,46 +20,92 @@ func toArr(c api.RGBA) [4]C.uint8_t {
 		C.uint8_t(c.R), C.uint8_t(c.G), C.uint8_t(c.B), C.uint8_t(c.A)}
 }
 
-// this struct contains data used for implementing api.Renderer within Display.
-type renderer struct {
-	engine        C.engine_t
-	width, height int32
-	unit          int32
-	textureCache  []render.Image
+// glShared is the OpenGL state shared by all outputs. All windows are
+// rendered to with the same context so that textures can be shared.
+type glShared struct {
+	owner        app.App
+	context      sdl.GLContext
+	engine       C.engine_t
+	textureCache []render.Image
+	users        int
 }
 
-func (r *renderer) init(width int32, height int32, numTextures int, debug bool) {
-	if !C.engine_init(&r.engine, C._Bool(debug)) {
+func newGLShared(owner app.App, context sdl.GLContext, debug bool) *glShared {
+	gl := &glShared{owner: owner, context: context}
+	if !C.engine_init(&gl.engine, C._Bool(debug)) {
 		panic("couldn't initialize rendering engine")
 	}
-	r.width = width
-	r.height = height
-	if width < height {
-		r.unit = width / 144
-	} else {
-		r.unit = height / 144
+	gl.textureCache = make([]render.Image, len(owner.GetTextures()))
+	return gl
+}
+
+// glRenderer implements the backend using OpenGL, rendering into a window.
+type glRenderer struct {
+	*glShared
+	window        *sdl.Window
+	width, height int32
+	unit          int32
+}
+
+func newGLRenderer(gl *glShared, window *sdl.Window) *glRenderer {
+	r := &glRenderer{glShared: gl, window: window}
+	r.width, r.height = window.GLGetDrawableSize()
+	r.unit = unitFor(r.width, r.height)
+	gl.users++
+	return r
+}
+
+func (r *glRenderer) activate() {
+	if err := r.window.GLMakeCurrent(r.context); err != nil {
+		panic("couldn't activate OpenGL context: " + err.Error())
 	}
-	C.glViewport(0, 0, C.GLsizei(width), C.GLsizei(height))
-	r.textureCache = make([]render.Image, numTextures)
+	C.glViewport(0, 0, C.GLsizei(r.width), C.GLsizei(r.height))
 }
 
-func (r *renderer) close() {
-	C.engine_close(&r.engine)
+// close destroys the window. The rendering engine is closed along with the
+// last window using it.
+func (r *glRenderer) close() {
+	r.users--
+	if r.users == 0 {
+		r.activate()
+		C.engine_close(&r.engine)
+	}
+	r.window.Destroy()
 }
 
-func (r *renderer) clear() {
+func (r *glRenderer) clear() {
 	C.glClearColor(1.0, 1.0, 1.0, 1.0)
 	C.glClear(C.GL_COLOR_BUFFER_BIT)
 }
 
+func (r *glRenderer) present() {
+	r.window.GLSwap()
+}
+
+func (r *glRenderer) readPixels(dst *image.RGBA) {
+	w, h := int(r.width), int(r.height)
+	buf := make([]byte, w*h*4)
+	C.glPixelStorei(C.GL_PACK_ALIGNMENT, 4)
+	C.glReadPixels(0, 0, C.GLsizei(w), C.GLsizei(h), C.GL_RGBA,
+		C.GL_UNSIGNED_BYTE, unsafe.Pointer(&buf[0]))
+	// OpenGL returns the bottom row first.
+	for y := 0; y < h; y++ {
+		copy(dst.Pix[y*dst.Stride:], buf[(h-1-y)*w*4:(h-y)*w*4])
+	}
+	// the window's alpha channel is meaningless.
+	for i := 3; i < len(dst.Pix); i += 4 {
+		dst.Pix[i] = 255
+	}
+}
+
 // toInternalCoords alters a transformation which transforms a square
 // (-0.5, -0.5) -- (0.5, 0.5) in a coordinate system where the screen
-// is (0,0) -- (d.r.width,d.r.height), to a transformation which transforms a
+// is (0,0) -- (r.width,r.height), to a transformation which transforms a
 // square (0,0) -- (1,1) in a coordinate system where the screen is
 // (-1.0, -1.0) -- (1.0, 1.0) (the OpenGL viewport).
-func (d *Display) toInternalCoords(t render.Transform, flip bool) render.Transform {
+func (r *glRenderer) toInternalCoords(t render.Transform, flip bool) render.Transform {
 	ret := render.Identity().Translate(-1.0, -1.0).Scale(
-		2.0/float32(d.r.width), 2.0/float32(d.r.height)).Compose(t)
+		2.0/float32(r.width), 2.0/float32(r.height)).Compose(t)
 	if flip {
 		ret = ret.Scale(1.0, -1.0)
 	}
@@ -66,19 +114,19 @@ func (d *Display) toInternalCoords(t render.Transform, flip bool) render.Transfo
 
 // OutputSize returns a rectangle that describes the dimensions in pixels
 // of the current rendering area. X and Y are always 0.
-func (d *Display) OutputSize() render.Rectangle {
-	return render.Rectangle{X: 0, Y: 0, Width: d.r.width, Height: d.r.height}
+func (r *glRenderer) OutputSize() render.Rectangle {
+	return render.Rectangle{X: 0, Y: 0, Width: r.width, Height: r.height}
 }
 
 // FillRect fills the rectangle with the specified dimensions with the
 // specified color. The rectangle is positions via the given transformation.
-func (d *Display) FillRect(t render.Transform, color api.RGBA) {
-	t = d.toInternalCoords(t, false)
+func (r *glRenderer) FillRect(t render.Transform, color api.RGBA) {
+	t = r.toInternalCoords(t, false)
 	cArr := toArr(color)
-	C.draw_rect(&d.r.engine, (*C.float)(&t[0]), &cArr[0], false)
+	C.draw_rect(&r.engine, (*C.float)(&t[0]), &cArr[0], false)
 }
 
-func (d *Display) surfaceToTexture(
+func (r *glRenderer) surfaceToTexture(
 	surface *sdl.Surface, scaleDownToOutput bool) (render.Image, error) {
 	// determine whether we need to convert the pixel format.
 	// we do this before potential scaling, because if we need to do scaling *and*
@@ -116,11 +164,11 @@ func (d *Display) surfaceToTexture(
 		panic("unexpected format")
 	}
 
-	maxW := int32(d.r.engine.maxTexSize)
-	maxH := int32(d.r.engine.maxTexSize)
+	maxW := int32(r.engine.maxTexSize)
+	maxH := int32(r.engine.maxTexSize)
 	if scaleDownToOutput {
-		// don't use d.r.with/height because we may be in a Canvas.
-		oW, oH := d.Window.GLGetDrawableSize()
+		// don't use r.with/height because we may be in a Canvas.
+		oW, oH := r.window.GLGetDrawableSize()
 		if oW < maxW {
 			maxW = oW
 		}
@@ -170,7 +218,7 @@ func (d *Display) surfaceToTexture(
 		panic("surface has wrong number of BytesPerPixel")
 	}
 	ret.TextureID = uint32(C.gen_texture(
-		&d.r.engine, glFormat, C.GLsizei(surface.W),
+		&r.engine, glFormat, C.GLsizei(surface.W),
 		C.GLsizei(surface.H), unsafe.Pointer(&surface.Pixels()[0])))
 	ret.Width = surface.W
 	ret.Height = surface.H
@@ -181,7 +229,7 @@ func (d *Display) surfaceToTexture(
 
 // LoadImageFile loads an image file from the specified path.
 // if an error is returned, the returned image is empty.
-func (d *Display) LoadImageFile(
+func (r *glRenderer) LoadImageFile(
 	location *url.URL, scaleDownToOutput bool) (render.Image, error) {
 	if location.Scheme != "file" {
 		panic("unsupported URL scheme: " + location.String())
@@ -190,12 +238,12 @@ func (d *Display) LoadImageFile(
 	if err != nil {
 		return render.EmptyImage(), err
 	}
-	return d.surfaceToTexture(surface, scaleDownToOutput)
+	return r.surfaceToTexture(surface, scaleDownToOutput)
 }
 
 // LoadImageMem loads an image from data in memory.
 // if an error is returned, the returned image is empty.
-func (d *Display) LoadImageMem(
+func (r *glRenderer) LoadImageMem(
 	data []byte, scaleDownToOutput bool) (render.Image, error) {
 	logoStream, err := sdl.RWFromMem(data)
 	if err != nil {
@@ -205,12 +253,12 @@ func (d *Display) LoadImageMem(
 	if err != nil {
 		panic(err)
 	}
-	return d.surfaceToTexture(logo, scaleDownToOutput)
+	return r.surfaceToTexture(logo, scaleDownToOutput)
 }
 
 // FreeImage destroys the texture associated with the image (if one exists)
 // and sets i to be the empty image. Does nothing on empty images.
-func (d *Display) FreeImage(i *render.Image) {
+func (r *glRenderer) FreeImage(i *render.Image) {
 	if !i.IsEmpty() {
 		C.glDeleteTextures(1, (*C.GLuint)(&i.TextureID))
 		i.Width = 0
@@ -219,22 +267,22 @@ func (d *Display) FreeImage(i *render.Image) {
 
 // DrawImage renders the given image if it is not empty, using the given
 // transformation. alpha modifies the image's opacity.
-func (d *Display) DrawImage(image render.Image, t render.Transform, alpha uint8) {
-	t = d.toInternalCoords(t, image.Flipped)
-	C.draw_image(&d.r.engine, C.GLuint(image.TextureID),
+func (r *glRenderer) DrawImage(image render.Image, t render.Transform, alpha uint8) {
+	t = r.toInternalCoords(t, image.Flipped)
+	C.draw_image(&r.engine, C.GLuint(image.TextureID),
 		(*C.float)(&t[0]), C.uint8_t(alpha), C.bool(image.HasAlpha))
 }
 
 // Unit is the scaled smallest unit in pixels.
-func (d *Display) Unit() int32 {
-	return d.r.unit
+func (r *glRenderer) Unit() int32 {
+	return r.unit
 }
 
 // RenderText renders the given text with the given font into an image with
 // transparent background.
 // Returns an empty image if it wasn't able to create the texture.
-func (d *Display) RenderText(text string, font api.Font) render.Image {
-	face := d.owner.Font(font.FamilyIndex, font.Style, font.Size)
+func (r *glRenderer) RenderText(text string, font api.Font) render.Image {
+	face := r.owner.Font(font.FamilyIndex, font.Style, font.Size)
 	// we give R,G,B intentionally in the wrong order because SDL_ttf renders
 	// the text in ARGB format, but we need ABGR for OpenGL ES.
 	bottomText, err := face.RenderUTF8Blended(
@@ -244,53 +292,62 @@ func (d *Display) RenderText(text string, font api.Font) render.Image {
 		panic(err)
 	}
 	bottomText.Format.Format = sdl.PIXELFORMAT_ABGR8888
-	ret, err := d.surfaceToTexture(bottomText, true)
+	ret, err := r.surfaceToTexture(bottomText, true)
 	if err != nil {
 		panic(err)
 	}
 	return ret
 }
 
-type canvas struct {
-	*renderer
+type glCanvas struct {
+	*glRenderer
 	prevFb       C.GLuint
 	fb, tex      C.GLuint
 	alpha        bool
 	prevW, prevH int32
 }
 
-func (c *canvas) Finish() (ret render.Image) {
+func (c *glCanvas) Finish() (ret render.Image) {
 	if c.fb == 0 {
 		panic("tried to finish already closed canvas")
 	}
-	C.finish_canvas(&c.renderer.engine, c.fb, c.prevFb)
+	C.finish_canvas(&c.glRenderer.engine, c.fb, c.prevFb)
 	c.fb = 0
 	ret = render.Image{Width: c.width, Height: c.height, TextureID: uint32(c.tex),
 		Flipped: true, HasAlpha: c.alpha}
-	c.renderer.width = c.prevW
-	c.renderer.height = c.prevH
+	c.glRenderer.width = c.prevW
+	c.glRenderer.height = c.prevH
 	C.glViewport(0, 0, C.GLsizei(c.width), C.GLsizei(c.height))
 	return
 }
 
-func (c *canvas) Close() {
+func (c *glCanvas) Close() {
 	if c.fb != 0 {
 		c.fb = 0
-		c.renderer.width = c.prevW
-		c.renderer.height = c.prevH
+		c.glRenderer.width = c.prevW
+		c.glRenderer.height = c.prevH
 		C.glViewport(0, 0, C.GLsizei(c.width), C.GLsizei(c.height))
-		C.destroy_canvas(&c.renderer.engine, c.fb, c.tex, c.prevFb)
+		C.destroy_canvas(&c.glRenderer.engine, c.fb, c.tex, c.prevFb)
 	}
 }
 
-func (d *Display) drawMasked(
+func (r *glRenderer) drawMasked(
 	bg api.Background, content render.Rectangle) bool {
 	if bg.TextureIndex == -1 {
 		return false
 	}
-	loadedTexture := &d.r.textureCache[bg.TextureIndex]
+	textures := r.owner.GetTextures()
+	if bg.TextureIndex >= len(textures) {
+		return false
+	}
+	if bg.TextureIndex >= len(r.textureCache) {
+		// textures may have been added after startup.
+		r.textureCache = append(r.textureCache, make([]render.Image,
+			len(textures)-len(r.textureCache))...)
+	}
+	loadedTexture := &r.textureCache[bg.TextureIndex]
 	if loadedTexture.IsEmpty() {
-		url := d.owner.GetTextures()[bg.TextureIndex].Location
+		url := textures[bg.TextureIndex].Location
 		if url.Scheme != "file" {
 			panic("unsupported URL scheme for texture: " + url.String())
 		}
@@ -314,67 +371,51 @@ func (d *Display) drawMasked(
 			panic("grayscale image has wrong number of bytes per pixel")
 		}
 		*loadedTexture = render.Image{
-			TextureID: uint32(C.gen_texture(&d.r.engine, C.GL_SINGLE_VALUE_COLOR,
+			TextureID: uint32(C.gen_texture(&r.engine, C.GL_SINGLE_VALUE_COLOR,
 				C.GLsizei(surface.W), C.GLsizei(surface.H),
 				unsafe.Pointer(&surface.Pixels()[0]))),
 			Width: surface.W, Height: surface.H, Flipped: false, HasAlpha: true}
 		surface.Free()
 	}
-	posTrans := d.toInternalCoords(content.Transformation(), false)
+	posTrans := r.toInternalCoords(content.Transformation(), false)
 	texTrans := render.Identity().Scale(
-		float32(d.r.width)/float32(loadedTexture.Width),
-		float32(d.r.height)/float32(loadedTexture.Height))
+		float32(r.width)/float32(loadedTexture.Width),
+		float32(r.height)/float32(loadedTexture.Height))
 	pColor := toArr(bg.Primary)
 	sColor := toArr(bg.Secondary)
-	C.draw_masked(&d.r.engine, C.GLuint(loadedTexture.TextureID),
+	C.draw_masked(&r.engine, C.GLuint(loadedTexture.TextureID),
 		(*C.float)(&posTrans[0]), (*C.float)(&texTrans[0]), &pColor[0], &sColor[0])
 	return true
 }
 
 // CreateCanvas creates a canvas to draw content into, and fills it with the
 // given background.
-func (d *Display) CreateCanvas(innerWidth, innerHeight int32,
+func (r *glRenderer) CreateCanvas(innerWidth, innerHeight int32,
 	bg api.Background, borders render.Directions) (c render.Canvas, content render.Rectangle) {
-	ret := &canvas{renderer: &d.r, prevW: d.r.width, prevH: d.r.height}
-	width, height := innerWidth, innerHeight
-	content = render.Rectangle{
-		X: 0, Y: 0, Width: innerWidth, Height: innerHeight}
-	if borders&render.East != 0 {
-		width += d.r.unit
-	}
-	if borders&render.West != 0 {
-		width += d.r.unit
-		content.X += d.r.unit
-	}
-	if borders&render.North != 0 {
-		height += d.r.unit
-	}
-	if borders&render.South != 0 {
-		height += d.r.unit
-		content.Y += d.r.unit
-	}
+	ret := &glCanvas{glRenderer: r, prevW: r.width, prevH: r.height}
+	width, height, content := withBorders(innerWidth, innerHeight, r.unit, borders)
 	ret.alpha = bg.Primary.A != 255 ||
 		(bg.TextureIndex != -1 && bg.Secondary.A != 255)
 
-	C.create_canvas(&d.r.engine, C.GLsizei(width), C.GLsizei(height),
+	C.create_canvas(&r.engine, C.GLsizei(width), C.GLsizei(height),
 		&ret.prevFb, &ret.fb, &ret.tex, C.bool(ret.alpha))
 	if ret.fb == 0 {
 		panic("failed to create canvas")
 	}
-	d.r.width, d.r.height = width, height
+	r.width, r.height = width, height
 
 	C.glViewport(0, 0, C.GLsizei(width), C.GLsizei(height))
 
-	if !d.drawMasked(bg, content) {
-		t := d.toInternalCoords(content.Transformation(), false)
+	if !r.drawMasked(bg, content) {
+		t := r.toInternalCoords(content.Transformation(), false)
 		pArr := toArr(bg.Primary)
-		C.draw_rect(&d.r.engine, (*C.float)(&t[0]), &pArr[0], true)
+		C.draw_rect(&r.engine, (*C.float)(&t[0]), &pArr[0], true)
 	}
 
 	c = ret
 	return
 }
 
-func (r *renderer) canvasCount() uint8 {
+func (r *glRenderer) canvasCount() uint8 {
 	return uint8(C.canvas_count(&r.engine))
 }
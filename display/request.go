@@ -0,0 +1,274 @@
+package display
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/QuestScreen/QuestScreen/app"
+	"github.com/QuestScreen/QuestScreen/shared"
+	"github.com/QuestScreen/api/server"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// maximum number of committed requests waiting for the display thread.
+// Further requests are rejected until the display thread catches up.
+const maxQueuedRequests = 32
+
+// batch holds the data sent with a request.
+type batch struct {
+	eventID   uint32
+	eventCode int32
+	outputs   []outputBatch
+}
+
+// outputBatch holds the data sent to a single output. data and configs are
+// indexed by module and nil if nothing has been sent.
+type outputBatch struct {
+	data, configs  []interface{}
+	enabledModules []bool
+	// transition from the current scene to the one built by the request; nil
+	// if the scene is replaced immediately.
+	sceneTransition *shared.SceneTransition
+}
+
+// requestQueue holds committed requests in the order they have been committed.
+type requestQueue struct {
+	mutex   sync.Mutex
+	batches []*batch
+}
+
+// Request is a pending message to the display thread.
+// Data sent with a request is collected until the request is committed, at
+// which point it is queued for the display thread.
+// A Request must be either committed or closed.
+type Request struct {
+	d *Display
+	b *batch
+}
+
+var errMultipleModuleConfigs = errors.New("Cannot send multiple configs to same module in one request")
+var errMultipleModuleData = errors.New("Cannot send multiple data objects to same module in one request")
+var errMultipleEnabledModules = errors.New("Cannot send multiple enabledModules lists in one request")
+var errAlreadyCommitted = errors.New("Request has already been committed")
+
+// StartRequest starts a new request to the display thread.
+// Returns an error if the display thread is overloaded with queued requests.
+func (d *Display) StartRequest(eventID uint32, eventCode int32) (Request,
+	server.Error) {
+	if eventID == sdl.FIRSTEVENT {
+		panic("illegal SDL event ID")
+	}
+	d.queue.mutex.Lock()
+	full := len(d.queue.batches) >= maxQueuedRequests
+	d.queue.mutex.Unlock()
+	if full {
+		return Request{}, &app.TooManyRequests{}
+	}
+	return Request{d: d, b: &batch{eventID: eventID, eventCode: eventCode,
+		outputs: make([]outputBatch, len(d.outputs))}}, nil
+}
+
+func (r *Request) output(index int) (*outputBatch, error) {
+	if r.b == nil {
+		return nil, errAlreadyCommitted
+	}
+	if index < 0 || index >= len(r.b.outputs) {
+		return nil, fmt.Errorf("Output index %d outside of range 0..%d", index, len(r.b.outputs)-1)
+	}
+	return &r.b.outputs[index], nil
+}
+
+func (r *Request) moduleSlot(output int, index shared.ModuleIndex,
+	config bool) (*interface{}, error) {
+	o, err := r.output(output)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= r.d.owner.NumModules() {
+		return nil, fmt.Errorf("Module index %d outside of range 0..%d", index, r.d.owner.NumModules()-1)
+	}
+	list := &o.data
+	if config {
+		list = &o.configs
+	}
+	if *list == nil {
+		*list = make([]interface{}, r.d.owner.NumModules())
+	}
+	return &(*list)[index], nil
+}
+
+// SendModuleConfig queues the given config for the module at the given ID on
+// the given output as part of the request.
+func (r *Request) SendModuleConfig(output int, index shared.ModuleIndex,
+	config interface{}) error {
+	slot, err := r.moduleSlot(output, index, true)
+	if err != nil {
+		return err
+	}
+	if *slot != nil {
+		return errMultipleModuleConfigs
+	}
+	*slot = config
+	return nil
+}
+
+// SendRendererData queues the given data for the module at the given ID on the
+// given output as part of the request. Whether the data is used for
+// RebuiltState or InitTransition depends on the event ID of the request.
+func (r *Request) SendRendererData(output int, index shared.ModuleIndex,
+	data interface{}) error {
+	slot, err := r.moduleSlot(output, index, false)
+	if err != nil {
+		return err
+	}
+	if *slot != nil {
+		return errMultipleModuleData
+	}
+	*slot = data
+	return nil
+}
+
+// SendEnabledModulesList queues the list of enabled modules of the given
+// output as part of the request.
+func (r *Request) SendEnabledModulesList(output int, value []bool) error {
+	o, err := r.output(output)
+	if err != nil {
+		return err
+	}
+	if o.enabledModules != nil {
+		return errMultipleEnabledModules
+	}
+	o.enabledModules = value
+	return nil
+}
+
+// SetSceneTransition sets the transition from the currently shown scene to the
+// scene built by the request on the given output. Without it, the output
+// changes to the new scene immediately.
+func (r *Request) SetSceneTransition(output int,
+	value shared.SceneTransition) error {
+	o, err := r.output(output)
+	if err != nil {
+		return err
+	}
+	o.sceneTransition = &value
+	return nil
+}
+
+// Commit queues the request for the display thread and wakes it up.
+func (r *Request) Commit() error {
+	if r.b == nil {
+		return errAlreadyCommitted
+	}
+	r.d.queue.mutex.Lock()
+	r.d.queue.batches = append(r.d.queue.batches, r.b)
+	r.d.queue.mutex.Unlock()
+	sdl.PushEvent(&sdl.UserEvent{Type: r.b.eventID, Code: r.b.eventCode})
+	r.b = nil
+	return nil
+}
+
+// Close closes the request. If the request has not been committed, the queued
+// data will be erased. This function is idempotent.
+func (r *Request) Close() {
+	r.b = nil
+}
+
+// apply hands the batch's data over to the outputs' module states.
+func (b *batch) apply(outputs []*output) {
+	for i, o := range outputs {
+		ob := &b.outputs[i]
+		for j := range ob.data {
+			if ob.data[j] != nil {
+				o.moduleStates[j].queuedData = ob.data[j]
+			}
+		}
+		for j := range ob.configs {
+			if ob.configs[j] != nil {
+				o.moduleStates[j].queuedConfig = ob.configs[j]
+			}
+		}
+		if ob.enabledModules != nil {
+			o.enabledModules = ob.enabledModules
+			o.initial = false
+		}
+	}
+}
+
+// processRequests processes all queued requests in order. Consecutive
+// requests that rebuild the scene are coalesced so that each module is rebuilt
+// at most once, with the most recent data and config sent to it. Consecutive
+// data updates of a module are all handed to the module in order since each
+// may only contain a partial change; only the transition of the last one is
+// animated while the earlier ones are finished immediately.
+// Returns true if any request has been processed.
+func (d *Display) processRequests() bool {
+	d.queue.mutex.Lock()
+	batches := d.queue.batches
+	d.queue.batches = nil
+	d.queue.mutex.Unlock()
+
+	rebuild, withConfig := false, false
+	// modules with pending data updates, in the order they were first updated.
+	var updated []shared.ModuleIndex
+	flush := func() {
+		if rebuild {
+			for _, o := range d.outputs {
+				o.activate()
+				o.rebuild(withConfig)
+			}
+		}
+		rebuild, withConfig = false, false
+		for _, index := range updated {
+			d.startTransition(index)
+		}
+		updated = updated[:0]
+	}
+	for _, b := range batches {
+		switch b.eventID {
+		case d.Events.ModuleUpdateID:
+			if rebuild {
+				flush()
+			}
+			index := shared.ModuleIndex(b.eventCode)
+			pending := false
+			for _, u := range updated {
+				if u == index {
+					pending = true
+					break
+				}
+			}
+			if pending {
+				// hand the previous data to the module before it is replaced. the
+				// transition started here is finished by the next one.
+				d.startTransition(index)
+			} else {
+				updated = append(updated, index)
+			}
+			b.apply(d.outputs)
+		case d.Events.LeaveGroupID:
+			flush()
+			for _, o := range d.outputs {
+				o.initial = true
+			}
+		default:
+			// SceneChangeID, ModuleConfigID and HeroesChangedID
+			if len(updated) > 0 {
+				flush()
+			}
+			for i, o := range d.outputs {
+				// captures the scene before any pending rebuild, which is the
+				// scene currently shown.
+				if t := b.outputs[i].sceneTransition; t != nil {
+					d.numTransitions += o.startSceneTransition(*t)
+				}
+			}
+			b.apply(d.outputs)
+			rebuild = true
+			withConfig = withConfig || b.eventID != d.Events.HeroesChangedID
+		}
+	}
+	flush()
+	return len(batches) > 0
+}
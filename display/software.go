@@ -0,0 +1,433 @@
+package display
+
+import (
+	"image"
+	"log"
+	"math"
+	"net/url"
+
+	"github.com/QuestScreen/QuestScreen/app"
+	"github.com/QuestScreen/api"
+	"github.com/QuestScreen/api/render"
+	"github.com/veandco/go-sdl2/img"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// softRenderer implements the backend in pure Go, rendering into an in-memory
+// framebuffer. It is used in headless mode where no OpenGL context is
+// available. Its output mirrors what the shaders in renderer.c produce.
+//
+// Images are stored with their top row first and are never flipped.
+// Pixel data is not premultiplied, like OpenGL textures.
+type softRenderer struct {
+	owner app.App
+	// screen is the framebuffer presented as frame; target is the framebuffer
+	// currently drawn into, which is screen unless a canvas is active.
+	screen, target *image.NRGBA
+	unit           int32
+	images         map[uint32]*image.NRGBA
+	nextID         uint32
+	canvases       uint8
+	textureCache   []*image.Gray
+}
+
+func newSoftRenderer(owner app.App, width, height int32) *softRenderer {
+	bounds := image.Rect(0, 0, int(width), int(height))
+	r := &softRenderer{owner: owner, screen: image.NewNRGBA(bounds),
+		unit: unitFor(width, height), images: make(map[uint32]*image.NRGBA),
+		nextID: 1, textureCache: make([]*image.Gray, len(owner.GetTextures()))}
+	r.target = r.screen
+	return r
+}
+
+func (r *softRenderer) close() {
+	r.images = nil
+	r.textureCache = nil
+}
+
+func (r *softRenderer) clear() {
+	pix := r.target.Pix
+	for i := range pix {
+		pix[i] = 255
+	}
+}
+
+func (r *softRenderer) activate() {}
+
+func (r *softRenderer) present() {}
+
+func (r *softRenderer) readPixels(dst *image.RGBA) {
+	// the screen is opaque, so its data is valid premultiplied RGBA.
+	copy(dst.Pix, r.screen.Pix)
+}
+
+func (r *softRenderer) canvasCount() uint8 {
+	return r.canvases
+}
+
+// OutputSize returns a rectangle that describes the dimensions in pixels
+// of the current rendering area. X and Y are always 0.
+func (r *softRenderer) OutputSize() render.Rectangle {
+	return render.Rectangle{X: 0, Y: 0, Width: int32(r.target.Rect.Dx()),
+		Height: int32(r.target.Rect.Dy())}
+}
+
+// Unit is the scaled smallest unit in pixels.
+func (r *softRenderer) Unit() int32 {
+	return r.unit
+}
+
+// rasterize calls fn for each pixel of the target whose center lies in the
+// square (-0.5, -0.5) -- (0.5, 0.5) transformed by t. pix contains the
+// pixel's RGBA values. u and v give the position of the pixel's center in the
+// square, ranging from 0 to 1, with (0, 0) being the upper left corner.
+func (r *softRenderer) rasterize(t render.Transform,
+	fn func(pix []uint8, u, v float32)) {
+	if t[0]*t[3]-t[1]*t[2] == 0 {
+		return
+	}
+	w, h := r.target.Rect.Dx(), r.target.Rect.Dy()
+	minX, minY := float32(math.MaxFloat32), float32(math.MaxFloat32)
+	maxX, maxY := -minX, -minY
+	for _, c := range [4][2]float32{
+		{-0.5, -0.5}, {0.5, -0.5}, {0.5, 0.5}, {-0.5, 0.5}} {
+		x := t[0]*c[0] + t[2]*c[1] + t[4]
+		y := t[1]*c[0] + t[3]*c[1] + t[5]
+		minX, maxX = float32(math.Min(float64(minX), float64(x))),
+			float32(math.Max(float64(maxX), float64(x)))
+		minY, maxY = float32(math.Min(float64(minY), float64(y))),
+			float32(math.Max(float64(maxY), float64(y)))
+	}
+	// the framebuffer's first row is the top row, while the renderer's
+	// coordinate system has its origin in the lower left corner.
+	x0 := clampInt(int(math.Floor(float64(minX))), 0, w)
+	x1 := clampInt(int(math.Ceil(float64(maxX))), 0, w)
+	y0 := clampInt(int(math.Floor(float64(float32(h)-maxY))), 0, h)
+	y1 := clampInt(int(math.Ceil(float64(float32(h)-minY))), 0, h)
+	inv := t.Invert()
+	for py := y0; py < y1; py++ {
+		cy := float32(h) - (float32(py) + 0.5)
+		for px := x0; px < x1; px++ {
+			cx := float32(px) + 0.5
+			sx := inv[0]*cx + inv[2]*cy + inv[4]
+			sy := inv[1]*cx + inv[3]*cy + inv[5]
+			if sx < -0.5 || sx >= 0.5 || sy <= -0.5 || sy > 0.5 {
+				continue
+			}
+			offset := r.target.PixOffset(px, py)
+			fn(r.target.Pix[offset:offset+4], sx+0.5, 0.5-sy)
+		}
+	}
+}
+
+func clampInt(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// blend draws the given color with the given alpha over dst. This is the
+// equivalent of the blend function used in renderer.c.
+func blend(dst []uint8, red, green, blue, alpha uint32) {
+	inv := 255 - alpha
+	dst[0] = uint8((red*alpha + uint32(dst[0])*inv + 127) / 255)
+	dst[1] = uint8((green*alpha + uint32(dst[1])*inv + 127) / 255)
+	dst[2] = uint8((blue*alpha + uint32(dst[2])*inv + 127) / 255)
+	dstAlpha := uint32(dst[3])
+	dst[3] = uint8((alpha*(255-dstAlpha)+127)/255 + dstAlpha)
+}
+
+// sample returns the color of the image at the given position, using bilinear
+// filtering. x and y are in pixels, positions outside the image are clamped.
+func sample(src *image.NRGBA, x, y float32) (ret [4]uint32) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	x, y = x-0.5, y-0.5
+	x0, y0 := int(math.Floor(float64(x))), int(math.Floor(float64(y)))
+	fx, fy := x-float32(x0), y-float32(y0)
+	var acc [4]float32
+	for _, p := range [4]struct {
+		dx, dy int
+		weight float32
+	}{{0, 0, (1 - fx) * (1 - fy)}, {1, 0, fx * (1 - fy)},
+		{0, 1, (1 - fx) * fy}, {1, 1, fx * fy}} {
+		offset := src.PixOffset(
+			clampInt(x0+p.dx, 0, w-1), clampInt(y0+p.dy, 0, h-1))
+		for i := 0; i < 4; i++ {
+			acc[i] += p.weight * float32(src.Pix[offset+i])
+		}
+	}
+	for i := range acc {
+		ret[i] = uint32(acc[i] + 0.5)
+	}
+	return
+}
+
+// FillRect fills the rectangle with the specified dimensions with the
+// specified color. The rectangle is positions via the given transformation.
+func (r *softRenderer) FillRect(t render.Transform, color api.RGBA) {
+	r.rasterize(t, func(pix []uint8, u, v float32) {
+		blend(pix, uint32(color.R), uint32(color.G), uint32(color.B),
+			uint32(color.A))
+	})
+}
+
+// DrawImage renders the given image if it is not empty, using the given
+// transformation. alpha modifies the image's opacity.
+func (r *softRenderer) DrawImage(image render.Image, t render.Transform,
+	alpha uint8) {
+	src, ok := r.images[image.TextureID]
+	if image.IsEmpty() || !ok {
+		return
+	}
+	w, h := float32(src.Rect.Dx()), float32(src.Rect.Dy())
+	r.rasterize(t, func(pix []uint8, u, v float32) {
+		if image.Flipped {
+			v = 1 - v
+		}
+		c := sample(src, u*w, v*h)
+		blend(pix, c[0], c[1], c[2], (c[3]*uint32(alpha)+127)/255)
+	})
+}
+
+// store registers the given image data and returns an Image referencing it.
+func (r *softRenderer) store(data *image.NRGBA, hasAlpha bool) render.Image {
+	id := r.nextID
+	r.nextID++
+	r.images[id] = data
+	return render.Image{TextureID: id, Width: int32(data.Rect.Dx()),
+		Height: int32(data.Rect.Dy()), Flipped: false, HasAlpha: hasAlpha}
+}
+
+// scaleDown scales the image down so that it fits into the given size while
+// preserving the aspect ratio. Returns the image itself if it already fits.
+func scaleDown(src *image.NRGBA, maxW, maxH int) *image.NRGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	factor := float32(maxW) / float32(w)
+	if tmp := float32(maxH) / float32(h); tmp < factor {
+		factor = tmp
+	}
+	if factor >= 1.0 {
+		return src
+	}
+	ret := image.NewNRGBA(image.Rect(0, 0,
+		int(float32(w)*factor), int(float32(h)*factor)))
+	for y := 0; y < ret.Rect.Dy(); y++ {
+		for x := 0; x < ret.Rect.Dx(); x++ {
+			c := sample(src, (float32(x)+0.5)/factor, (float32(y)+0.5)/factor)
+			offset := ret.PixOffset(x, y)
+			for i := 0; i < 4; i++ {
+				ret.Pix[offset+i] = uint8(c[i])
+			}
+		}
+	}
+	return ret
+}
+
+func (r *softRenderer) surfaceToImage(
+	surface *sdl.Surface, scaleDownToOutput bool) (render.Image, error) {
+	// like the OpenGL renderer, only RGB surfaces are considered opaque.
+	hasAlpha := surface.Format.Format != sdl.PIXELFORMAT_BGR888 &&
+		surface.Format.Format != sdl.PIXELFORMAT_RGB888
+	converted, err := surface.ConvertFormat(sdl.PIXELFORMAT_RGBA32, 0)
+	surface.Free()
+	if err != nil {
+		return render.EmptyImage(), err
+	}
+	defer converted.Free()
+	data := image.NewNRGBA(image.Rect(0, 0, int(converted.W), int(converted.H)))
+	pixels := converted.Pixels()
+	for y := 0; y < data.Rect.Dy(); y++ {
+		copy(data.Pix[y*data.Stride:(y+1)*data.Stride],
+			pixels[y*int(converted.Pitch):])
+	}
+	if scaleDownToOutput {
+		// don't use the target's size because we may be in a Canvas.
+		data = scaleDown(data, r.screen.Rect.Dx(), r.screen.Rect.Dy())
+	}
+	return r.store(data, hasAlpha), nil
+}
+
+// LoadImageFile loads an image file from the specified path.
+// if an error is returned, the returned image is empty.
+func (r *softRenderer) LoadImageFile(
+	location *url.URL, scaleDownToOutput bool) (render.Image, error) {
+	if location.Scheme != "file" {
+		panic("unsupported URL scheme: " + location.String())
+	}
+	surface, err := img.Load(location.Path)
+	if err != nil {
+		return render.EmptyImage(), err
+	}
+	return r.surfaceToImage(surface, scaleDownToOutput)
+}
+
+// LoadImageMem loads an image from data in memory.
+// if an error is returned, the returned image is empty.
+func (r *softRenderer) LoadImageMem(
+	data []byte, scaleDownToOutput bool) (render.Image, error) {
+	stream, err := sdl.RWFromMem(data)
+	if err != nil {
+		return render.EmptyImage(), err
+	}
+	surface, err := img.LoadRW(stream, true)
+	if err != nil {
+		return render.EmptyImage(), err
+	}
+	return r.surfaceToImage(surface, scaleDownToOutput)
+}
+
+// FreeImage removes the image data associated with the image (if it exists)
+// and sets i to be the empty image. Does nothing on empty images.
+func (r *softRenderer) FreeImage(i *render.Image) {
+	if !i.IsEmpty() {
+		delete(r.images, i.TextureID)
+		i.Width = 0
+	}
+}
+
+// RenderText renders the given text with the given font into an image with
+// transparent background.
+// Returns an empty image if it wasn't able to create the image.
+func (r *softRenderer) RenderText(text string, font api.Font) render.Image {
+	face := r.owner.Font(font.FamilyIndex, font.Style, font.Size)
+	surface, err := face.RenderUTF8Blended(
+		text, sdl.Color{R: font.Color.R, G: font.Color.G, B: font.Color.B,
+			A: font.Color.A})
+	if err != nil {
+		panic(err)
+	}
+	ret, err := r.surfaceToImage(surface, true)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+type softCanvas struct {
+	r            *softRenderer
+	prev, target *image.NRGBA
+	alpha        bool
+}
+
+func (c *softCanvas) Finish() render.Image {
+	if c.target == nil {
+		panic("tried to finish already closed canvas")
+	}
+	ret := c.r.store(c.target, c.alpha)
+	c.Close()
+	return ret
+}
+
+func (c *softCanvas) Close() {
+	if c.target != nil {
+		c.target = nil
+		c.r.target = c.prev
+		c.r.canvases--
+	}
+}
+
+// loadMask loads the texture with the given index as grayscale image.
+func (r *softRenderer) loadMask(index int) *image.Gray {
+	textures := r.owner.GetTextures()
+	if index >= len(textures) {
+		return nil
+	}
+	if index >= len(r.textureCache) {
+		// textures may have been added after startup.
+		r.textureCache = append(r.textureCache, make([]*image.Gray,
+			len(textures)-len(r.textureCache))...)
+	}
+	if r.textureCache[index] != nil {
+		return r.textureCache[index]
+	}
+	url := textures[index].Location
+	if url.Scheme != "file" {
+		panic("unsupported URL scheme for texture: " + url.String())
+	}
+	surface, err := img.Load(url.Path)
+	if err != nil {
+		log.Printf("unable to load %s: %s\n", url.Path, err.Error())
+		return nil
+	}
+	defer surface.Free()
+	if surface.Format.Format != sdl.PIXELFORMAT_INDEX8 {
+		grayscale, err := surface.ConvertFormat(sdl.PIXELFORMAT_INDEX8, 0)
+		if err != nil {
+			log.Printf("could not convert %s to grayscale: %s\n", url.Path,
+				err.Error())
+			return nil
+		}
+		surface.Free()
+		surface = grayscale
+	}
+	if surface.Format.BytesPerPixel != 1 {
+		panic("grayscale image has wrong number of bytes per pixel")
+	}
+	mask := image.NewGray(image.Rect(0, 0, int(surface.W), int(surface.H)))
+	pixels := surface.Pixels()
+	for y := 0; y < mask.Rect.Dy(); y++ {
+		copy(mask.Pix[y*mask.Stride:(y+1)*mask.Stride],
+			pixels[y*int(surface.Pitch):])
+	}
+	r.textureCache[index] = mask
+	return mask
+}
+
+func mix(primary, secondary uint8, a uint32) uint8 {
+	return uint8((uint32(primary)*a + uint32(secondary)*(255-a) + 127) / 255)
+}
+
+func (r *softRenderer) drawMasked(
+	bg api.Background, content render.Rectangle) bool {
+	if bg.TextureIndex == -1 {
+		return false
+	}
+	mask := r.loadMask(bg.TextureIndex)
+	if mask == nil {
+		return false
+	}
+	// the mask is repeated in its original size over the whole canvas.
+	w, h := float32(r.target.Rect.Dx()), float32(r.target.Rect.Dy())
+	mw, mh := mask.Rect.Dx(), mask.Rect.Dy()
+	p, s := bg.Primary, bg.Secondary
+	r.rasterize(content.Transformation(), func(pix []uint8, u, v float32) {
+		a := uint32(mask.Pix[mask.PixOffset(int(u*w)%mw, int(v*h)%mh)])
+		pix[0], pix[1] = mix(p.R, s.R, a), mix(p.G, s.G, a)
+		pix[2], pix[3] = mix(p.B, s.B, a), mix(p.A, s.A, a)
+	})
+	return true
+}
+
+// CreateCanvas creates a canvas to draw content into, and fills it with the
+// given background.
+func (r *softRenderer) CreateCanvas(innerWidth, innerHeight int32,
+	bg api.Background, borders render.Directions) (c render.Canvas, content render.Rectangle) {
+	width, height, content := withBorders(innerWidth, innerHeight, r.unit, borders)
+	if width <= 0 || height <= 0 {
+		panic("failed to create canvas")
+	}
+	ret := &softCanvas{r: r, prev: r.target,
+		target: image.NewNRGBA(image.Rect(0, 0, int(width), int(height))),
+		alpha: bg.Primary.A != 255 ||
+			(bg.TextureIndex != -1 && bg.Secondary.A != 255)}
+	// like the OpenGL renderer, start with opaque black.
+	for i := 3; i < len(ret.target.Pix); i += 4 {
+		ret.target.Pix[i] = 255
+	}
+	r.target = ret.target
+	r.canvases++
+
+	if !r.drawMasked(bg, content) {
+		col := bg.Primary
+		r.rasterize(content.Transformation(), func(pix []uint8, u, v float32) {
+			pix[0], pix[1], pix[2], pix[3] = col.R, col.G, col.B, col.A
+		})
+	}
+
+	c = ret
+	return
+}
@@ -0,0 +1,197 @@
+package display
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/QuestScreen/QuestScreen/app"
+	"github.com/QuestScreen/api"
+	"github.com/QuestScreen/api/render"
+	"github.com/QuestScreen/api/resources"
+)
+
+var update = flag.Bool("update", false, "write rendered frames as golden images")
+
+// maximum difference of a color channel to the golden image. Allows for
+// rounding differences of floating point operations between architectures.
+const goldenTolerance = 2
+
+// testOwner implements the parts of app.App used by the software renderer.
+type testOwner struct {
+	app.App
+	textures []resources.Resource
+}
+
+func (o *testOwner) GetTextures() []resources.Resource {
+	return o.textures
+}
+
+// checkerboard creates a 4x4 image with four colored quadrants, one of them
+// half transparent.
+func checkerboard(r *softRenderer) render.Image {
+	data := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	colors := [4][4]uint8{
+		{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {0, 0, 0, 128}}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			copy(data.Pix[data.PixOffset(x, y):], colors[y/2*2+x/2][:])
+		}
+	}
+	return r.store(data, true)
+}
+
+var goldenScenes = []struct {
+	name string
+	draw func(r *softRenderer)
+}{
+	{"rects", func(r *softRenderer) {
+		r.FillRect(render.Rectangle{X: 10, Y: 10, Width: 60, Height: 40}.
+			Transformation(), api.RGBA{R: 200, G: 30, B: 30, A: 255})
+		r.FillRect(render.Rectangle{X: 50, Y: 30, Width: 80, Height: 60}.
+			Translation().Rotate(math.Pi/6).Scale(80, 60),
+			api.RGBA{R: 30, G: 30, B: 200, A: 128})
+		// partly outside of the screen.
+		r.FillRect(render.Rectangle{X: 170, Y: 120, Width: 40, Height: 40}.
+			Transformation(), api.RGBA{R: 30, G: 160, B: 30, A: 255})
+	}},
+	{"images", func(r *softRenderer) {
+		img := checkerboard(r)
+		img.Draw(r, render.Rectangle{X: 8, Y: 72, Width: 64, Height: 64}, 255)
+		flipped := img
+		flipped.Flipped = true
+		flipped.Draw(r, render.Rectangle{X: 80, Y: 72, Width: 32, Height: 64},
+			128)
+		r.DrawImage(img, render.Rectangle{X: 100, Y: 8, Width: 48, Height: 48}.
+			Translation().Rotate(math.Pi/4).Scale(48, 48), 255)
+	}},
+	{"canvas", func(r *softRenderer) {
+		c, content := r.CreateCanvas(80, 60, api.Background{
+			Primary: api.RGBA{R: 0, G: 128, B: 0, A: 200}, TextureIndex: -1},
+			render.North|render.West)
+		r.FillRect(content.Shrink(20, 20).Transformation(),
+			api.RGBA{R: 255, G: 255, B: 0, A: 255})
+		img := c.Finish()
+		if r.canvasCount() != 0 {
+			panic("canvas has not been closed")
+		}
+		img.Draw(r, render.Rectangle{X: 20, Y: 20, Width: img.Width,
+			Height: img.Height}, 255)
+		img.Draw(r, render.Rectangle{X: 100, Y: 60, Width: img.Width / 2,
+			Height: img.Height / 2}, 255)
+	}},
+	{"mask", func(r *softRenderer) {
+		// a diagonal gradient, preloaded so that no file is read.
+		mask := image.NewGray(image.Rect(0, 0, 16, 16))
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				mask.Pix[mask.PixOffset(x, y)] = uint8((x + y) * 8)
+			}
+		}
+		r.textureCache = []*image.Gray{mask}
+		c, _ := r.CreateCanvas(96, 64, api.Background{
+			Primary:   api.RGBA{R: 255, G: 128, B: 0, A: 255},
+			Secondary: api.RGBA{R: 0, G: 0, B: 128, A: 255}, TextureIndex: 0},
+			render.South|render.East)
+		img := c.Finish()
+		img.Draw(r, render.Rectangle{X: 48, Y: 40, Width: img.Width,
+			Height: img.Height}, 255)
+	}},
+}
+
+func TestSoftRendererGolden(t *testing.T) {
+	owner := &testOwner{textures: []resources.Resource{{Name: "gradient",
+		Location: &url.URL{Scheme: "file", Path: "/nonexistent/gradient.png"}}}}
+	for _, scene := range goldenScenes {
+		t.Run(scene.name, func(t *testing.T) {
+			r := newSoftRenderer(owner, 192, 144)
+			defer r.close()
+			r.clear()
+			scene.draw(r)
+			frame := image.NewRGBA(r.screen.Rect)
+			r.readPixels(frame)
+
+			path := filepath.Join("testdata", scene.name+".png")
+			if *update {
+				if err := writePNG(path, frame); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			golden, err := readPNG(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareImages(t, golden, frame)
+		})
+	}
+}
+
+func writePNG(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func readPNG(path string) (*image.RGBA, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	ret := image.NewRGBA(img.Bounds())
+	for y := ret.Rect.Min.Y; y < ret.Rect.Max.Y; y++ {
+		for x := ret.Rect.Min.X; x < ret.Rect.Max.X; x++ {
+			ret.Set(x, y, img.At(x, y))
+		}
+	}
+	return ret, nil
+}
+
+// compareImages reports the first pixels in which the given images differ by
+// more than goldenTolerance.
+func compareImages(t *testing.T, expected, actual *image.RGBA) {
+	t.Helper()
+	if expected.Rect != actual.Rect {
+		t.Fatalf("expected size %v, got %v", expected.Rect, actual.Rect)
+	}
+	diffs := 0
+	for y := 0; y < actual.Rect.Dy(); y++ {
+		for x := 0; x < actual.Rect.Dx(); x++ {
+			offset := actual.PixOffset(x, y)
+			e := expected.Pix[offset : offset+4]
+			a := actual.Pix[offset : offset+4]
+			for i := range a {
+				if d := int(a[i]) - int(e[i]); d > goldenTolerance ||
+					d < -goldenTolerance {
+					if diffs < 10 {
+						t.Errorf("pixel (%d, %d): expected %v, got %v", x, y, e, a)
+					}
+					diffs++
+					break
+				}
+			}
+		}
+	}
+	if diffs > 0 {
+		t.Errorf("%d pixels differ from the golden image", diffs)
+	}
+}
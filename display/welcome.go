@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"github.com/QuestScreen/QuestScreen/assets"
+	"github.com/QuestScreen/QuestScreen/qrcode"
 	"github.com/QuestScreen/api"
 	"github.com/QuestScreen/api/render"
 
@@ -42,59 +43,171 @@ func getIPAddresses() ([]string, error) {
 
 var fontColor = sdl.Color{R: 0, G: 0, B: 0, A: 200}
 
-func (d *Display) renderIPHint(font api.Font, ip string,
-	portPart string, frame *render.Rectangle) {
-	ipHint := d.RenderText("http://"+ip+portPart, font)
+// Welcome describes the information shown on the welcome screen.
+type Welcome struct {
+	// Port the web client is served on.
+	Port uint16
+	// HTTPS is true if the web client is served via HTTPS.
+	HTTPS bool
+	// PairingCode is the code players need to pair their device.
+	// It is not shown if empty.
+	PairingCode string
+	// Hostname is the name the host is advertised as via mDNS.
+	// It is not shown if empty.
+	Hostname string
+	// WiFi is the content of a QR code for joining the local Wi-Fi network.
+	// It is not shown if empty.
+	WiFi string
+}
+
+func (o *output) renderHint(font api.Font, text string,
+	frame *render.Rectangle) {
+	hint := o.RenderText(text, font)
 	var row render.Rectangle
-	row, *frame = frame.Carve(render.North, ipHint.Height+4*d.r.unit)
-	ipArea := row.Position(ipHint.Width, ipHint.Height,
+	row, *frame = frame.Carve(render.North, hint.Height+4*o.Unit())
+	area := row.Position(hint.Width, hint.Height,
 		render.Center, render.Middle)
-	ipHint.Draw(d, ipArea, 255)
-	d.FreeImage(&ipHint)
+	hint.Draw(o, area, 255)
+	o.FreeImage(&hint)
 }
 
-func (d *Display) genWelcome(frame render.Rectangle, port uint16) error {
-	c, _ := d.CreateCanvas(frame.Width, frame.Height,
+// width of the quiet zone around a QR code, in modules.
+const qrQuietZone = 4
+
+// renderQRCode draws the given QR code as large as possible into the given
+// area, with a caption below it if font is not nil.
+func (o *output) renderQRCode(code *qrcode.Code, caption string,
+	font *api.Font, area render.Rectangle) {
+	if font != nil {
+		text := o.RenderText(caption, *font)
+		var row render.Rectangle
+		row, area = area.Carve(render.South, text.Height+2*o.Unit())
+		text.Draw(o, row.Position(text.Width, text.Height, render.Center,
+			render.Top), 255)
+		o.FreeImage(&text)
+	}
+	modules := int32(code.Size + 2*qrQuietZone)
+	moduleSize := area.Width / modules
+	if area.Height/modules < moduleSize {
+		moduleSize = area.Height / modules
+	}
+	if moduleSize == 0 {
+		log.Println("not enough space for QR code: " + caption)
+		return
+	}
+	// the canvas background is white, so only dark modules are drawn.
+	// horizontal runs of dark modules are merged into a single rectangle.
+	qrArea := area.Position(modules*moduleSize, modules*moduleSize,
+		render.Center, render.Middle)
+	black := api.RGBA{R: 0, G: 0, B: 0, A: 255}
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; {
+			if !code.Dark(x, y) {
+				x++
+				continue
+			}
+			start := x
+			for x < code.Size && code.Dark(x, y) {
+				x++
+			}
+			render.Rectangle{
+				X: qrArea.X + int32(qrQuietZone+start)*moduleSize,
+				Y: qrArea.Y + qrArea.Height -
+					int32(qrQuietZone+y+1)*moduleSize,
+				Width: int32(x-start) * moduleSize, Height: moduleSize,
+			}.Fill(o, black)
+		}
+	}
+}
+
+// genWelcome generates the welcome screen showing the addresses of the web
+// client including its mDNS host name, and the pairing code for players if not empty. A QR code of the
+// primary address, and of the Wi-Fi network if configured, is shown below.
+func (o *output) genWelcome(frame render.Rectangle, info Welcome) error {
+	c, _ := o.CreateCanvas(frame.Width, frame.Height,
 		api.RGBA{R: 255, G: 255, B: 255, A: 255}.AsBackground(),
 		render.Nowhere)
 	defer c.Close()
 
 	logoRow, frame := frame.Carve(render.North, frame.Height/3)
-	logoTex, err := d.LoadImageMem(
+	logoTex, err := o.LoadImageMem(
 		assets.MustAsset("android-chrome-512x512.png"), true)
 	if err != nil {
 		panic("while generating welcome screen: " + err.Error())
 	}
 	logoArea := logoRow.Position(logoTex.Width, logoTex.Height,
 		render.Center, render.Middle)
-	heightWithMargin := logoArea.Height + 4*d.r.unit
+	heightWithMargin := logoArea.Height + 4*o.Unit()
 	if heightWithMargin > logoRow.Height {
 		logoArea = logoArea.Scale(float32(logoRow.Height) / float32(heightWithMargin))
 	}
-	logoTex.Draw(d, logoArea, 255)
+	logoTex.Draw(o, logoArea, 255)
 
-	if d.owner.NumFontFamilies() > 0 {
+	ips, err := getIPAddresses()
+	if err != nil {
+		log.Println("while getting IPs: " + err.Error())
+	}
+	scheme := "http://"
+	if info.HTTPS {
+		scheme = "https://"
+	}
+	port := strconv.Itoa(int(info.Port))
+
+	var captionFont *api.Font
+	if o.owner.NumFontFamilies() > 0 {
 		fontFace := api.Font{FamilyIndex: 0, Size: api.LargeFont,
 			Style: api.RegularFont, Color: api.RGBA{R: 0, G: 0, B: 0, A: 255}}
-		titleTex := d.RenderText("QuestScreen", fontFace)
-		defer d.FreeImage(&titleTex)
-		titleRow, frame := frame.Carve(render.North, titleTex.Height+4*d.r.unit)
+		titleTex := o.RenderText("QuestScreen", fontFace)
+		defer o.FreeImage(&titleTex)
+		var titleRow render.Rectangle
+		titleRow, frame = frame.Carve(render.North, titleTex.Height+4*o.Unit())
 		texFrame := titleRow.Position(titleTex.Width, titleTex.Height,
 			render.Center, render.Middle)
-		titleTex.Draw(d, texFrame, 255)
+		titleTex.Draw(o, texFrame, 255)
 
 		fontFace.Size = api.HeadingFont
-		ips, err := getIPAddresses()
-		portPart := ":" + strconv.Itoa(int(port)) + "/"
-		if err == nil {
-			for i := range ips {
-				d.renderIPHint(fontFace, ips[i], portPart, &frame)
-			}
+		if info.Hostname != "" {
+			o.renderHint(fontFace,
+				scheme+net.JoinHostPort(info.Hostname, port)+"/", &frame)
+		}
+		for i := range ips {
+			o.renderHint(fontFace,
+				scheme+net.JoinHostPort(ips[i], port)+"/", &frame)
+		}
+		if info.PairingCode != "" {
+			o.renderHint(fontFace, "Pairing code: "+info.PairingCode, &frame)
+		}
+		fontFace.Size = api.ContentFont
+		captionFont = &fontFace
+	}
+
+	var codes []*qrcode.Code
+	var captions []string
+	if len(ips) > 0 {
+		code, err := qrcode.Encode(
+			[]byte(scheme+net.JoinHostPort(ips[0], port)+"/"), qrcode.Medium)
+		if err != nil {
+			log.Println("while encoding URL QR code: " + err.Error())
 		} else {
-			log.Println("while getting IPs: " + err.Error())
+			codes = append(codes, code)
+			captions = append(captions, "Web Client")
 		}
 	}
-	d.welcomeTexture = c.Finish()
+	if info.WiFi != "" {
+		code, err := qrcode.Encode([]byte(info.WiFi), qrcode.Medium)
+		if err != nil {
+			log.Println("while encoding Wi-Fi QR code: " + err.Error())
+		} else {
+			codes = append(codes, code)
+			captions = append(captions, "Wi-Fi")
+		}
+	}
+	for i := range codes {
+		var area render.Rectangle
+		area, frame = frame.Carve(render.West, frame.Width/int32(len(codes)-i))
+		o.renderQRCode(codes[i], captions[i], captionFont, area)
+	}
+	o.welcomeTexture = c.Finish()
 
 	return nil
 }
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuestScreen/QuestScreen/shared"
+	"github.com/QuestScreen/api/comms"
+	"gopkg.in/yaml.v3"
+)
+
+// role describes what a client is allowed to do.
+type role int
+
+const (
+	// anonymous clients may only log in or pair their device.
+	anonymousRole role = iota
+	// players may view state and data, and use whitelisted module endpoints.
+	playerRole
+	// the GM has full access.
+	gmRole
+)
+
+// String returns the name of the role as used in the config and the API.
+func (r role) String() string {
+	switch r {
+	case playerRole:
+		return "player"
+	case gmRole:
+		return "gm"
+	default:
+		return ""
+	}
+}
+
+func parseRole(name string) (role, error) {
+	switch name {
+	case "player":
+		return playerRole, nil
+	case "gm":
+		return gmRole, nil
+	default:
+		return anonymousRole, fmt.Errorf("unknown role: %s", name)
+	}
+}
+
+// tokenCookie is the name of the cookie a client's token is stored in.
+// The cookie is required for requests that cannot set the Authorization
+// header, like loading images or subscribing to /events.
+const tokenCookie = "questscreen-token"
+
+// after this many failed login or pairing attempts from the same address,
+// further attempts from that address at the same endpoint are rejected for
+// lockoutDuration. Failures are forgotten after lockoutDuration.
+const (
+	maxFailedAttempts = 5
+	lockoutDuration   = time.Minute
+)
+
+// paths players may GET.
+var playerViews = []string{"/static", "/data", "/state", "/events",
+	"/display/snapshot", "/display/stream"}
+
+// pairedDevice is a device that has been issued a token via login or pairing.
+// Only the hash of the token is stored.
+type pairedDevice struct {
+	ID        string
+	Name      string
+	Role      string
+	TokenHash string `yaml:"tokenHash"`
+}
+
+// authenticator checks the permissions of requests and issues tokens.
+// Paired devices are persisted in the data directory so that they stay
+// paired across restarts.
+//
+// If no GM password is configured, authentication is disabled and every
+// client has the GM role.
+type authenticator struct {
+	mutex  sync.Mutex
+	config authConfig
+	path   string
+	// maps hashes of the configured tokens to their roles.
+	configured  map[string]role
+	paired      []pairedDevice
+	pairingCode string
+	lockouts    map[lockoutKey]*lockout
+}
+
+// lockoutKey identifies the remote address and endpoint failed attempts are
+// counted for, so that failing at pairing does not lock out the GM's login.
+type lockoutKey struct {
+	endpoint, address string
+}
+
+// lockout tracks failed attempts of a lockoutKey.
+type lockout struct {
+	failures                 int
+	lastFailure, lockedUntil time.Time
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAuthenticator(config authConfig, path string) *authenticator {
+	a := &authenticator{config: config, path: path,
+		configured: make(map[string]role),
+		lockouts:   make(map[lockoutKey]*lockout)}
+	if !a.enabled() {
+		return a
+	}
+	for _, d := range config.Devices {
+		// roles have been validated when loading the config.
+		r, _ := parseRole(d.Role)
+		a.configured[hashToken(d.Token)] = r
+	}
+	input, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err = yaml.Unmarshal(input, &a.paired); err != nil {
+			log.Printf("[auth] unable to read %s: %s\n", path, err.Error())
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("[auth] unable to read %s: %s\n", path, err.Error())
+	}
+	code, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	a.pairingCode = fmt.Sprintf("%06d", code.Int64())
+	return a
+}
+
+func (a *authenticator) enabled() bool {
+	return a.config.Password != ""
+}
+
+// PairingCode returns the code players need to pair their device, or the
+// empty string if authentication is disabled.
+func (a *authenticator) PairingCode() string {
+	return a.pairingCode
+}
+
+// roleOf returns the role of the client that sent the given request.
+func (a *authenticator) roleOf(r *http.Request) role {
+	if !a.enabled() {
+		return gmRole
+	}
+	var token string
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = header[len("Bearer "):]
+	} else if cookie, err := r.Cookie(tokenCookie); err == nil {
+		token = cookie.Value
+	}
+	if token == "" {
+		return anonymousRole
+	}
+	hash := hashToken(token)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if ret, ok := a.configured[hash]; ok {
+		return ret
+	}
+	for i := range a.paired {
+		if a.paired[i].TokenHash == hash {
+			ret, _ := parseRole(a.paired[i].Role)
+			return ret
+		}
+	}
+	return anonymousRole
+}
+
+// permits returns whether a client with the given role may access the given
+// path with the given method.
+func (a *authenticator) permits(r role, method httpMethods, path string) bool {
+	switch r {
+	case gmRole:
+		return true
+	case playerRole:
+		if method == httpGet {
+			for _, view := range playerViews {
+				if path == view {
+					return true
+				}
+			}
+		} else if method == httpPost {
+			for _, e := range a.config.PlayerEndpoints {
+				prefix := "/state/" + strings.Trim(e, "/")
+				if path == prefix || strings.HasPrefix(path, prefix+"/") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// authorize checks whether the given request is permitted. If not, it writes
+// an error response and returns false.
+func (a *authenticator) authorize(w http.ResponseWriter, r *http.Request,
+	method httpMethods, name string) bool {
+	cr := a.roleOf(r)
+	if a.permits(cr, method, r.URL.Path) {
+		return true
+	}
+	if cr == anonymousRole {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, fmt.Sprintf("[401] %s: authentication required", name),
+			http.StatusUnauthorized)
+	} else {
+		http.Error(w, fmt.Sprintf("[403] %s: not permitted for role %s", name,
+			cr), http.StatusForbidden)
+	}
+	return false
+}
+
+// protect wraps a handler so that it is only called for permitted requests.
+// Handlers registered via reg check permissions themselves.
+func (a *authenticator) protect(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.authorize(w, r, parseMethod(r.Method), name) {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// attempt checks the given secret against the expected one. Repeated failures
+// lead to all attempts from the request's remote address at the given endpoint
+// being rejected for some time. Must be called with the mutex locked.
+func (a *authenticator) attempt(r *http.Request, endpoint string,
+	given, expected string) (ok, locked bool) {
+	now := time.Now()
+	for key, l := range a.lockouts {
+		if now.After(l.lockedUntil) && now.Sub(l.lastFailure) > lockoutDuration {
+			delete(a.lockouts, key)
+		}
+	}
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	key := lockoutKey{endpoint: endpoint, address: address}
+	l := a.lockouts[key]
+	if l != nil && now.Before(l.lockedUntil) {
+		return false, true
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1 {
+		delete(a.lockouts, key)
+		return true, false
+	}
+	if l == nil {
+		l = &lockout{}
+		a.lockouts[key] = l
+	}
+	l.failures++
+	l.lastFailure = now
+	if l.failures >= maxFailedAttempts {
+		log.Printf("[auth] too many failed attempts at %s from %s, locking for %s\n",
+			endpoint, address, lockoutDuration)
+		l.failures = 0
+		l.lockedUntil = now.Add(lockoutDuration)
+	}
+	return false, false
+}
+
+// issue creates a new token for a device with the given name and role.
+// Must be called with the mutex locked.
+func (a *authenticator) issue(name string, r role) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	hash := hashToken(token)
+	a.paired = append(a.paired, pairedDevice{
+		ID: hash[:12], Name: name, Role: r.String(), TokenHash: hash})
+	if err := a.persist(); err != nil {
+		a.paired = a.paired[:len(a.paired)-1]
+		return "", err
+	}
+	log.Printf("[auth] issued %s token to %s\n", r, name)
+	return token, nil
+}
+
+// persist writes the list of paired devices. Must be called with the mutex
+// locked.
+func (a *authenticator) persist() error {
+	output, err := yaml.Marshal(a.paired)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.path, output, 0600)
+}
+
+// authEndpoints implements the /auth endpoints. It is not registered via reg
+// since it needs the request's credentials and sets the token cookie.
+type authEndpoints struct {
+	auth *authenticator
+	// whether the token cookie may only be sent via HTTPS.
+	secure bool
+}
+
+func (ae *authEndpoints) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Clacks-Overhead", "GNU Terry Pratchett")
+	method := parseMethod(r.Method)
+	var allowed httpMethods
+	var handle func(w http.ResponseWriter, r *http.Request)
+	switch path := r.URL.Path; {
+	case path == "/auth":
+		allowed, handle = httpGet, ae.status
+	case path == "/auth/login":
+		allowed, handle = httpPost, ae.login
+	case path == "/auth/pair":
+		allowed, handle = httpPost, ae.pair
+	case path == "/auth/devices":
+		allowed, handle = httpGet, ae.devices
+	case strings.HasPrefix(path, "/auth/devices/"):
+		allowed, handle = httpDelete, ae.revoke
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if method&allowed == 0 {
+		http.Error(w, fmt.Sprintf(
+			"[405] AuthHandler: Method not allowed (supports %s, got %s)",
+			allowed, method), http.StatusMethodNotAllowed)
+		return
+	}
+	handle(w, r)
+}
+
+func (ae *authEndpoints) status(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, shared.AuthStatus{Enabled: ae.auth.enabled(),
+		Role: ae.auth.roleOf(r).String()})
+}
+
+func receiveAuthRequest(w http.ResponseWriter, r *http.Request,
+	target interface{}) bool {
+	raw, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 4096))
+	if err == nil {
+		err = comms.ReceiveData(raw, &comms.ValidatedStruct{Value: target})
+	}
+	if err != nil {
+		http.Error(w, "[400] AuthHandler: received invalid data:\n  "+
+			err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// grant checks the given secret and issues a token with the given role if it
+// matches. The token is sent both in the response and as cookie.
+func (ae *authEndpoints) grant(w http.ResponseWriter, req *http.Request,
+	endpoint, given, expected, name string, r role) {
+	if !ae.auth.enabled() {
+		sendJSON(w, shared.AuthStatus{Enabled: false, Role: gmRole.String()})
+		return
+	}
+	if strings.TrimSpace(name) == "" {
+		http.Error(w, "[400] AuthHandler: device name must not be empty",
+			http.StatusBadRequest)
+		return
+	}
+	ae.auth.mutex.Lock()
+	defer ae.auth.mutex.Unlock()
+	ok, locked := ae.auth.attempt(req, endpoint, given, expected)
+	if locked {
+		http.Error(w, "[429] AuthHandler: too many failed attempts, try again later",
+			http.StatusTooManyRequests)
+		return
+	} else if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "[401] AuthHandler: invalid credentials",
+			http.StatusUnauthorized)
+		return
+	}
+	token, err := ae.auth.issue(name, r)
+	if err != nil {
+		http.Error(w, "[500] AuthHandler: unable to store token:\n  "+
+			err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: tokenCookie, Value: token, Path: "/",
+		MaxAge: 10 * 365 * 24 * 60 * 60, HttpOnly: true, Secure: ae.secure,
+		SameSite: http.SameSiteStrictMode})
+	sendJSON(w, shared.AuthStatus{Enabled: true, Role: r.String(), Token: token})
+}
+
+func (ae *authEndpoints) login(w http.ResponseWriter, r *http.Request) {
+	var value shared.LoginRequest
+	if receiveAuthRequest(w, r, &value) {
+		ae.grant(w, r, "login", value.Password, ae.auth.config.Password,
+			value.Name, gmRole)
+	}
+}
+
+func (ae *authEndpoints) pair(w http.ResponseWriter, r *http.Request) {
+	var value shared.PairingRequest
+	if receiveAuthRequest(w, r, &value) {
+		ae.grant(w, r, "pair", value.Code, ae.auth.pairingCode, value.Name,
+			playerRole)
+	}
+}
+
+func (ae *authEndpoints) devices(w http.ResponseWriter, r *http.Request) {
+	if !ae.auth.authorize(w, r, httpGet, "AuthHandler") {
+		return
+	}
+	ae.auth.mutex.Lock()
+	defer ae.auth.mutex.Unlock()
+	ret := make([]shared.Device, len(ae.auth.paired))
+	for i, d := range ae.auth.paired {
+		ret[i] = shared.Device{ID: d.ID, Name: d.Name, Role: d.Role}
+	}
+	sendJSON(w, ret)
+}
+
+func (ae *authEndpoints) revoke(w http.ResponseWriter, r *http.Request) {
+	if !ae.auth.authorize(w, r, httpDelete, "AuthHandler") {
+		return
+	}
+	id := r.URL.Path[len("/auth/devices/"):]
+	ae.auth.mutex.Lock()
+	defer ae.auth.mutex.Unlock()
+	for i := range ae.auth.paired {
+		if ae.auth.paired[i].ID == id {
+			d := ae.auth.paired[i]
+			ae.auth.paired = append(ae.auth.paired[:i], ae.auth.paired[i+1:]...)
+			if err := ae.auth.persist(); err != nil {
+				log.Println("[auth] unable to persist devices: " + err.Error())
+			}
+			log.Printf("[auth] revoked token of %s\n", d.Name)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("[404] AuthHandler: device %s not found", id),
+		http.StatusNotFound)
+}
+
+// loginPage is a minimal page for logging in or pairing a device when
+// authentication is enabled. The web client is loaded after a token has been
+// issued.
+const loginPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>QuestScreen</title>
+<link rel="stylesheet" href="/static/pure-min.css">
+<style>body{margin:2em auto;max-width:30em;padding:0 1em}form{margin-bottom:2em}</style>
+</head>
+<body>
+<h1>QuestScreen</h1>
+<form class="pure-form pure-form-stacked" data-path="/auth/pair" data-secret="code">
+<fieldset><legend>Player: enter the pairing code shown on the screen</legend>
+<input name="secret" inputmode="numeric" autocomplete="off" required>
+<input name="name" placeholder="Device name" required>
+<button class="pure-button pure-button-primary">Pair</button></fieldset>
+</form>
+<form class="pure-form pure-form-stacked" data-path="/auth/login" data-secret="password">
+<fieldset><legend>GM: log in</legend>
+<input name="secret" type="password" required>
+<input name="name" placeholder="Device name" required>
+<button class="pure-button pure-button-primary">Log in</button></fieldset>
+</form>
+<p id="error"></p>
+<script>
+document.querySelectorAll("form").forEach(function(form) {
+  form.addEventListener("submit", function(e) {
+    e.preventDefault();
+    var body = {name: form.elements.name.value};
+    body[form.dataset.secret] = form.elements.secret.value;
+    fetch(form.dataset.path, {method: "POST", body: JSON.stringify(body)})
+      .then(function(resp) {
+        if (resp.ok) { window.location = "/"; return; }
+        return resp.text().then(function(t) {
+          document.getElementById("error").textContent = t;
+        });
+      });
+  });
+});
+</script>
+</body>
+</html>
+`
+
+type loginPageHandler struct{}
+
+func (loginPageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Clacks-Overhead", "GNU Terry Pratchett")
+	w.Header().Set("Content-Type", contentTypes.HTML)
+	w.Write([]byte(loginPage))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuestScreen/QuestScreen/shared"
+	"github.com/QuestScreen/api/server"
+)
+
+// snapshots are named after their creation time using this layout.
+const backupIDLayout = "20060102-150405"
+
+func (qs *QuestScreen) backupDir() string {
+	return qs.DataDir("backups")
+}
+
+// listBackups returns all existing snapshots, newest first.
+func (qs *QuestScreen) listBackups() ([]shared.Backup, error) {
+	files, err := ioutil.ReadDir(qs.backupDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []shared.Backup{}, nil
+		}
+		return nil, err
+	}
+	ret := make([]shared.Backup, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".zip" {
+			continue
+		}
+		ret = append(ret, shared.Backup{
+			ID:      strings.TrimSuffix(file.Name(), ".zip"),
+			Created: file.ModTime().Unix(), Size: file.Size()})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created > ret[j].Created
+	})
+	return ret, nil
+}
+
+// createBackup writes a snapshot of the data directory and then deletes
+// snapshots according to the configured retention rules.
+func (qs *QuestScreen) createBackup() (shared.Backup, error) {
+	dir := qs.backupDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return shared.Backup{}, err
+	}
+	id := time.Now().Format(backupIDLayout)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(dir, id+".zip")); os.IsNotExist(err) {
+			break
+		}
+		id = time.Now().Format(backupIDLayout) + "-" + strconv.Itoa(i)
+	}
+	path := filepath.Join(dir, id+".zip")
+
+	// the private key of the generated certificate is not included.
+	exclude := []string{"backups", "tls"}
+	if !qs.backups.IncludeAssets {
+		exclude = append(exclude, "fonts", "textures")
+	}
+	// write to a file ignored by listBackups until the snapshot is complete.
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return shared.Backup{}, err
+	}
+	err = qs.persistence.WriteSnapshot(file, exclude...)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return shared.Backup{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return shared.Backup{}, err
+	}
+	qs.pruneBackups()
+	return shared.Backup{ID: id, Created: info.ModTime().Unix(),
+		Size: info.Size()}, nil
+}
+
+// pruneBackups deletes all snapshots exceeding the configured number of
+// snapshots to keep or the configured maximum age. The newest snapshot is
+// never deleted.
+func (qs *QuestScreen) pruneBackups() {
+	backups, err := qs.listBackups()
+	if err != nil {
+		log.Println("[backups] unable to list snapshots: " + err.Error())
+		return
+	}
+	now := time.Now()
+	for i := 1; i < len(backups); i++ {
+		tooMany := qs.backups.Keep > 0 && i >= qs.backups.Keep
+		tooOld := qs.backups.MaxAge > 0 &&
+			now.Sub(time.Unix(backups[i].Created, 0)) > qs.backups.MaxAge
+		if tooMany || tooOld {
+			path := filepath.Join(qs.backupDir(), backups[i].ID+".zip")
+			if err := os.Remove(path); err != nil {
+				log.Printf("[backups] unable to delete %s: %s\n", path, err.Error())
+			}
+		}
+	}
+}
+
+// runBackupScheduler periodically creates snapshots according to the
+// configured interval. The given mutex is the one used by the server's
+// handlers; it is held while a snapshot is created so that the data is not
+// modified in the meantime.
+func (qs *QuestScreen) runBackupScheduler(mutex *sync.Mutex) {
+	interval := qs.backups.Interval
+	wait := time.Duration(0)
+	if backups, err := qs.listBackups(); err == nil && len(backups) > 0 {
+		wait = interval - time.Since(time.Unix(backups[0].Created, 0))
+		if wait < 0 {
+			wait = 0
+		}
+	}
+	timer := time.NewTimer(wait)
+	for range timer.C {
+		mutex.Lock()
+		if b, err := qs.createBackup(); err != nil {
+			log.Println("[backups] unable to create snapshot: " + err.Error())
+		} else {
+			log.Println("[backups] created snapshot " + b.ID)
+		}
+		mutex.Unlock()
+		timer.Reset(interval)
+	}
+}
+
+type backupsEndpoint struct {
+	*endpointEnv
+}
+
+func (be backupsEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	if method == httpPost {
+		if _, err := be.qs.createBackup(); err != nil {
+			return nil, &server.InternalError{
+				Description: "while creating snapshot", Inner: err}
+		}
+	}
+	ret, err := be.qs.listBackups()
+	if err != nil {
+		return nil, &server.InternalError{
+			Description: "while listing snapshots", Inner: err}
+	}
+	return ret, nil
+}
+
+type backupRestoreEndpoint struct {
+	*endpointEnv
+}
+
+func (bre backupRestoreEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	backups, err := bre.qs.listBackups()
+	if err != nil {
+		return nil, &server.InternalError{
+			Description: "while listing snapshots", Inner: err}
+	}
+	found := false
+	for i := range backups {
+		if backups[i].ID == ids[0] {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, &server.NotFound{Name: ids[0]}
+	}
+
+	req, serr := bre.qs.display.StartRequest(bre.events.LeaveGroupID, 0)
+	if serr != nil {
+		return nil, serr
+	}
+	defer req.Close()
+
+	if err := bre.qs.persistence.RestoreSnapshot(
+		filepath.Join(bre.qs.backupDir(), ids[0]+".zip")); err != nil {
+		return nil, &server.InternalError{
+			Description: "while restoring snapshot", Inner: err}
+	}
+	bre.qs.setActiveGroup(-1)
+	bre.qs.persistence, bre.qs.communication = bre.qs.data.LoadPersisted(bre.qs)
+	bre.qs.loadModuleResources()
+	req.Commit()
+
+	bre.publishData()
+	bre.publishState()
+	return bre.qs.communication.ViewAll(bre.qs), nil
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/QuestScreen/QuestScreen/display"
 	"github.com/veandco/go-sdl2/sdl"
@@ -12,9 +15,153 @@ type appConfig struct {
 	fullscreen bool
 	width      int32
 	height     int32
-	msaa       int
-	port       uint16
-	keyActions []display.KeyAction
+	// index of the SDL display the primary output's window is placed on.
+	displayIndex int32
+	// IDs of the modules shown on the primary output; all if empty.
+	shownModules []string
+	msaa         int
+	port         uint16
+	keyActions   []display.KeyAction
+	bindings     []bindingConfig
+	backups      backupConfig
+	preview      previewConfig
+	outputs      []outputConfig
+	auth         authConfig
+	tls          tlsConfig
+	wifi         wifiConfig
+	mdns         mdnsConfig
+}
+
+// outputConfig describes an additional output. The primary output is
+// configured via the top-level fullscreen, width, height, display and modules
+// values.
+type outputConfig struct {
+	Name string
+	// index of the SDL display the output's window is placed on.
+	Display    int32
+	Fullscreen bool
+	// size of the window. Optional if Fullscreen is set.
+	Width, Height int32
+	// IDs (<plugin-id>.<module-id>) of the modules shown on this output.
+	// If empty, all modules are shown.
+	Modules []string `yaml:",omitempty"`
+}
+
+// backupConfig configures automatic snapshots of the data directory.
+type backupConfig struct {
+	// time between two snapshots. 0 disables automatic snapshots, which is the
+	// default; users opt in by setting an interval.
+	Interval time.Duration
+	// number of snapshots to keep. 0 keeps all snapshots.
+	Keep int
+	// snapshots older than this will be deleted. 0 disables deletion by age.
+	MaxAge time.Duration `yaml:"maxAge"`
+	// whether the fonts and textures directories are included.
+	IncludeAssets bool `yaml:"includeAssets"`
+}
+
+// previewConfig configures the preview of the rendered screen.
+type previewConfig struct {
+	// maximum number of frames per second sent by /display/stream.
+	// 0 disables the stream.
+	FPS int `yaml:"fps"`
+	// JPEG quality of streamed frames, from 1 to 100.
+	Quality int
+}
+
+// authConfig configures access control for the HTTP API.
+type authConfig struct {
+	// password required for GM access. If empty, authentication is disabled
+	// and every client has GM access.
+	Password string `yaml:",omitempty"`
+	// devices that are granted access via a fixed token.
+	Devices []deviceConfig `yaml:",omitempty"`
+	// module endpoints players may use, given as <plugin-id>/<module-id>,
+	// optionally followed by the endpoint's path.
+	PlayerEndpoints []string `yaml:"playerEndpoints"`
+}
+
+// tlsConfig configures serving via HTTPS.
+type tlsConfig struct {
+	Enabled bool
+	// paths to certificate and private key in PEM format. If not given, a
+	// self-signed certificate is generated and stored in the data directory.
+	Cert string `yaml:",omitempty"`
+	Key  string `yaml:",omitempty"`
+	// port of an additional HTTP listener that redirects to HTTPS.
+	// 0 disables the listener.
+	RedirectPort uint16 `yaml:"redirectPort"`
+}
+
+// mdnsConfig configures the advertisement of the web client via mDNS.
+type mdnsConfig struct {
+	Enabled bool
+	// host name without the .local suffix.
+	Hostname string
+}
+
+// wifiConfig describes the Wi-Fi network players should join. If SSID is set,
+// a QR code for joining the network is shown on the welcome screen.
+type wifiConfig struct {
+	SSID     string `yaml:"ssid,omitempty"`
+	Password string `yaml:",omitempty"`
+	// either "WPA", "WEP" or "nopass". Defaults to "WPA" if a password is
+	// given, else to "nopass".
+	Security string `yaml:",omitempty"`
+}
+
+// qrContent returns the content of a QR code for joining the network.
+// Returns an empty string if no network is configured.
+func (wc wifiConfig) qrContent() string {
+	if wc.SSID == "" {
+		return ""
+	}
+	security := wc.Security
+	if security == "" {
+		if wc.Password == "" {
+			security = "nopass"
+		} else {
+			security = "WPA"
+		}
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`,
+		":", `\:`, `"`, `\"`)
+	ret := "WIFI:T:" + security + ";S:" + escaper.Replace(wc.SSID) + ";"
+	if security != "nopass" {
+		ret += "P:" + escaper.Replace(wc.Password) + ";"
+	}
+	return ret + ";"
+}
+
+type deviceConfig struct {
+	Name  string
+	Token string
+	// either "gm" or "player".
+	Role string
+}
+
+// actions that can be bound to an input.
+const (
+	nextSceneAction      = "nextScene"
+	previousSceneAction  = "previousScene"
+	toggleHerolistAction = "toggleHerolist"
+	postAction           = "post"
+)
+
+// bindingConfig binds a key or gamepad button to an action. Bound keys are
+// handled before keyActions and do not open the key actions popup.
+type bindingConfig struct {
+	// name of the bound key as understood by SDL.
+	Key string `yaml:",omitempty"`
+	// index of the bound gamepad button. Only used if Key is empty.
+	Button *uint8 `yaml:",omitempty"`
+	// one of "nextScene", "previousScene", "toggleHerolist" or "post".
+	Action string
+	// absolute path of the endpoint that receives the payload.
+	// only used with the "post" action.
+	Path string `yaml:",omitempty"`
+	// payload posted to Path as JSON.
+	Payload interface{} `yaml:",omitempty"`
 }
 
 type tmpKeyAction struct {
@@ -26,17 +173,37 @@ type tmpKeyAction struct {
 type tmpConfig struct {
 	Fullscreen    bool
 	Width, Height int32
+	Display       int32
+	Modules       []string `yaml:",omitempty"`
 	Port          uint16
-	MSAA          int            `yaml:"msaa"`
-	KeyActions    []tmpKeyAction `yaml:"keyActions"`
+	MSAA          int             `yaml:"msaa"`
+	KeyActions    []tmpKeyAction  `yaml:"keyActions"`
+	Bindings      []bindingConfig `yaml:",omitempty"`
+	Backups       backupConfig
+	Preview       previewConfig
+	Outputs       []outputConfig `yaml:",omitempty"`
+	Auth          authConfig
+	TLS           tlsConfig  `yaml:"tls"`
+	WiFi          wifiConfig `yaml:"wifi,omitempty"`
+	MDNS          mdnsConfig `yaml:"mdns"`
 }
 
 func (c *appConfig) MarshalYAML() (interface{}, error) {
 	ret := tmpConfig{
 		Fullscreen: c.fullscreen,
 		Width:      c.width, Height: c.height, Port: c.port,
+		Display:    c.displayIndex,
+		Modules:    c.shownModules,
 		MSAA:       c.msaa,
-		KeyActions: make([]tmpKeyAction, len(c.keyActions))}
+		KeyActions: make([]tmpKeyAction, len(c.keyActions)),
+		Bindings:   c.bindings,
+		Backups:    c.backups,
+		Preview:    c.preview,
+		Outputs:    c.outputs,
+		Auth:       c.auth,
+		TLS:        c.tls,
+		WiFi:       c.wifi,
+		MDNS:       c.mdns}
 	for i := range c.keyActions {
 		a := c.keyActions[i]
 		ret.KeyActions[i] = tmpKeyAction{
@@ -48,7 +215,9 @@ func (c *appConfig) MarshalYAML() (interface{}, error) {
 }
 
 func (c *appConfig) UnmarshalYAML(value *yaml.Node) error {
-	var tmp tmpConfig
+	tmp := tmpConfig{Backups: defaultBackupConfig(),
+		Preview: defaultPreviewConfig(), Auth: defaultAuthConfig(),
+		MDNS: defaultMDNSConfig()}
 	if err := value.Decode(&tmp); err != nil {
 		return err
 	}
@@ -58,10 +227,75 @@ func (c *appConfig) UnmarshalYAML(value *yaml.Node) error {
 	if tmp.MSAA != 2 && tmp.MSAA != 4 {
 		return fmt.Errorf("invalid MSAA value %v", tmp.MSAA)
 	}
+	if tmp.Backups.Interval < 0 || tmp.Backups.Keep < 0 ||
+		tmp.Backups.MaxAge < 0 {
+		return fmt.Errorf("invalid backup configuration (interval=%v, keep=%d, maxAge=%v)",
+			tmp.Backups.Interval, tmp.Backups.Keep, tmp.Backups.MaxAge)
+	}
+	if tmp.Preview.FPS < 0 || tmp.Preview.Quality < 1 ||
+		tmp.Preview.Quality > 100 {
+		return fmt.Errorf("invalid preview configuration (fps=%d, quality=%d)",
+			tmp.Preview.FPS, tmp.Preview.Quality)
+	}
+	for i := range tmp.Auth.Devices {
+		d := tmp.Auth.Devices[i]
+		if d.Token == "" {
+			return fmt.Errorf("device %s: missing token", d.Name)
+		}
+		if _, err := parseRole(d.Role); err != nil {
+			return fmt.Errorf("device %s: %s", d.Name, err.Error())
+		}
+	}
+	if (tmp.TLS.Cert == "") != (tmp.TLS.Key == "") {
+		return errors.New("tls: cert and key must be given together")
+	}
+	if tmp.TLS.Enabled && tmp.TLS.RedirectPort != 0 &&
+		tmp.TLS.RedirectPort == tmp.Port {
+		return errors.New("tls: redirectPort must differ from port")
+	}
+	for i := range tmp.Bindings {
+		if err := tmp.Bindings[i].validate(); err != nil {
+			return fmt.Errorf("binding %d: %s", i+1, err.Error())
+		}
+	}
+	switch tmp.WiFi.Security {
+	case "", "WPA", "WEP", "nopass":
+	default:
+		return fmt.Errorf("wifi: invalid security %q", tmp.WiFi.Security)
+	}
+	if tmp.MDNS.Enabled && (tmp.MDNS.Hostname == "" ||
+		strings.ContainsAny(tmp.MDNS.Hostname, ". ")) {
+		return fmt.Errorf("mdns: invalid hostname %q", tmp.MDNS.Hostname)
+	}
+	if tmp.Display < 0 {
+		return fmt.Errorf("invalid display index %d", tmp.Display)
+	}
+	for i := range tmp.Outputs {
+		o := &tmp.Outputs[i]
+		if o.Name == "" {
+			o.Name = fmt.Sprintf("Output %d", i+2)
+		}
+		if o.Display < 0 {
+			return fmt.Errorf("output %s: invalid display index %d", o.Name,
+				o.Display)
+		}
+		if o.Width <= 0 || o.Height <= 0 {
+			if !o.Fullscreen {
+				return fmt.Errorf("output %s: invalid size (w=%d, h=%d)", o.Name,
+					o.Width, o.Height)
+			}
+			// SDL requires a window size even for fullscreen windows.
+			o.Width, o.Height = 800, 600
+		}
+	}
 
 	*c = appConfig{fullscreen: tmp.Fullscreen, width: tmp.Width, height: tmp.Height,
+		displayIndex: tmp.Display, shownModules: tmp.Modules,
 		port: tmp.Port, msaa: tmp.MSAA,
-		keyActions: make([]display.KeyAction, len(tmp.KeyActions))}
+		keyActions: make([]display.KeyAction, len(tmp.KeyActions)),
+		bindings:   tmp.Bindings,
+		backups:    tmp.Backups, preview: tmp.Preview, outputs: tmp.Outputs,
+		auth: tmp.Auth, tls: tmp.TLS, wifi: tmp.WiFi, mdns: tmp.MDNS}
 
 	for i := range tmp.KeyActions {
 		ta := tmp.KeyActions[i]
@@ -79,10 +313,50 @@ func (c *appConfig) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
+func (bc *bindingConfig) validate() error {
+	if bc.Key != "" {
+		if sdl.GetKeyFromName(bc.Key) == sdl.K_UNKNOWN {
+			return fmt.Errorf("unknown key: %s", bc.Key)
+		}
+	} else if bc.Button == nil {
+		return errors.New("neither key nor button given")
+	}
+	switch bc.Action {
+	case nextSceneAction, previousSceneAction, toggleHerolistAction:
+	case postAction:
+		if !strings.HasPrefix(bc.Path, "/") {
+			return fmt.Errorf("invalid path %q (must be absolute)", bc.Path)
+		}
+	default:
+		return fmt.Errorf("unknown action: %s", bc.Action)
+	}
+	return nil
+}
+
 func defaultConfig() appConfig {
 	return appConfig{
 		fullscreen: false, width: 800, height: 600, port: 8080, msaa: 2,
 		keyActions: []display.KeyAction{{Key: sdl.K_ESCAPE, ReturnValue: 0,
 			Description: "Exit"}},
+		backups: defaultBackupConfig(),
+		preview: defaultPreviewConfig(),
+		auth:    defaultAuthConfig(),
+		mdns:    defaultMDNSConfig(),
 	}
 }
+
+func defaultBackupConfig() backupConfig {
+	return backupConfig{Interval: 0, Keep: 7}
+}
+
+func defaultPreviewConfig() previewConfig {
+	return previewConfig{FPS: 5, Quality: 75}
+}
+
+func defaultAuthConfig() authConfig {
+	return authConfig{PlayerEndpoints: []string{"base/herolist"}}
+}
+
+func defaultMDNSConfig() mdnsConfig {
+	return mdnsConfig{Enabled: true, Hostname: "questscreen"}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/QuestScreen/QuestScreen/data"
+	"github.com/QuestScreen/QuestScreen/shared"
+)
+
+// eventStream implements the /events endpoint. Clients subscribe to it as a
+// stream of Server-Sent Events and get notified whenever the server's state
+// changes, so that they do not need to poll /state and /data.
+type eventStream struct {
+	mutex       sync.Mutex
+	subscribers map[chan []byte]struct{}
+}
+
+// number of events that may be queued for a subscriber before it is dropped.
+const eventBufferSize = 32
+
+const keepAliveInterval = 30 * time.Second
+
+func newEventStream() *eventStream {
+	return &eventStream{subscribers: make(map[chan []byte]struct{})}
+}
+
+// publish sends an event with the given name and JSON-serialized payload to
+// all subscribers. Subscribers that cannot keep up are dropped; their clients
+// will reconnect and are expected to refetch the whole state.
+func (es *eventStream) publish(name string, payload interface{}) {
+	content, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("[events] unable to serialize %s event: %s\n", name,
+			err.Error())
+		return
+	}
+	var b bytes.Buffer
+	b.WriteString("event: ")
+	b.WriteString(name)
+	b.WriteString("\ndata: ")
+	b.Write(content)
+	b.WriteString("\n\n")
+	msg := b.Bytes()
+
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	for sub := range es.subscribers {
+		select {
+		case sub <- msg:
+		default:
+			delete(es.subscribers, sub)
+			close(sub)
+		}
+	}
+}
+
+func (es *eventStream) subscribe() chan []byte {
+	sub := make(chan []byte, eventBufferSize)
+	es.mutex.Lock()
+	es.subscribers[sub] = struct{}{}
+	es.mutex.Unlock()
+	return sub
+}
+
+func (es *eventStream) unsubscribe(sub chan []byte) {
+	es.mutex.Lock()
+	if _, ok := es.subscribers[sub]; ok {
+		delete(es.subscribers, sub)
+		close(sub)
+	}
+	es.mutex.Unlock()
+}
+
+func (es *eventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Clacks-Overhead", "GNU Terry Pratchett")
+	method := parseMethod(r.Method)
+	if method != httpGet {
+		http.Error(w, fmt.Sprintf(
+			"[EventStream] 405: Method not allowed (supports GET, got %s)",
+			method), http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "[EventStream] 500: streaming not supported",
+			http.StatusInternalServerError)
+		return
+	}
+
+	sub := es.subscribe()
+	defer es.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case msg, ok := <-sub:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (env *endpointEnv) viewState() shared.StateResponse {
+	ret := shared.StateResponse{ActiveGroup: env.qs.activeGroupIndex,
+		ActiveScene: -1}
+	if env.qs.activeGroupIndex != -1 {
+		ret.ActiveScene = env.qs.data.ActiveScene()
+		ret.Modules = env.qs.communication.ViewSceneState(env.qs)
+		ret.OutputScenes = env.qs.outputScenes
+	}
+	return ret
+}
+
+// publishState notifies subscribers about the current group, scene and module
+// states.
+func (env *endpointEnv) publishState() {
+	env.stream.publish(shared.StateEvent, env.viewState())
+}
+
+// publishData notifies subscribers about changed systems, groups, scenes or
+// heroes.
+func (env *endpointEnv) publishData() {
+	env.stream.publish(shared.DataEvent, env.qs.communication.ViewAll(env.qs))
+}
+
+// publishTemplates notifies subscribers about a saved or deleted user template.
+func (env *endpointEnv) publishTemplates() {
+	env.stream.publish(shared.TemplatesEvent,
+		env.qs.communication.ViewTemplates(env.qs))
+}
+
+// publishModule notifies subscribers about a changed state of the module
+// with the given index in the active scene.
+func (env *endpointEnv) publishModule(index shared.ModuleIndex) {
+	state := env.qs.data.StateOf(index)
+	if state == nil {
+		return
+	}
+	raw, err := json.Marshal(state.Send(env.qs.ServerContext(index)))
+	if err != nil {
+		log.Printf("[events] unable to serialize state of %s: %s\n",
+			env.qs.ModuleID(index), err.Error())
+		return
+	}
+	env.stream.publish(shared.ModuleEvent,
+		shared.ModuleStateUpdate{ModuleIndex: index, State: raw})
+}
+
+// publishConfig notifies subscribers about the updated config at the given
+// path below /config.
+func (env *endpointEnv) publishConfig(path string, view interface{}) {
+	raw, err := json.Marshal(view)
+	if err != nil {
+		log.Printf("[events] unable to serialize config %s: %s\n", path,
+			err.Error())
+		return
+	}
+	env.stream.publish(shared.ConfigEvent,
+		shared.ConfigUpdate{Path: path, Modules: raw})
+}
+
+// publishHeroesChange notifies subscribers about a changed list of heroes in
+// the given group. Since module states may depend on the heroes, the state is
+// published as well if the group is the active one.
+func (env *endpointEnv) publishHeroesChange(g data.Group) {
+	env.publishData()
+	if env.qs.activeGroupIndex != -1 && env.qs.activeGroup() == g {
+		env.publishState()
+	}
+}
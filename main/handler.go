@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"strings"
 	"sync"
@@ -136,16 +138,34 @@ func (endpoint) walk(url *string, id *[]string) bool {
 	return true
 }
 
+// maximum size of a request body unless the endpoint handler implements
+// bodyLimiter.
+const defaultMaxBodySize = 1 << 20
+
+// bodyLimiter may be implemented by an endpointHandler that receives request
+// bodies larger than defaultMaxBodySize, e.g. file uploads.
+type bodyLimiter interface {
+	maxBodySize() int64
+}
+
+// serverGuard is shared by all handlers. Its mutex serializes the handling of
+// requests, and its authenticator checks whether a request is permitted.
+type serverGuard struct {
+	sync.Mutex
+	auth *authenticator
+}
+
 // handler is a HTTP handler that is able to capture URL path fragments as IDs
 // (i.e. /config/groups/<group-id>/scenes/<scene-id>) and then call the
 // registered endpointHandler with the captured IDs as strings. It also filters
 // requests by HTTP method and only calls an endpoint handler when the actual
-// HTTP method is specified for that endpoint.
+// HTTP method is specified for that endpoint and the client's role permits
+// the request.
 type handler struct {
 	name     string
 	basePath string
 	path     []pathItem
-	mutex    *sync.Mutex
+	guard    *serverGuard
 }
 
 func sendJSON(w http.ResponseWriter, data interface{}) {
@@ -160,14 +180,57 @@ func sendJSON(w http.ResponseWriter, data interface{}) {
 	}
 }
 
+// fileResponse may be returned by an endpointHandler to send the content as
+// downloadable file instead of as JSON. If name is empty, the content is sent
+// for being displayed inline.
+type fileResponse struct {
+	name        string
+	contentType string
+	content     []byte
+}
+
+func sendFile(w http.ResponseWriter, file fileResponse) {
+	w.Header().Set("Content-Type", file.contentType)
+	if file.name != "" {
+		w.Header().Set("Content-Disposition",
+			mime.FormatMediaType("attachment", map[string]string{"filename": file.name}))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(file.content)
+}
+
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Clacks-Overhead", "GNU Terry Pratchett")
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
+	if !h.guard.auth.authorize(w, r, parseMethod(r.Method), h.name) {
+		return
+	}
+	// the body is received before locking so that a slow upload does not block
+	// other requests.
+	method := parseMethod(r.Method)
+	e, ids, raw, ok := h.receive(w, r, method)
+	if !ok {
+		return
+	}
+	h.guard.Lock()
+	defer h.guard.Unlock()
+	h.handle(w, e, method, ids, raw)
+}
+
+// serve handles the request without authorization. The caller must hold the
+// guard's lock.
+func (h *handler) serve(w http.ResponseWriter, r *http.Request) {
 	method := parseMethod(r.Method)
+	if e, ids, raw, ok := h.receive(w, r, method); ok {
+		h.handle(w, e, method, ids, raw)
+	}
+}
 
-	var ids []string
-	var e endpoint
+// receive resolves the endpoint of the request, captures the IDs in its path
+// and reads its body. If the request cannot be handled, an error is sent to
+// the client and ok is false.
+func (h *handler) receive(w http.ResponseWriter, r *http.Request,
+	method httpMethods) (e endpoint, ids []string, raw []byte, ok bool) {
 	if h.basePath[len(h.basePath)-1] == '/' {
 		url := r.URL.Path[len(h.basePath):]
 	OUTER:
@@ -193,7 +256,7 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 				http.Error(w, fmt.Sprintf("[404] %s: not found", h.name),
 					http.StatusNotFound)
-				return
+				return e, ids, nil, false
 			}
 		}
 	} else {
@@ -204,33 +267,96 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf(
 			"[405] %s: Method not allowed (supports %s, got %s)",
 			h.name, e.allowedMethods, method), http.StatusMethodNotAllowed)
-		return
+		return e, ids, nil, false
 	}
 
-	var raw []byte
 	if method == httpPost || method == httpPut {
+		limit := int64(defaultMaxBodySize)
+		if bl, isLimiter := e.handler.(bodyLimiter); isLimiter {
+			limit = bl.maxBodySize()
+		}
 		var err error
-		raw, err = ioutil.ReadAll(r.Body)
+		raw, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
 		if err != nil {
-			http.Error(w, fmt.Sprintf("[500] %s: unable to read body:\n  %s", h.name,
-				err.Error()), http.StatusInternalServerError)
-			return
+			if int64(len(raw)) >= limit {
+				http.Error(w, fmt.Sprintf(
+					"[413] %s: request body too large (maximum is %d KiB)", h.name,
+					limit>>10), http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, fmt.Sprintf("[500] %s: unable to read body:\n  %s",
+					h.name, err.Error()), http.StatusInternalServerError)
+			}
+			return e, ids, nil, false
 		}
 	}
+	return e, ids, raw, true
+}
+
+// handle calls the endpoint's handler and sends its result. The caller must
+// hold the guard's lock.
+func (h *handler) handle(w http.ResponseWriter, e endpoint, method httpMethods,
+	ids []string, raw []byte) {
 	ret, err := e.handler.Handle(method, ids, raw)
 	if err != nil {
 		msg := fmt.Sprintf("[%d] %s: %s", err.StatusCode(), h.name, err.Error())
 		http.Error(w, msg, err.StatusCode())
 		return
 	}
-	if ret != nil {
+	if file, ok := ret.(fileResponse); ok {
+		sendFile(w, file)
+	} else if ret != nil {
 		sendJSON(w, ret)
 	} else {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-func reg(name string, basePath string, mutex *sync.Mutex, pathItems ...pathItem) {
+// internalResponse records the response to a request issued by serveInternal.
+type internalResponse struct {
+	Code   int
+	Body   bytes.Buffer
+	header http.Header
+}
+
+func (ir *internalResponse) Header() http.Header {
+	return ir.header
+}
+
+func (ir *internalResponse) Write(data []byte) (int, error) {
+	if ir.Code == 0 {
+		ir.Code = http.StatusOK
+	}
+	return ir.Body.Write(data)
+}
+
+func (ir *internalResponse) WriteHeader(statusCode int) {
+	if ir.Code == 0 {
+		ir.Code = statusCode
+	}
+}
+
+// serveInternal handles a POST request issued by QuestScreen itself, e.g. by
+// an input binding, as if it had been issued by a client with GM access.
+// The caller must hold the guard's lock.
+func serveInternal(path string, payload []byte) *internalResponse {
+	w := &internalResponse{header: make(http.Header)}
+	r, err := http.NewRequest("POST", path, bytes.NewReader(payload))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("[400] invalid path %s: %s", path, err.Error()),
+			http.StatusBadRequest)
+		return w
+	}
+	h, _ := http.DefaultServeMux.Handler(r)
+	if target, ok := h.(*handler); ok {
+		target.serve(w, r)
+	} else {
+		http.Error(w, fmt.Sprintf("[404] no endpoint at %s", path),
+			http.StatusNotFound)
+	}
+	return w
+}
+
+func reg(name string, basePath string, guard *serverGuard, pathItems ...pathItem) {
 	http.Handle(basePath, &handler{name: name, basePath: basePath,
-		path: pathItems, mutex: mutex})
+		path: pathItems, guard: guard})
 }
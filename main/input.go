@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/QuestScreen/QuestScreen/display"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// inputHandler executes the actions of the configured bindings. Actions are
+// executed as internal requests to the registered endpoints so that they
+// behave exactly like requests from the web client, including history and
+// events sent to connected clients.
+type inputHandler struct {
+	qs    *QuestScreen
+	guard *serverGuard
+}
+
+func (qs *QuestScreen) inputBindings() []display.InputBinding {
+	ret := make([]display.InputBinding, len(qs.bindings))
+	for i := range qs.bindings {
+		b := &qs.bindings[i]
+		if b.Key != "" {
+			ret[i].Key = sdl.GetKeyFromName(b.Key)
+		} else {
+			ret[i].Key = sdl.K_UNKNOWN
+			ret[i].Button = *b.Button
+		}
+	}
+	return ret
+}
+
+func (ih *inputHandler) handle(index int) {
+	binding := &ih.qs.bindings[index]
+	ih.guard.Lock()
+	defer ih.guard.Unlock()
+	path, payload, err := ih.request(binding)
+	if err != nil {
+		log.Printf("[input] %s: %s\n", binding.Action, err.Error())
+		return
+	}
+	if path == "" {
+		return
+	}
+	if w := serveInternal(path, payload); w.Code >= 300 {
+		log.Printf("[input] %s: %s\n", binding.Action, w.Body.String())
+	}
+}
+
+// request returns the path and payload of the request that executes the
+// binding's action. Returns an empty path if there is nothing to do.
+func (ih *inputHandler) request(binding *bindingConfig) (string, []byte,
+	error) {
+	qs := ih.qs
+	switch binding.Action {
+	case nextSceneAction, previousSceneAction:
+		g := qs.activeGroup()
+		if g == nil {
+			return "", nil, errors.New("no active group")
+		}
+		scene := qs.data.ActiveScene() + 1
+		if binding.Action == previousSceneAction {
+			scene -= 2
+		}
+		if scene < 0 || scene >= g.NumScenes() {
+			return "", nil, nil
+		}
+		payload, err := json.Marshal(map[string]interface{}{
+			"action": "setscene", "index": scene})
+		return "/state", payload, err
+	case toggleHerolistAction:
+		index := qs.ModuleFor("base", "herolist")
+		if index == -1 || qs.activeGroup() == nil {
+			return "", nil, errors.New("herolist not available")
+		}
+		state := qs.data.State.StateOf(index)
+		if state == nil {
+			return "", nil, errors.New("herolist not enabled in current scene")
+		}
+		raw, err := json.Marshal(state.Send(qs.ServerContext(index)))
+		if err != nil {
+			return "", nil, err
+		}
+		var current struct {
+			Global bool `json:"global"`
+		}
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return "", nil, err
+		}
+		payload, err := json.Marshal(!current.Global)
+		return "/state/base/herolist", payload, err
+	default:
+		payload, err := json.Marshal(binding.Payload)
+		return binding.Path, payload, err
+	}
+}
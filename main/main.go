@@ -23,9 +23,15 @@ func main() {
 	height := getopt.Int32Long("height", 'h', 0, "height of the window (set w and h to start windowed)")
 	msaa := getopt.IntLong("msaa", 'm', -1, "Anti-Aliasing (MSAA) samples")
 	debug := getopt.BoolLong("debug", 'd', "use an OpenGL debug context")
+	headless := getopt.BoolLong("headless", 0,
+		"render into an in-memory framebuffer instead of a window")
 	getopt.Parse()
 
-	if err := sdl.Init(sdl.INIT_VIDEO | sdl.INIT_EVENTS); err != nil {
+	var sdlFlags uint32 = sdl.INIT_EVENTS
+	if !*headless {
+		sdlFlags |= sdl.INIT_VIDEO
+	}
+	if err := sdl.Init(sdlFlags); err != nil {
 		panic(err)
 	}
 	defer sdl.Quit()
@@ -38,14 +44,17 @@ func main() {
 
 	events := display.GenEvents()
 	var qs QuestScreen
-	qs.Init(*fullscreenFlag, *width, *height, *msaa, events, *port, *debug)
+	qs.Init(*fullscreenFlag, *width, *height, *msaa, events, *port, *debug,
+		*headless)
 
 	server, err := startServer(&qs, events, qs.appConfig.port)
 	if err != nil {
 		panic(err)
 	}
+	qs.startMDNS()
 
 	ret := qs.display.RenderLoop()
+	qs.stopMDNS()
 	_ = server.Close()
 	qs.destroy()
 	os.Exit(ret)
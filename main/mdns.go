@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+
+	"github.com/QuestScreen/QuestScreen/mdns"
+	"github.com/QuestScreen/QuestScreen/versioninfo"
+)
+
+// listenMDNS creates the mDNS responder if enabled. Returns the host name
+// the web client will be advertised as, or "" if mDNS is disabled or the
+// responder could not be created. Failure to create it is not fatal since the
+// web client is still reachable via IP address.
+func (qs *QuestScreen) listenMDNS() string {
+	if !qs.mdns.Enabled {
+		return ""
+	}
+	serviceType := "_http._tcp"
+	if qs.tls.Enabled {
+		serviceType = "_https._tcp"
+	}
+	responder, err := mdns.Listen(mdns.Service{Instance: "QuestScreen",
+		Type: serviceType, Host: qs.mdns.Hostname, Port: qs.port,
+		TXT: qs.mdnsTXT()})
+	if err != nil {
+		log.Println("[mdns] unable to start responder: " + err.Error())
+		return ""
+	}
+	qs.mdnsMutex.Lock()
+	qs.responder = responder
+	qs.mdnsMutex.Unlock()
+	return qs.mdns.Hostname + ".local"
+}
+
+// startMDNS starts advertising the web client via the responder created by
+// listenMDNS, if any.
+func (qs *QuestScreen) startMDNS() {
+	qs.mdnsMutex.Lock()
+	responder := qs.responder
+	qs.mdnsMutex.Unlock()
+	if responder == nil {
+		return
+	}
+	go func() {
+		if err := responder.Serve(); err != nil {
+			log.Println("[mdns] responder stopped: " + err.Error())
+		}
+	}()
+}
+
+// mdnsTXT returns the entries of the advertised TXT record.
+func (qs *QuestScreen) mdnsTXT() []string {
+	ret := []string{"path=/", "version=" + versioninfo.CurrentVersion}
+	if group := qs.activeGroup(); group != nil {
+		ret = append(ret, "group="+group.Name())
+	}
+	return ret
+}
+
+// updateMDNS updates the advertised TXT record after the active group has
+// changed.
+func (qs *QuestScreen) updateMDNS() {
+	qs.mdnsMutex.Lock()
+	defer qs.mdnsMutex.Unlock()
+	if qs.responder != nil {
+		qs.responder.SetTXT(qs.mdnsTXT())
+	}
+}
+
+func (qs *QuestScreen) stopMDNS() {
+	qs.mdnsMutex.Lock()
+	defer qs.mdnsMutex.Unlock()
+	if qs.responder != nil {
+		if err := qs.responder.Close(); err != nil {
+			log.Println("[mdns] while closing responder: " + err.Error())
+		}
+		qs.responder = nil
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/QuestScreen/QuestScreen/shared"
+	"github.com/QuestScreen/api/server"
+	"gopkg.in/yaml.v3"
+)
+
+// playlistEntry is an item of a group's playlist, which is stored as
+// playlist.yaml in the group's directory.
+type playlistEntry struct {
+	// ID of the scene that is shown.
+	Scene string
+	// time until the playlist advances to the next entry.
+	Duration time.Duration
+	// requests posted to module endpoints after the scene has been shown.
+	Requests []playlistRequest `yaml:",omitempty"`
+}
+
+type playlistRequest struct {
+	// path of the module endpoint below /state/, e.g. base/herolist.
+	Endpoint string
+	// payload posted to the endpoint as JSON.
+	Payload interface{}
+}
+
+const (
+	minPlaylistDuration = time.Second
+	// a request is retried in this interval while the display is overloaded.
+	playlistRetryInterval = 20 * time.Millisecond
+	maxPlaylistRetries    = 100
+)
+
+// preparedRequest is a request of a playlist entry ready to be posted.
+type preparedRequest struct {
+	path    string
+	payload []byte
+}
+
+// playlist rotates through the scenes of the active group's playlist. It runs
+// in its own goroutine and issues its requests via the endpoints so that they
+// are handled like requests from the web client.
+//
+// All fields are protected by the guard's lock.
+type playlist struct {
+	*endpointEnv
+	guard    *serverGuard
+	group    int
+	scenes   []string
+	dwell    []time.Duration
+	requests [][]preparedRequest
+	current  int
+	// closed when the playlist is stopped; nil if it is not running.
+	stop chan struct{}
+	next chan struct{}
+}
+
+func (qs *QuestScreen) pathToPlaylist() string {
+	return filepath.Join(qs.dataDir, "groups",
+		qs.activeGroup().ID(), "playlist.yaml")
+}
+
+// load loads the playlist of the active group.
+func (p *playlist) load() server.Error {
+	g := p.qs.activeGroup()
+	if g == nil {
+		return &server.BadRequest{Message: "No active group"}
+	}
+	raw, err := ioutil.ReadFile(p.qs.pathToPlaylist())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &server.NotFound{Name: "playlist of group " + g.Name()}
+		}
+		return &server.InternalError{Description: "Failed to read playlist",
+			Inner: err}
+	}
+	var entries []playlistEntry
+	if err := yaml.Unmarshal(raw, &entries); err != nil {
+		return &server.BadRequest{Message: "Invalid playlist", Inner: err}
+	}
+	if len(entries) == 0 {
+		return &server.BadRequest{Message: "Playlist is empty"}
+	}
+
+	p.group = p.qs.activeGroupIndex
+	p.scenes = make([]string, len(entries))
+	p.dwell = make([]time.Duration, len(entries))
+	p.requests = make([][]preparedRequest, len(entries))
+	for i := range entries {
+		e := &entries[i]
+		index, _ := g.SceneByID(e.Scene)
+		if index == -1 {
+			return &server.BadRequest{
+				Message: fmt.Sprintf("Playlist entry %d: unknown scene %s", i+1, e.Scene)}
+		}
+		if e.Duration < minPlaylistDuration {
+			return &server.BadRequest{Message: fmt.Sprintf(
+				"Playlist entry %d: duration must be at least %v", i+1,
+				minPlaylistDuration)}
+		}
+		p.scenes[i] = e.Scene
+		p.dwell[i] = e.Duration
+		for j := range e.Requests {
+			payload, err := json.Marshal(e.Requests[j].Payload)
+			if err != nil {
+				return &server.BadRequest{Message: fmt.Sprintf(
+					"Playlist entry %d: invalid payload", i+1), Inner: err}
+			}
+			p.requests[i] = append(p.requests[i], preparedRequest{
+				path: "/state/" + e.Requests[j].Endpoint, payload: payload})
+		}
+	}
+	return nil
+}
+
+func (p *playlist) view() shared.PlaylistState {
+	if p.stop != nil {
+		return shared.PlaylistState{Running: true, Entry: p.current,
+			Scenes: p.scenes}
+	}
+	// show the playlist that would be started.
+	ret := shared.PlaylistState{Entry: -1, Scenes: []string{}}
+	if p.qs.activeGroup() != nil && p.load() == nil {
+		ret.Scenes = p.scenes
+	}
+	return ret
+}
+
+func (p *playlist) start() server.Error {
+	p.halt()
+	if err := p.load(); err != nil {
+		return err
+	}
+	p.current = 0
+	p.stop = make(chan struct{})
+	p.next = make(chan struct{}, 1)
+	go p.run(p.stop, p.next)
+	return nil
+}
+
+func (p *playlist) halt() {
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+		p.next = nil
+	}
+}
+
+// run shows the entries of the playlist until stop is closed or the active
+// group changes.
+func (p *playlist) run(stop, next chan struct{}) {
+	for {
+		p.guard.Lock()
+		if p.stop != stop {
+			p.guard.Unlock()
+			return
+		}
+		if p.qs.activeGroupIndex != p.group {
+			p.halt()
+			p.guard.Unlock()
+			return
+		}
+		scene := p.scenes[p.current]
+		dwell := p.dwell[p.current]
+		requests := p.requests[p.current]
+		index, _ := p.qs.activeGroup().SceneByID(scene)
+		p.guard.Unlock()
+
+		if index == -1 {
+			// the scene has been deleted since the playlist has been started.
+			log.Printf("[playlist] skipping missing scene %s\n", scene)
+			dwell = minPlaylistDuration
+		} else {
+			payload, _ := json.Marshal(map[string]interface{}{
+				"action": "setscene", "index": index})
+			if !p.post(stop, "/state", payload) {
+				return
+			}
+			for _, r := range requests {
+				if !p.post(stop, r.path, r.payload) {
+					return
+				}
+			}
+		}
+
+		timer := time.NewTimer(dwell)
+		select {
+		case <-timer.C:
+		case <-next:
+			timer.Stop()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+
+		p.guard.Lock()
+		if p.stop == stop {
+			p.current = (p.current + 1) % len(p.scenes)
+		}
+		p.guard.Unlock()
+	}
+}
+
+// post posts the payload to the given endpoint. Requests rejected because
+// the display's request queue is full are retried.
+// Returns false if the playlist has been stopped.
+func (p *playlist) post(stop chan struct{}, path string, payload []byte) bool {
+	for attempt := 0; ; attempt++ {
+		p.guard.Lock()
+		if p.stop != stop {
+			p.guard.Unlock()
+			return false
+		}
+		p.playlistRequest = true
+		w := serveInternal(path, payload)
+		p.playlistRequest = false
+		p.guard.Unlock()
+		if w.Code != http.StatusTooManyRequests {
+			if w.Code >= 300 {
+				log.Printf("[playlist] %s: %s\n", path, w.Body.String())
+			}
+			return true
+		}
+		if attempt == maxPlaylistRetries {
+			log.Printf("[playlist] %s: display busy, giving up\n", path)
+			return true
+		}
+		time.Sleep(playlistRetryInterval)
+	}
+}
+
+type playlistEndpoint struct {
+	*playlist
+}
+
+func (pe playlistEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	return pe.view(), nil
+}
+
+type playlistControlEndpoint struct {
+	*playlist
+	action string
+}
+
+func (pce playlistControlEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	switch pce.action {
+	case "start":
+		if err := pce.start(); err != nil {
+			return nil, err
+		}
+	case "stop":
+		pce.halt()
+	case "next":
+		if pce.stop == nil {
+			return nil, &server.BadRequest{Message: "Playlist is not running"}
+		}
+		select {
+		case pce.next <- struct{}{}:
+		default:
+			// already advancing.
+		}
+	}
+	return pce.view(), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"time"
+
+	"github.com/QuestScreen/QuestScreen/display"
+	"github.com/QuestScreen/api/server"
+)
+
+type snapshotEndpoint struct {
+	*endpointEnv
+}
+
+func (se snapshotEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	frame, _ := se.qs.display.Frame()
+	if frame == nil {
+		return nil, &server.NotFound{Name: "rendered frame"}
+	}
+	var b bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&b, frame); err != nil {
+		return nil, &server.InternalError{
+			Description: "while encoding snapshot", Inner: err}
+	}
+	return fileResponse{contentType: "image/png", content: b.Bytes()}, nil
+}
+
+// previewStream implements the /display/stream endpoint. It sends the
+// rendered frames as MJPEG stream, i.e. a multipart response where each part
+// replaces the previous one.
+//
+// Frames are only sent when the displayed content changes, and at most with
+// the configured number of frames per second.
+type previewStream struct {
+	display *display.Display
+	config  previewConfig
+}
+
+// boundary between the parts of the stream.
+const previewBoundary = "questscreen-frame"
+
+func (ps *previewStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Clacks-Overhead", "GNU Terry Pratchett")
+	method := parseMethod(r.Method)
+	if method != httpGet {
+		http.Error(w, fmt.Sprintf(
+			"[PreviewStream] 405: Method not allowed (supports GET, got %s)",
+			method), http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "[PreviewStream] 500: streaming not supported",
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type",
+		"multipart/x-mixed-replace; boundary="+previewBoundary)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	interval := time.Second / time.Duration(ps.config.FPS)
+	options := jpeg.Options{Quality: ps.config.Quality}
+	var b bytes.Buffer
+	for {
+		frame, updated := ps.display.Frame()
+		if frame != nil {
+			b.Reset()
+			if err := jpeg.Encode(&b, frame, &options); err != nil {
+				return
+			}
+			// giving the length allows clients to show the frame without
+			// waiting for the next boundary.
+			if _, err := fmt.Fprintf(w,
+				"--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n",
+				previewBoundary, b.Len()); err != nil {
+				return
+			}
+			b.WriteString("\r\n")
+			if _, err := w.Write(b.Bytes()); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		select {
+		case <-time.After(interval):
+		case <-r.Context().Done():
+			return
+		}
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
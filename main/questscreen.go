@@ -11,12 +11,15 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
 	"github.com/QuestScreen/QuestScreen/app"
 	"github.com/QuestScreen/QuestScreen/data"
 	"github.com/QuestScreen/QuestScreen/display"
+	"github.com/QuestScreen/QuestScreen/mdns"
 	"github.com/QuestScreen/QuestScreen/plugins"
 	"github.com/QuestScreen/QuestScreen/shared"
 	"github.com/QuestScreen/api"
@@ -62,6 +65,20 @@ type QuestScreen struct {
 	activeSystemIndex   int
 	messages            []shared.Message
 	context             sdl.GLContext
+	headless            bool
+	authenticator       *authenticator
+	responder           *mdns.Responder
+	// for each output, the modules it shows; nil if it shows all modules.
+	outputModules [][]bool
+	// for each output, the index of the scene it shows, or -1 if it shows the
+	// active scene. The primary output always shows the active scene.
+	outputScenes []int
+	// guards resourceCollections and textures, which are rescanned by the
+	// server while the display thread reads them.
+	resourceMutex sync.RWMutex
+	// guards responder, which is used by the server and closed by the main
+	// thread on shutdown.
+	mdnsMutex sync.Mutex
 }
 
 // implements api.MessageSender
@@ -166,7 +183,8 @@ func (qs *QuestScreen) loadConfig(path string, width int32, height int32,
 	return nil
 }
 
-func (qs *QuestScreen) loadTextures(path string) {
+func loadTextures(list []resources.Resource,
+	path string) []resources.Resource {
 	textureFiles, err := ioutil.ReadDir(path)
 	if err == nil {
 		for _, file := range textureFiles {
@@ -176,19 +194,27 @@ func (qs *QuestScreen) loadTextures(path string) {
 					log.Printf("could not read file %s: %s\n", path, err.Error())
 					continue
 				}
-				qs.textures = append(qs.textures, resources.Resource{
+				list = append(list, resources.Resource{
 					Name: file.Name(), Location: toFileUrl(path)})
 			}
 		}
 	}
+	return list
 }
 
-var specialDirs = [6]string{"base", "fonts", "textures", "plugins", "groups",
-	"systems"}
+func (qs *QuestScreen) loadAllTextures() (ret []resources.Resource) {
+	if dd := qs.defaultDir(); dd != "" {
+		ret = loadTextures(ret, filepath.Join(dd, "textures"))
+	}
+	return loadTextures(ret, qs.DataDir("textures"))
+}
+
+var specialDirs = [7]string{"base", "fonts", "textures", "plugins", "groups",
+	"systems", "templates"}
 
 // Init initializes the static data
 func (qs *QuestScreen) Init(fullscreen bool, width int32, height int32,
-	msaa int, events display.Events, port uint16, debug bool) {
+	msaa int, events display.Events, port uint16, debug bool, headless bool) {
 	mc := messageCollector{owner: qs, moduleIndex: -1}
 
 	usr, _ := user.Current()
@@ -203,33 +229,20 @@ func (qs *QuestScreen) Init(fullscreen bool, width int32, height int32,
 		return
 	}
 
-	setGLAttributes(debug)
-	sdl.GLSetAttribute(sdl.GL_DOUBLEBUFFER, 1)
-
-	if qs.appConfig.msaa > 0 {
-		sdl.GLSetAttribute(sdl.GL_MULTISAMPLEBUFFERS, 1)
-		sdl.GLSetAttribute(sdl.GL_MULTISAMPLESAMPLES, qs.appConfig.msaa)
-		log.Printf("using MSAA samples: %v\n", qs.appConfig.msaa)
-	}
-
-	// create window and renderer
-	var flags uint32 = sdl.WINDOW_OPENGL | sdl.WINDOW_ALLOW_HIGHDPI
-	if qs.fullscreen {
-		flags |= sdl.WINDOW_FULLSCREEN_DESKTOP
-	}
-	window, err := sdl.CreateWindow("QuestScreen", sdl.WINDOWPOS_UNDEFINED,
-		sdl.WINDOWPOS_UNDEFINED, qs.width, qs.height, flags)
-	if err != nil {
-		panic(err)
-	}
-
-	qs.context, err = window.GLCreateContext()
-	if err != nil {
-		panic(err)
+	qs.authenticator = newAuthenticator(qs.appConfig.auth,
+		qs.DataDir("devices.yaml"))
+	qs.headless = headless
+	var windows []*sdl.Window
+	oHeight := qs.height
+	if headless {
+		log.Printf("rendering headless at %dx%d\n", qs.width, qs.height)
+		if len(qs.outputs) > 0 {
+			log.Println("additional outputs are not available in headless mode")
+		}
+	} else {
+		windows = qs.createWindows(debug)
+		_, oHeight = windows[0].GLGetDrawableSize()
 	}
-	sdl.GLSetSwapInterval(1)
-
-	_, oHeight := window.GLGetDrawableSize()
 
 	dd := qs.defaultDir()
 	fontSizeMap := [6]int32{oHeight / 37, oHeight / 27, oHeight / 19,
@@ -245,26 +258,159 @@ func (qs *QuestScreen) Init(fullscreen bool, width int32, height int32,
 			"Please place at least one TTF/OTF font file in " + fontPath)
 	}
 
-	if dd != "" {
-		qs.loadTextures(filepath.Join(dd, "textures"))
-	}
-	qs.loadTextures(qs.DataDir("textures"))
+	qs.textures = qs.loadAllTextures()
 
 	qs.modules = make([]moduleRef, 0, 32)
-	qs.resourceCollections = make([][][]ownedResourceFile, 0, 32)
 	qs.activeGroupIndex = -1
 	qs.activeSystemIndex = -1
 
 	plugins.LoadPlugins(qs)
+	qs.resolveOutputModules(&mc, len(windows))
 
 	qs.persistence, qs.communication = qs.data.LoadPersisted(qs)
 	qs.loadModuleResources()
 
-	if err := qs.display.Init(
-		qs, events, qs.fullscreen, qs.port, qs.keyActions,
-		window, debug); err != nil {
+	welcome := display.Welcome{Port: qs.port, HTTPS: qs.tls.Enabled,
+		PairingCode: qs.authenticator.PairingCode(),
+		WiFi:        qs.wifi.qrContent(), Hostname: qs.listenMDNS()}
+	var err error
+	if headless {
+		err = qs.display.InitHeadless(qs, events, welcome, qs.width, qs.height)
+	} else {
+		err = qs.display.Init(qs, events, welcome, qs.keyActions, windows,
+			qs.context, debug)
+	}
+	if err != nil {
+		panic(err)
+	}
+	qs.resetOutputScenes()
+	if qs.preview.FPS > 0 {
+		qs.display.SetCaptureInterval(time.Second / time.Duration(qs.preview.FPS))
+	}
+}
+
+// createWindows creates the windows of all outputs and the OpenGL context
+// used for all of them. The first window is the primary output.
+func (qs *QuestScreen) createWindows(debug bool) []*sdl.Window {
+	setGLAttributes(debug)
+	sdl.GLSetAttribute(sdl.GL_DOUBLEBUFFER, 1)
+
+	if qs.appConfig.msaa > 0 {
+		sdl.GLSetAttribute(sdl.GL_MULTISAMPLEBUFFERS, 1)
+		sdl.GLSetAttribute(sdl.GL_MULTISAMPLESAMPLES, qs.appConfig.msaa)
+		log.Printf("using MSAA samples: %v\n", qs.appConfig.msaa)
+	}
+
+	windows := make([]*sdl.Window, 1, len(qs.outputs)+1)
+	windows[0] = createWindow("QuestScreen", qs.displayIndex, qs.fullscreen,
+		qs.width, qs.height)
+	var err error
+	qs.context, err = windows[0].GLCreateContext()
+	if err != nil {
+		panic(err)
+	}
+	for i := range qs.outputs {
+		o := &qs.outputs[i]
+		windows = append(windows, createWindow("QuestScreen: "+o.Name,
+			o.Display, o.Fullscreen, o.Width, o.Height))
+	}
+	// waiting for vsync on each window would divide the frame rate by the
+	// number of windows.
+	if len(windows) == 1 {
+		sdl.GLSetSwapInterval(1)
+	} else {
+		sdl.GLSetSwapInterval(0)
+	}
+	return windows
+}
+
+func createWindow(title string, display int32, fullscreen bool,
+	width, height int32) *sdl.Window {
+	var flags uint32 = sdl.WINDOW_OPENGL | sdl.WINDOW_ALLOW_HIGHDPI
+	if fullscreen {
+		flags |= sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+	pos := int32(sdl.WINDOWPOS_UNDEFINED) | display
+	window, err := sdl.CreateWindow(title, pos, pos, width, height, flags)
+	if err != nil {
 		panic(err)
 	}
+	return window
+}
+
+// resolveOutputModules resolves the IDs of the modules shown on each output.
+// Unknown IDs are reported via the given message sender.
+func (qs *QuestScreen) resolveOutputModules(ms server.MessageSender,
+	numOutputs int) {
+	if numOutputs == 0 {
+		// headless mode has a single output.
+		numOutputs = 1
+	}
+	qs.outputModules = make([][]bool, numOutputs)
+	for i := range qs.outputModules {
+		name := "primary output"
+		ids := qs.shownModules
+		if i > 0 {
+			name = "output " + qs.outputs[i-1].Name
+			ids = qs.outputs[i-1].Modules
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		shown := make([]bool, qs.NumModules())
+		for _, id := range ids {
+			found := false
+			for j := shared.FirstModule; j < qs.NumModules(); j++ {
+				if qs.ModuleID(j) == id {
+					shown[j] = true
+					found = true
+					break
+				}
+			}
+			if !found {
+				ms.Warning(name + ": unknown module " + id)
+			}
+		}
+		qs.outputModules[i] = shown
+	}
+}
+
+// outputShows returns whether the given output shows the module at the given
+// index if it is enabled in the output's scene.
+func (qs *QuestScreen) outputShows(output int, index shared.ModuleIndex) bool {
+	return qs.outputModules[output] == nil || qs.outputModules[output][index]
+}
+
+// resetOutputScenes lets all outputs show the active scene.
+func (qs *QuestScreen) resetOutputScenes() {
+	qs.outputScenes = make([]int, qs.display.NumOutputs())
+	for i := range qs.outputScenes {
+		qs.outputScenes[i] = -1
+	}
+}
+
+// shownScenes returns the index of the scene shown on each output, or nil if
+// there is no active group.
+func (qs *QuestScreen) shownScenes() []int {
+	if qs.activeGroup() == nil {
+		return nil
+	}
+	ret := make([]int, len(qs.outputScenes))
+	for i := range ret {
+		ret[i] = qs.sceneOfOutput(i)
+	}
+	return ret
+}
+
+// sceneOfOutput returns the index of the scene in the active group that is
+// shown on the given output.
+func (qs *QuestScreen) sceneOfOutput(output int) int {
+	scene := qs.outputScenes[output]
+	// the scene may have been deleted in the meantime.
+	if scene == -1 || scene >= qs.activeGroup().NumScenes() {
+		return qs.data.ActiveScene()
+	}
+	return scene
 }
 
 // DataDir returns the path to the subdirectory specified by the given list of
@@ -437,7 +583,10 @@ func (qs *QuestScreen) listFiles(
 var forbiddenNames = [7]string{"scenes", "heroes", "fonts", "textures",
 	"plugins", "config.yaml", "state.yaml"}
 
-func (qs *QuestScreen) loadModuleResources() {
+// loadModuleResources scans the resource collections of all modules and
+// replaces the current ones with the result. Returns the previous ones.
+func (qs *QuestScreen) loadModuleResources() [][][]ownedResourceFile {
+	loaded := make([][][]ownedResourceFile, 0, len(qs.modules))
 	for i := range qs.modules {
 		descr := qs.ModuleAt(shared.ModuleIndex(i))
 		collections := make([][]ownedResourceFile, 0, 32)
@@ -445,8 +594,52 @@ func (qs *QuestScreen) loadModuleResources() {
 		for j := range selectors {
 			collections = append(collections, qs.listFiles(qs.ModuleID(shared.ModuleIndex(i)), selectors[j]))
 		}
-		qs.resourceCollections = append(qs.resourceCollections, collections)
+		loaded = append(loaded, collections)
 	}
+	qs.resourceMutex.Lock()
+	defer qs.resourceMutex.Unlock()
+	old := qs.resourceCollections
+	qs.resourceCollections = loaded
+	return old
+}
+
+// rescanResources reloads the lists of resources and textures from the file
+// system. Returns the indexes of all modules whose resource collections have
+// changed, and whether new textures have been found.
+//
+// Since configurations reference textures by index, textures are only ever
+// added; removed textures stay in the list until restart.
+func (qs *QuestScreen) rescanResources() (
+	changed []shared.ModuleIndex, newTextures bool) {
+	old := qs.loadModuleResources()
+	// only the server modifies the collections, so reading them without lock
+	// is safe here.
+	for i := range qs.resourceCollections {
+		if !reflect.DeepEqual(old[i], qs.resourceCollections[i]) {
+			changed = append(changed, shared.ModuleIndex(i))
+		}
+	}
+
+	known := qs.GetTextures()
+	found := qs.loadAllTextures()
+	// copy so that the slice held by the display thread is never written to.
+	textures := known[:len(known):len(known)]
+OUTER:
+	for i := range found {
+		for j := range known {
+			if found[i].Location.String() == known[j].Location.String() {
+				continue OUTER
+			}
+		}
+		textures = append(textures, found[i])
+		newTextures = true
+	}
+	if newTextures {
+		qs.resourceMutex.Lock()
+		qs.textures = textures
+		qs.resourceMutex.Unlock()
+	}
+	return
 }
 
 func (qs *QuestScreen) activeGroup() data.Group {
@@ -484,7 +677,9 @@ func (qs *QuestScreen) NumFontFamilies() int {
 // GetResources filters resources by current group and system.
 func (qs *QuestScreen) GetResources(
 	moduleIndex shared.ModuleIndex, index resources.CollectionIndex) []resources.Resource {
+	qs.resourceMutex.RLock()
 	complete := qs.resourceCollections[moduleIndex][index]
+	qs.resourceMutex.RUnlock()
 	ret := make([]resources.Resource, 0, len(complete))
 	for i := range complete {
 		if (complete[i].group == -1 || complete[i].group == qs.activeGroupIndex) &&
@@ -500,7 +695,10 @@ func (qs *QuestScreen) GetResources(
 }
 
 // GetTextures filters textures by current group and system.
+// The returned slice is never modified; rescanning publishes a new one.
 func (qs *QuestScreen) GetTextures() []resources.Resource {
+	qs.resourceMutex.RLock()
+	defer qs.resourceMutex.RUnlock()
 	return qs.textures
 }
 
@@ -515,6 +713,8 @@ func (qs *QuestScreen) pathToState() string {
 // Returns the index of the currently active scene inside the group
 func (qs *QuestScreen) setActiveGroup(index int) (int, server.Error) {
 	qs.activeGroupIndex = index
+	qs.resetOutputScenes()
+	qs.updateMDNS()
 	if index == -1 {
 		qs.activeSystemIndex = -1
 		return -1, nil
@@ -530,6 +730,9 @@ func (qs *QuestScreen) setActiveGroup(index int) (int, server.Error) {
 }
 
 func (qs *QuestScreen) destroy() {
-	sdl.GLDeleteContext(qs.context)
+	// the display needs the context to release its resources.
 	qs.display.Destroy()
+	if !qs.headless {
+		sdl.GLDeleteContext(qs.context)
+	}
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/QuestScreen/QuestScreen/shared"
+	"github.com/QuestScreen/api/comms"
+	"github.com/QuestScreen/api/resources"
+	"github.com/QuestScreen/api/server"
+)
+
+// maximum size of an uploaded resource file.
+const maxResourceSize = 32 << 20
+
+// maximum size of a request body containing an uploaded file, which is sent
+// base64-encoded in JSON.
+const maxUploadBodySize = maxResourceSize/3*4 + 1<<16
+
+// resourceScope describes where a resource file is stored. This determines for
+// which groups and systems the resource is available.
+type resourceScope int
+
+const (
+	baseScope resourceScope = iota
+	systemScope
+	groupScope
+)
+
+// resourceFileEndpoint implements creating, renaming and deleting the files
+// of a module's resource collection.
+//
+// For systemScope and groupScope, the first ID is the ID of the system or
+// group. For PUT and DELETE, the last ID is the name of the file.
+type resourceFileEndpoint struct {
+	*endpointEnv
+	moduleIndex   shared.ModuleIndex
+	resourceIndex resources.CollectionIndex
+	scope         resourceScope
+}
+
+func (rfe resourceFileEndpoint) maxBodySize() int64 {
+	return maxUploadBodySize
+}
+
+func (rfe resourceFileEndpoint) selector() *resources.Selector {
+	return &rfe.qs.ModuleAt(rfe.moduleIndex).ResourceCollections[rfe.resourceIndex]
+}
+
+// dir returns the directory holding the collection's files in the endpoint's
+// scope, and the remaining ids.
+func (rfe resourceFileEndpoint) dir(ids []string) (string, []string,
+	server.Error) {
+	moduleID := rfe.qs.ModuleID(rfe.moduleIndex)
+	subdir := rfe.selector().Subdirectory
+	switch rfe.scope {
+	case systemScope:
+		if _, s := rfe.qs.data.SystemByID(ids[0]); s == nil {
+			return "", nil, &server.NotFound{Name: ids[0]}
+		}
+		return rfe.qs.DataDir("systems", ids[0], moduleID, subdir), ids[1:], nil
+	case groupScope:
+		if _, g := rfe.qs.data.GroupByID(ids[0]); g == nil {
+			return "", nil, &server.NotFound{Name: ids[0]}
+		}
+		return rfe.qs.DataDir("groups", ids[0], moduleID, subdir), ids[1:], nil
+	default:
+		return rfe.qs.DataDir("base", moduleID, subdir), ids, nil
+	}
+}
+
+// checkName checks whether a file with the given name would be part of the
+// resource collection.
+func (rfe resourceFileEndpoint) checkName(name string) server.Error {
+	if name == "" || name[0] == '.' || strings.ContainsAny(name, "/\\") {
+		return &server.BadRequest{Message: "invalid file name: " + name}
+	}
+	selector := rfe.selector()
+	if selector.Name != "" {
+		if name != selector.Name {
+			return &server.BadRequest{
+				Message: "file must be named " + selector.Name}
+		}
+		return nil
+	}
+	if len(selector.Suffixes) > 0 {
+		suffix := filepath.Ext(name)
+		for i := range selector.Suffixes {
+			if suffix == selector.Suffixes[i] {
+				return nil
+			}
+		}
+		return &server.BadRequest{Message: fmt.Sprintf(
+			"file must have one of the suffixes %s",
+			strings.Join(selector.Suffixes, ", "))}
+	}
+	return nil
+}
+
+func (rfe resourceFileEndpoint) create(dir string, raw []byte) server.Error {
+	var value shared.ResourceUploadRequest
+	if err := comms.ReceiveData(raw,
+		&comms.ValidatedStruct{Value: &value}); err != nil {
+		return &server.BadRequest{Inner: err, Message: "received invalid data"}
+	}
+	if err := rfe.checkName(value.Name); err != nil {
+		return err
+	}
+	if len(value.Content) > maxResourceSize {
+		return &server.BadRequest{Message: fmt.Sprintf(
+			"file too large (maximum is %d MiB)", maxResourceSize>>20)}
+	}
+	path := filepath.Join(dir, value.Name)
+	if _, err := os.Stat(path); err == nil {
+		return &server.BadRequest{Message: "file already exists: " + value.Name}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return &server.InternalError{
+			Description: "unable to create directory", Inner: err}
+	}
+	// write to a hidden file first so that the file watcher does not see a
+	// partially written file.
+	tmp, err := ioutil.TempFile(dir, ".upload")
+	if err != nil {
+		return &server.InternalError{
+			Description: "unable to create file", Inner: err}
+	}
+	_, err = tmp.Write(value.Content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return &server.InternalError{
+			Description: "unable to write file", Inner: err}
+	}
+	return nil
+}
+
+func (rfe resourceFileEndpoint) rename(dir, name string,
+	raw []byte) server.Error {
+	newName := comms.ValidatedString{MinLen: 1, MaxLen: 255}
+	if err := comms.ReceiveData(raw, &newName); err != nil {
+		return &server.BadRequest{Inner: err, Message: "received invalid data"}
+	}
+	if err := rfe.checkName(newName.Value); err != nil {
+		return err
+	}
+	newPath := filepath.Join(dir, newName.Value)
+	if _, err := os.Stat(newPath); err == nil {
+		return &server.BadRequest{
+			Message: "file already exists: " + newName.Value}
+	}
+	if err := os.Rename(filepath.Join(dir, name), newPath); err != nil {
+		return &server.InternalError{
+			Description: "unable to rename file", Inner: err}
+	}
+	return nil
+}
+
+func (rfe resourceFileEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	dir, ids, err := rfe.dir(ids)
+	if err != nil {
+		return nil, err
+	}
+	if method == httpPost {
+		err = rfe.create(dir, raw)
+	} else {
+		name := ids[0]
+		if err = rfe.checkName(name); err != nil {
+			return nil, err
+		}
+		if _, statErr := os.Stat(filepath.Join(dir, name)); statErr != nil {
+			return nil, &server.NotFound{Name: name}
+		}
+		if method == httpPut {
+			err = rfe.rename(dir, name, raw)
+		} else if removeErr := os.Remove(filepath.Join(dir, name)); removeErr != nil {
+			err = &server.InternalError{
+				Description: "unable to delete file", Inner: removeErr}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the file watcher would pick up the change as well, but the client
+	// expects the returned list to be up to date.
+	if err := rfe.rescanResources(); err != nil {
+		log.Println("[resources] unable to rescan: " + err.Error())
+	}
+	return rfe.qs.GetResources(rfe.moduleIndex, rfe.resourceIndex), nil
+}
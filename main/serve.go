@@ -1,17 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 
+	"github.com/QuestScreen/QuestScreen/app"
 	"github.com/QuestScreen/QuestScreen/assets"
+	"github.com/QuestScreen/QuestScreen/data"
 	"github.com/QuestScreen/QuestScreen/shared"
 	"github.com/QuestScreen/api/comms"
 	"github.com/QuestScreen/api/groups"
@@ -24,6 +29,15 @@ import (
 
 // this file implements a RESTful server. The server's API is as follows:
 //
+// If TLS is enabled, the API is served via HTTPS. An additional HTTP listener
+// may be configured that redirects all requests to HTTPS.
+//
+// If a GM password is configured, clients must authenticate with a token
+// given either as Bearer token in the Authorization header or as cookie.
+// Players may only GET /static, /data, /state, /events and /display/*, and
+// POST to the module endpoints configured as playerEndpoints. Unauthenticated
+// clients may only use /login and /auth.
+//
 // /[index.html]
 //   GET: Returns the web client. The file name index.html is optional.
 // /static
@@ -40,30 +54,128 @@ import (
 // /data/systems/<system-id>
 //   PUT: Updates system metadata
 //   DELETE: Deletes the system with the id <system-id>
+// /data/systems/<system-id>/clone
+//   POST: Creates a copy of the system with the name given as payload.
+//         Returns list of all systems.
 // /data/groups
 //   POST: Creates a new group from the payload. Returns list of all groups.
+// /data/groups/import
+//   POST: Imports a group from a zip archive given as payload, as created by
+//         GET /data/groups/<group-id>/export. Returns list of all groups.
 // /data/groups/<group-id>
 //   PUT: Updates group metadata
 //   DELETE: Deletes the group with the id <group-id>
+// /data/groups/<group-id>/clone
+//   POST: Creates a copy of the group including its scenes. The payload gives
+//         the name of the copy and whether heroes, state and resources are
+//         copied as well. Returns list of all groups.
+// /data/groups/<group-id>/export
+//   GET: Returns a zip archive containing the group's data and resources,
+//        and the system referenced by the group.
 // /data/groups/<group-id>/scenes
 //   POST: Creates a new scene from the payload in the group with the given id.
+// /data/groups/<group-id>/scenes/order
+//   POST: Reorders the group's scenes according to the list of scene IDs given
+//         as payload, which must contain each scene exactly once. Returns list
+//         of the group's scenes.
 // /data/groups/<group-id>/scenes/<scene-id>
 //   PUT: Updates scene metadata
 //   DELETE: Deletes the scene with the given id from its group.
+// /data/groups/<group-id>/scenes/<scene-id>/clone
+//   POST: Creates a copy of the scene with the name given as payload in the
+//         same group. Returns list of the group's scenes.
 // /data/groups/<group-id>/heroes
 //   POST: Creates a new hero from the payload in the group with the given id.
 // /data/groups/<group-id>/heroes/<hero-id>
 //   PUT: Updates hero metadata
 //   DELETE: Deletes the hero with the given id from its group.
+// /data/groups/<group-id>/heroes/<hero-id>/portrait
+//   GET: Returns the hero's portrait image.
+//   PUT: Replaces the portrait with the image file given as payload.
+//   DELETE: Removes the portrait.
+//   PUT and DELETE return the list of the group's heroes.
+// /data/templates/groups
+// /data/templates/scenes
+//   POST: Saves the group or scene given in the payload as user template.
+//         Returns the list of plugins including the user templates, as given
+//         in /static.
+// /data/templates/groups/<template-id>
+// /data/templates/scenes/<template-id>
+//   DELETE: Deletes the user template. Returns same data as POST.
+// /login
+//   GET: Returns a page for logging in as GM or pairing a player's device.
+//        Clients that are not authenticated are redirected here.
+// /auth
+//   GET: Returns whether authentication is enabled and the client's role.
+// /auth/login
+//   POST: Issues a GM token if the given password is correct. The token is
+//         returned and set as cookie.
+// /auth/pair
+//   POST: Issues a player token if the given pairing code, which is shown on
+//         the welcome screen, is correct. The token is returned and set as
+//         cookie.
+// /auth/devices
+//   GET: Returns the list of devices that have been issued a token.
+// /auth/devices/<device-id>
+//   DELETE: Revokes the token of the given device.
+// /events
+//   GET: Returns a stream of Server-Sent Events notifying the client about
+//        changes to state, data and configuration (see shared/events.go).
 // /state
 //   GET: Returns the current group, scene, and for each active module its
 //        state.
-//   POST: Changes active group or scene, returns same data as GET.
+//   POST: Changes active group or scene, returns same data as GET. If an
+//         output other than the primary one (index 0) is given, sets the
+//         scene shown on that output instead of the active scene; the scene
+//         index -1 lets the output show the active scene again.
+// /state/undo
+//   POST: Reverts the most recent change of the active scene or of a module's
+//         state in the active group. Returns same data as GET /state.
+// /state/redo
+//   POST: Reapplies the most recently reverted change. Returns same data as
+//         GET /state.
+// /state/playlist
+//   GET: Returns the state of the active group's playlist, which is stored as
+//        playlist.yaml in the group's directory.
+// /state/playlist/start
+//   POST: (Re)starts rotating through the scenes of the active group's
+//         playlist. Returns same data as GET /state/playlist.
+// /state/playlist/stop
+//   POST: Stops the playlist. Returns same data as GET /state/playlist.
+// /state/playlist/next
+//   POST: Advances the running playlist to its next entry. Returns same data
+//         as GET /state/playlist.
 // /state/<plugin-id>/<module-id>[/<endpoint-path>][/<entity-id>]
 //   PUT: Trigger an animation by changing the state of the given module.
+// /backups
+//   GET: Returns the list of snapshots of the data directory.
+//   POST: Creates a new snapshot. Returns the list of snapshots.
+// /backups/<backup-id>/restore
+//   POST: Replaces the data with the content of the given snapshot and ends
+//         the current session. Returns same data as GET /data.
+// /display/snapshot
+//   GET: Returns a PNG image of the most recently rendered frame.
+// /display/stream
+//   GET: Returns the rendered frames as MJPEG stream. Only available if the
+//        configured preview FPS is greater than 0.
 // /resources/<plugin-id>/<module-id>/<index>
 //   GET: Returns the list of resources for the given module at the given
 //        resource index.
+// /resources/<plugin-id>/<module-id>/<index>/base
+// /resources/<plugin-id>/<module-id>/<index>/systems/<system-id>
+// /resources/<plugin-id>/<module-id>/<index>/groups/<group-id>
+//   POST: Uploads a resource file into the base, system or group directory of
+//         the given module's resource collection. Returns same data as GET
+//         on the collection.
+// /resources/<plugin-id>/<module-id>/<index>/base/<file-name>
+// /resources/<plugin-id>/<module-id>/<index>/systems/<system-id>/<file-name>
+// /resources/<plugin-id>/<module-id>/<index>/groups/<group-id>/<file-name>
+//   PUT: Renames the resource file to the name given as payload.
+//   DELETE: Deletes the resource file.
+//   Both return same data as GET on the collection.
+// /resources/rescan
+//   POST: Reloads the lists of resources and textures from the file system.
+//         This is done automatically when files change.
 // /config/base
 //   GET: Returns the base configuration.
 //   PUT: Updates the base configuration.
@@ -108,6 +220,7 @@ func (srh *staticResourceHandler) ServeHTTP(
 
 type primaryFileHandler struct {
 	resources map[string]staticResource
+	auth      *authenticator
 }
 
 func (pfh *primaryFileHandler) ServeHTTP(
@@ -122,7 +235,13 @@ func (pfh *primaryFileHandler) ServeHTTP(
 	}
 	var ok bool
 	var res staticResource
-	if r.URL.Path == "/" || r.URL.Path == "/index.html" || r.URL.Path == "/favicon.ico" {
+	if r.URL.Path == "/" || r.URL.Path == "/index.html" {
+		if pfh.auth.roleOf(r) == anonymousRole {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		res, ok = pfh.resources[r.URL.Path]
+	} else if r.URL.Path == "/favicon.ico" {
 		res, ok = pfh.resources[r.URL.Path]
 	}
 	if ok {
@@ -202,6 +321,20 @@ func newStaticResourceHandler(qs *QuestScreen) *staticResourceHandler {
 type endpointEnv struct {
 	qs     *QuestScreen
 	events display.Events
+	stream *eventStream
+	// set if changed resources could not be sent to the display yet.
+	rescanPending bool
+	// set while the playlist issues requests, whose changes are not recorded
+	// in the history.
+	playlistRequest bool
+}
+
+// record adds the given checkpoint to the history unless the change has been
+// made by the playlist.
+func (env *endpointEnv) record(cp data.Checkpoint) {
+	if !env.playlistRequest {
+		env.qs.data.Record(cp)
+	}
 }
 
 func (env *endpointEnv) sendConfigsToDisplay() server.Error {
@@ -218,16 +351,49 @@ func (env *endpointEnv) sendConfigsToDisplay() server.Error {
 }
 
 func sendScene(qs *QuestScreen, req *display.Request) {
+	for output := range qs.outputScenes {
+		sendOutputScene(qs, req, output)
+	}
+}
+
+func sendOutputScene(qs *QuestScreen, req *display.Request, output int) {
 	data := make([]bool, len(qs.modules))
-	scene := qs.activeGroup().Scene(qs.data.ActiveScene())
+	sceneIndex := qs.sceneOfOutput(output)
+	scene := qs.activeGroup().Scene(sceneIndex)
 	for i := shared.FirstModule; i < qs.NumModules(); i++ {
-		data[i] = scene.UsesModule(i)
+		data[i] = scene.UsesModule(i) && qs.outputShows(output, i)
 		if data[i] {
-			req.SendRendererData(i, qs.data.StateOf(i).CreateRendererData(
-				qs.ServerContext(i)))
+			req.SendRendererData(output, i, qs.data.StateOfScene(
+				sceneIndex, i).CreateRendererData(qs.ServerContext(i)))
+		}
+	}
+	req.SendEnabledModulesList(output, data)
+}
+
+// sendSceneTransitions sets the configured transition on each output whose
+// scene differs from the one given in previous, which may be nil if all
+// outputs changed their scene.
+func sendSceneTransitions(qs *QuestScreen, req *display.Request,
+	previous []int) {
+	for output := range qs.outputScenes {
+		sceneIndex := qs.sceneOfOutput(output)
+		if previous == nil || previous[output] != sceneIndex {
+			req.SetSceneTransition(output, qs.data.SceneTransition(
+				qs.activeGroupIndex, sceneIndex))
+		}
+	}
+}
+
+// sendModuleUpdate sends the given data for the module at the given index to
+// all outputs showing the module in the active scene.
+func sendModuleUpdate(qs *QuestScreen, req *display.Request,
+	index shared.ModuleIndex, data interface{}) {
+	for output := range qs.outputScenes {
+		if qs.sceneOfOutput(output) == qs.data.ActiveScene() &&
+			qs.outputShows(output, index) {
+			req.SendRendererData(output, index, data)
 		}
 	}
-	req.SendEnabledModulesList(data)
 }
 
 func propagateHeroesChange(action groups.HeroChangeAction,
@@ -245,8 +411,10 @@ func propagateHeroesChange(action groups.HeroChangeAction,
 				if ok {
 					ctx := qs.ServerContext(j)
 					hams.HeroListChanged(ctx, action, heroIndex)
-					if i == qs.data.State.ActiveScene() {
-						req.SendRendererData(j, state.CreateRendererData(ctx))
+					for output := range qs.outputScenes {
+						if i == qs.sceneOfOutput(output) && qs.outputShows(output, j) {
+							req.SendRendererData(output, j, state.CreateRendererData(ctx))
+						}
 					}
 				}
 			}
@@ -255,13 +423,22 @@ func propagateHeroesChange(action groups.HeroChangeAction,
 }
 
 func mergeAndSendConfigs(qs *QuestScreen, req *display.Request) {
+	for output := range qs.outputScenes {
+		mergeAndSendOutputConfigs(qs, req, output)
+	}
+}
+
+func mergeAndSendOutputConfigs(qs *QuestScreen, req *display.Request,
+	output int) {
 	g := qs.activeGroup()
 	if g != nil {
-		scene := g.Scene(qs.data.ActiveScene())
+		sceneIndex := qs.sceneOfOutput(output)
+		scene := g.Scene(sceneIndex)
+		aspect := qs.display.OutputAspect(output)
 		for i := shared.FirstModule; i < qs.NumModules(); i++ {
-			if scene.UsesModule(i) {
-				req.SendModuleConfig(i, qs.data.MergeConfig(i,
-					qs.activeSystemIndex, qs.activeGroupIndex, qs.data.ActiveScene()))
+			if scene.UsesModule(i) && qs.outputShows(output, i) {
+				req.SendModuleConfig(output, i, qs.data.MergeConfig(i,
+					qs.activeSystemIndex, qs.activeGroupIndex, sceneIndex, aspect))
 			}
 		}
 	}
@@ -293,16 +470,36 @@ type validatedStateAction struct {
 		Action string `json:"action"`
 		Index  int    `json:"index"`
 	}
-	Action               stateAction
-	MaxGroups, MaxScenes int
+	Output                           int
+	Action                           stateAction
+	MaxGroups, MaxScenes, MaxOutputs int
 }
 
 func (vsa *validatedStateAction) UnmarshalJSON(data []byte) error {
+	// output is optional and defaults to the primary output.
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if output, ok := raw["output"]; ok {
+		if err := json.Unmarshal(output, &vsa.Output); err != nil {
+			return fmt.Errorf("in output: %s", err.Error())
+		}
+		delete(raw, "output")
+		data, _ = json.Marshal(raw)
+	}
 	if err := json.Unmarshal(data, &comms.ValidatedStruct{
 		Value: &vsa.Value}); err != nil {
 		return err
 	}
 
+	if vsa.Output < 0 || vsa.Output > vsa.MaxOutputs {
+		return fmt.Errorf("output out of range [0..%d]", vsa.MaxOutputs)
+	}
+	if vsa.Output != 0 && vsa.Value.Action != "setscene" {
+		return fmt.Errorf("action %s cannot target an output", vsa.Value.Action)
+	}
+
 	switch vsa.Value.Action {
 	case "setgroup":
 		vsa.Action = setgroup
@@ -311,7 +508,12 @@ func (vsa *validatedStateAction) UnmarshalJSON(data []byte) error {
 		}
 	case "setscene":
 		vsa.Action = setscene
-		if vsa.Value.Index < 0 || vsa.Value.Index > vsa.MaxScenes {
+		// additional outputs may be reset to show the active scene via -1.
+		minIndex := 0
+		if vsa.Output != 0 {
+			minIndex = -1
+		}
+		if vsa.Value.Index < minIndex || vsa.Value.Index > vsa.MaxScenes {
 			return fmt.Errorf("index out of range [0..%d]", vsa.Value.Index)
 		}
 	case "leavegroup":
@@ -324,8 +526,6 @@ func (vsa *validatedStateAction) UnmarshalJSON(data []byte) error {
 
 func (se stateEndpoint) Handle(method httpMethods, ids []string,
 	raw []byte) (interface{}, server.Error) {
-	activeScene := -1
-	var modules []json.RawMessage = nil
 	if method == httpPost {
 		g := se.qs.activeGroup()
 		maxScenes := -1
@@ -333,7 +533,7 @@ func (se stateEndpoint) Handle(method httpMethods, ids []string,
 			maxScenes = g.NumScenes() - 1
 		}
 		value := validatedStateAction{MaxGroups: se.qs.data.NumGroups() - 1,
-			MaxScenes: maxScenes}
+			MaxScenes: maxScenes, MaxOutputs: se.qs.display.NumOutputs() - 1}
 		if err := comms.ReceiveData(raw, &value); err != nil {
 			return nil, &server.BadRequest{
 				Inner: err, Message: "received invalid data"}
@@ -352,10 +552,13 @@ func (se stateEndpoint) Handle(method httpMethods, ids []string,
 				return nil, err
 			}
 			defer req.Close()
+			previous := se.qs.shownScenes()
 
 			switch value.Action {
 			case setgroup:
-				activeScene, err = se.qs.setActiveGroup(value.Value.Index)
+				// scene indexes of different groups are not comparable.
+				previous = nil
+				activeScene, err := se.qs.setActiveGroup(value.Value.Index)
 				if err != nil {
 					return nil, err
 				}
@@ -366,31 +569,157 @@ func (se stateEndpoint) Handle(method httpMethods, ids []string,
 				if g == nil {
 					return nil, &server.BadRequest{Message: "No active group"}
 				}
+				if value.Output != 0 {
+					// scenes of additional outputs are not part of the history.
+					se.qs.outputScenes[value.Output] = value.Value.Index
+					break
+				}
 
-				activeScene = value.Value.Index
-				if err := se.qs.data.SetScene(activeScene); err != nil {
+				cp, err := se.qs.data.Checkpoint(-1)
+				if err != nil {
 					return nil, err
 				}
+				changed := value.Value.Index != se.qs.data.ActiveScene()
+				if err := se.qs.data.SetScene(value.Value.Index); err != nil {
+					return nil, err
+				}
+				if changed {
+					se.record(cp)
+				}
 				se.qs.persistence.WriteState()
 			}
 
-			sendScene(se.qs, &req)
-			mergeAndSendConfigs(se.qs, &req)
+			if value.Output == 0 {
+				sendScene(se.qs, &req)
+				mergeAndSendConfigs(se.qs, &req)
+			} else {
+				sendOutputScene(se.qs, &req, value.Output)
+				mergeAndSendOutputConfigs(se.qs, &req, value.Output)
+			}
+			sendSceneTransitions(se.qs, &req, previous)
 			req.Commit()
-			modules = se.qs.communication.ViewSceneState(se.qs)
 		}
+	}
+
+	ret := se.viewState()
+	if method == httpPost {
+		se.stream.publish(shared.StateEvent, ret)
+	}
+	return ret, nil
+}
+
+type historyEndpoint struct {
+	*endpointEnv
+	redo bool
+}
+
+func (he historyEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	if he.qs.activeGroupIndex == -1 {
+		return nil, &server.BadRequest{Message: "No active group"}
+	}
+	var step data.HistoryStep
+	var err server.Error
+	if he.redo {
+		step, err = he.qs.data.NextRedo()
+	} else {
+		step, err = he.qs.data.NextUndo()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// a module change within the active scene is animated like a change done
+	// via the module's endpoint, everything else rebuilds the scene.
+	moduleUpdate := step.Module != -1 && step.Scene == he.qs.data.ActiveScene()
+	var req display.Request
+	if moduleUpdate {
+		req, err = he.qs.display.StartRequest(
+			he.events.ModuleUpdateID, int32(step.Module))
+	} else {
+		req, err = he.qs.display.StartRequest(he.events.SceneChangeID, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer req.Close()
+
+	if err = step.Apply(); err != nil {
+		return nil, err
+	}
+	if moduleUpdate {
+		sendModuleUpdate(he.qs, &req, step.Module, he.qs.data.StateOf(
+			step.Module).CreateRendererData(he.qs.ServerContext(step.Module)))
 	} else {
-		if se.qs.activeGroupIndex != -1 {
-			activeScene = se.qs.data.ActiveScene()
-			modules = se.qs.communication.ViewSceneState(se.qs)
+		sendScene(he.qs, &req)
+		mergeAndSendConfigs(he.qs, &req)
+	}
+	req.Commit()
+	he.qs.persistence.WriteState()
+
+	ret := he.viewState()
+	he.stream.publish(shared.StateEvent, ret)
+	return ret, nil
+}
+
+// resourceAwareState may be implemented by module states that hold resources
+// they queried via server.Context. It is notified when the module's resource
+// collections changed on disk so that the state can query them again.
+type resourceAwareState interface {
+	ResourcesChanged(ctx server.Context)
+}
+
+// rescanResources reloads resources and textures from the file system and
+// updates all module states in the active group that depend on changed
+// resources.
+func (env *endpointEnv) rescanResources() server.Error {
+	changed, newTextures := env.qs.rescanResources()
+	if newTextures {
+		log.Println("[resources] found new textures")
+	}
+	g := env.qs.activeGroup()
+	if len(changed) > 0 {
+		log.Printf("[resources] resources of %d module(s) changed\n", len(changed))
+		if g != nil {
+			for i := 0; i < g.NumScenes(); i++ {
+				scene := g.Scene(i)
+				for _, j := range changed {
+					if scene.UsesModule(j) {
+						if ras, ok := env.qs.data.StateOfScene(i, j).(resourceAwareState); ok {
+							ras.ResourcesChanged(env.qs.ServerContext(j))
+						}
+					}
+				}
+			}
+			env.rescanPending = true
 		}
 	}
+	if g == nil || !env.rescanPending {
+		env.rescanPending = false
+		return nil
+	}
+	// the changed states are sent on the next rescan if the display is busy.
+	req, err := env.qs.display.StartRequest(env.events.SceneChangeID, 0)
+	if err != nil {
+		return err
+	}
+	defer req.Close()
+	env.rescanPending = false
+	sendScene(env.qs, &req)
+	mergeAndSendConfigs(env.qs, &req)
+	req.Commit()
+	env.qs.persistence.WriteState()
+	env.publishState()
+	return nil
+}
+
+type rescanEndpoint struct {
+	*endpointEnv
+}
 
-	return shared.StateResponse{
-		ActiveGroup: se.qs.activeGroupIndex,
-		ActiveScene: activeScene,
-		Modules:     modules,
-	}, nil
+func (re rescanEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	return nil, re.rescanResources()
 }
 
 type resourceEndpoint struct {
@@ -415,6 +744,7 @@ func (bce baseConfigEndpoint) Handle(method httpMethods, ids []string,
 			return nil, err
 		}
 		bce.qs.persistence.WriteBase()
+		bce.publishConfig("base", bce.qs.communication.ViewBaseConfig())
 		return nil, bce.sendConfigsToDisplay()
 	}
 	return bce.qs.communication.ViewBaseConfig(), nil
@@ -435,6 +765,8 @@ func (sce systemConfigEndpoint) Handle(method httpMethods, ids []string,
 			return nil, err
 		}
 		sce.qs.persistence.WriteSystem(s)
+		view, _ := sce.qs.communication.ViewSystemConfig(s)
+		sce.publishConfig("systems/"+s.ID(), view)
 		return nil, sce.sendConfigsToDisplay()
 	}
 	return sce.qs.communication.ViewSystemConfig(s)
@@ -456,6 +788,7 @@ func (gce groupConfigEndpoint) Handle(method httpMethods, ids []string,
 			return nil, err
 		}
 		gce.qs.persistence.WriteGroup(g)
+		gce.publishConfig("groups/"+g.ID(), gce.qs.communication.ViewGroupConfig(g))
 		return nil, gce.sendConfigsToDisplay()
 	}
 	return gce.qs.communication.ViewGroupConfig(g), nil
@@ -480,6 +813,8 @@ func (sce sceneConfigEndpoint) Handle(method httpMethods, ids []string,
 			return nil, err
 		}
 		sce.qs.persistence.WriteScene(g, s)
+		sce.publishConfig("groups/"+g.ID()+"/scenes/"+s.ID(),
+			sce.qs.communication.ViewSceneConfig(s))
 		return nil, sce.sendConfigsToDisplay()
 	}
 	return sce.qs.communication.ViewSceneConfig(s), nil
@@ -513,6 +848,11 @@ func (me *moduleEndpoint) Handle(method httpMethods, ids []string,
 	}
 	defer req.Close()
 
+	cp, err := me.qs.data.Checkpoint(me.moduleIndex)
+	if err != nil {
+		return nil, err
+	}
+
 	var responseObj, data interface{}
 	if me.pure {
 		ep := state.(modules.PureEndpointProvider).PureEndpoint(me.endpointIndex)
@@ -525,9 +865,11 @@ func (me *moduleEndpoint) Handle(method httpMethods, ids []string,
 		return nil, err
 	}
 
-	req.SendRendererData(me.moduleIndex, data)
+	sendModuleUpdate(me.qs, &req, me.moduleIndex, data)
 	req.Commit()
+	me.record(cp)
 	me.qs.persistence.WriteState()
+	me.publishModule(me.moduleIndex)
 	return responseObj, nil
 }
 
@@ -561,9 +903,37 @@ func (se systemEndpoint) Handle(method httpMethods, ids []string,
 	} else {
 		err = se.qs.persistence.DeleteSystem(index)
 	}
+	if err == nil {
+		se.publishData()
+	}
 	return se.qs.communication.ViewSystems(), err
 }
 
+type systemCloneEndpoint struct {
+	*endpointEnv
+}
+
+func (sce systemCloneEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	index, s := sce.qs.data.SystemByID(ids[0])
+	if s == nil {
+		return nil, &server.NotFound{Name: ids[0]}
+	}
+	name := comms.ValidatedString{MinLen: 1, MaxLen: -1}
+	if err := comms.ReceiveData(raw, &name); err != nil {
+		return nil, &server.BadRequest{Inner: err, Message: "received invalid data"}
+	}
+	if err := sce.qs.persistence.CloneSystem(index, name.Value); err != nil {
+		return nil, err
+	}
+	// systems are sorted, so the index of the active one may have changed.
+	if g := sce.qs.activeGroup(); g != nil {
+		sce.qs.activeSystemIndex = g.SystemIndex()
+	}
+	sce.publishData()
+	return sce.qs.communication.ViewSystems(), nil
+}
+
 type dataGroupEndpoint struct {
 	*endpointEnv
 }
@@ -586,9 +956,99 @@ func (dge dataGroupEndpoint) Handle(method httpMethods, ids []string,
 	} else {
 		dge.qs.persistence.DeleteGroup(index)
 	}
+	dge.publishData()
 	return dge.qs.communication.ViewGroups(), nil
 }
 
+type groupExportEndpoint struct {
+	*endpointEnv
+}
+
+func (gee groupExportEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	_, g := gee.qs.data.GroupByID(ids[0])
+	if g == nil {
+		return nil, &server.NotFound{Name: ids[0]}
+	}
+	var b bytes.Buffer
+	if err := gee.qs.persistence.ExportGroup(g, &b); err != nil {
+		return nil, &server.InternalError{
+			Description: "while exporting group", Inner: err}
+	}
+	return fileResponse{name: g.ID() + ".zip", contentType: "application/zip",
+		content: b.Bytes()}, nil
+}
+
+type groupCloneEndpoint struct {
+	*endpointEnv
+}
+
+func (gce groupCloneEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	index, g := gce.qs.data.GroupByID(ids[0])
+	if g == nil {
+		return nil, &server.NotFound{Name: ids[0]}
+	}
+	var value shared.GroupCloneRequest
+	if err := comms.ReceiveData(raw,
+		&comms.ValidatedStruct{Value: &value}); err != nil {
+		return nil, &server.BadRequest{Inner: err, Message: "received invalid data"}
+	}
+	if value.Name == "" {
+		return nil, &server.BadRequest{Message: "name must not be empty"}
+	}
+	activeID := ""
+	if active := gce.qs.activeGroup(); active != nil {
+		activeID = active.ID()
+	}
+	if _, err := gce.qs.persistence.CloneGroup(index, value.Name,
+		data.GroupCloneOptions{Heroes: value.Heroes, State: value.State,
+			Resources: value.Resources}); err != nil {
+		return nil, &server.InternalError{
+			Description: "while cloning group", Inner: err}
+	}
+	// groups are sorted, so the index of the active one may have changed.
+	if activeID != "" {
+		gce.qs.activeGroupIndex, _ = gce.qs.data.GroupByID(activeID)
+	}
+	if value.Resources {
+		gce.qs.loadModuleResources()
+	}
+	gce.publishData()
+	return gce.qs.communication.ViewGroups(), nil
+}
+
+// maximum size of an imported group archive.
+const maxImportSize = 256 << 20
+
+type groupImportEndpoint struct {
+	*endpointEnv
+}
+
+func (gie groupImportEndpoint) maxBodySize() int64 {
+	return maxImportSize
+}
+
+func (gie groupImportEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	activeID := ""
+	if g := gie.qs.activeGroup(); g != nil {
+		activeID = g.ID()
+	}
+	if _, err := gie.qs.persistence.ImportGroup(raw); err != nil {
+		return nil, &server.BadRequest{Inner: err, Message: "invalid archive"}
+	}
+	// groups and systems are sorted, so the indexes of the active ones may have
+	// changed.
+	if activeID != "" {
+		gie.qs.activeGroupIndex, _ = gie.qs.data.GroupByID(activeID)
+		gie.qs.activeSystemIndex = gie.qs.activeGroup().SystemIndex()
+	}
+	gie.qs.loadModuleResources()
+	gie.publishData()
+	return gie.qs.communication.ViewGroups(), nil
+}
+
 type dataSystemsEndpoint struct {
 	*endpointEnv
 }
@@ -602,6 +1062,7 @@ func (sc dataSystemsEndpoint) Handle(method httpMethods, ids []string,
 	if err := sc.qs.persistence.CreateSystem(name.Value); err != nil {
 		return nil, err
 	}
+	sc.publishData()
 	return sc.qs.communication.ViewSystems(), nil
 }
 
@@ -609,6 +1070,9 @@ type dataGroupsEndpoint struct {
 	*endpointEnv
 }
 
+// groupCreationReceiver receives a GroupCreationRequest. The plugin index after
+// the last plugin references the group templates saved by the user, which are
+// selected by TemplateID instead of GroupTemplateIndex.
 type groupCreationReceiver struct {
 	shared.GroupCreationRequest
 	plugins []pluginData
@@ -622,12 +1086,19 @@ func (gcr *groupCreationReceiver) UnmarshalJSON(data []byte) error {
 	if gcr.Name == "" {
 		return errors.New("name must not be empty")
 	} else if gcr.PluginIndex < 0 ||
-		gcr.PluginIndex >= len(gcr.plugins) {
-		return fmt.Errorf("pluginIndex out of range [0..%d]", len(gcr.plugins)-1)
-	} else if gcr.GroupTemplateIndex < 0 ||
-		gcr.GroupTemplateIndex >= len(gcr.plugins[gcr.PluginIndex].GroupTemplates) {
+		gcr.PluginIndex > len(gcr.plugins) {
+		return fmt.Errorf("pluginIndex out of range [0..%d]", len(gcr.plugins))
+	}
+	if gcr.PluginIndex == len(gcr.plugins) {
+		if gcr.TemplateID == "" {
+			return errors.New("templateID must not be empty")
+		}
+		return nil
+	}
+	numTemplates := len(gcr.plugins[gcr.PluginIndex].GroupTemplates)
+	if gcr.GroupTemplateIndex < 0 || gcr.GroupTemplateIndex >= numTemplates {
 		return fmt.Errorf("groupTemplateIndex out of range [0..%d]",
-			len(gcr.plugins[gcr.PluginIndex].GroupTemplates)-1)
+			numTemplates-1)
 	}
 	return nil
 }
@@ -638,12 +1109,24 @@ func (dge dataGroupsEndpoint) Handle(method httpMethods, ids []string,
 	if err := comms.ReceiveData(raw, &value); err != nil {
 		return nil, &server.BadRequest{Inner: err, Message: "received invalid data"}
 	}
-	if err := dge.qs.persistence.CreateGroup(value.Name,
-		&dge.qs.plugins[value.PluginIndex].Plugin.GroupTemplates[value.GroupTemplateIndex],
-		dge.qs.plugins[value.PluginIndex].Plugin.SceneTemplates); err != nil {
+	var tmpl *app.GroupTemplate
+	var sceneTmpls []app.SceneTemplate
+	if value.PluginIndex == len(dge.qs.plugins) {
+		tmpl, sceneTmpls = dge.qs.data.GroupTemplateByID(value.TemplateID)
+		if tmpl == nil {
+			return nil, &server.NotFound{Name: value.TemplateID}
+		}
+	} else {
+		plugin := dge.qs.plugins[value.PluginIndex].Plugin
+		tmpl = &plugin.GroupTemplates[value.GroupTemplateIndex]
+		sceneTmpls = plugin.SceneTemplates
+	}
+	if err := dge.qs.persistence.CreateGroup(
+		value.Name, tmpl, sceneTmpls); err != nil {
 		return nil, &server.InternalError{
 			Description: "while creating group", Inner: err}
 	}
+	dge.publishData()
 	return dge.qs.communication.ViewGroups(), nil
 }
 
@@ -651,6 +1134,9 @@ type dataScenesEndpoint struct {
 	*endpointEnv
 }
 
+// sceneCreationReceiver receives a SceneCreationRequest. The plugin index after
+// the last plugin references the scene templates saved by the user, which are
+// selected by TemplateID instead of SceneTemplateIndex.
 type sceneCreationReceiver struct {
 	shared.SceneCreationRequest
 	plugins []pluginData
@@ -664,12 +1150,19 @@ func (scr *sceneCreationReceiver) UnmarshalJSON(data []byte) error {
 	if scr.Name == "" {
 		return errors.New("name must not be empty")
 	} else if scr.PluginIndex < 0 ||
-		scr.PluginIndex >= len(scr.plugins) {
-		return fmt.Errorf("pluginIndex out of range [0..%d]", len(scr.plugins)-1)
-	} else if scr.SceneTemplateIndex < 0 ||
-		scr.SceneTemplateIndex >= len(scr.plugins[scr.PluginIndex].SceneTemplates) {
-		return fmt.Errorf("groupTemplateIndex out of range [0..%d]",
-			len(scr.plugins[scr.PluginIndex].SceneTemplates)-1)
+		scr.PluginIndex > len(scr.plugins) {
+		return fmt.Errorf("pluginIndex out of range [0..%d]", len(scr.plugins))
+	}
+	if scr.PluginIndex == len(scr.plugins) {
+		if scr.TemplateID == "" {
+			return errors.New("templateID must not be empty")
+		}
+		return nil
+	}
+	numTemplates := len(scr.plugins[scr.PluginIndex].SceneTemplates)
+	if scr.SceneTemplateIndex < 0 || scr.SceneTemplateIndex >= numTemplates {
+		return fmt.Errorf("sceneTemplateIndex out of range [0..%d]",
+			numTemplates-1)
 	}
 	return nil
 }
@@ -685,11 +1178,20 @@ func (dse dataScenesEndpoint) Handle(method httpMethods, ids []string,
 		return nil, &server.BadRequest{Inner: err, Message: "received invalid data"}
 	}
 
-	if err := dse.qs.persistence.CreateScene(group, value.Name,
-		&dse.qs.plugins[value.PluginIndex].SceneTemplates[value.SceneTemplateIndex]); err != nil {
+	var tmpl *app.SceneTemplate
+	if value.PluginIndex == len(dse.qs.plugins) {
+		tmpl = dse.qs.data.SceneTemplateByID(value.TemplateID)
+		if tmpl == nil {
+			return nil, &server.NotFound{Name: value.TemplateID}
+		}
+	} else {
+		tmpl = &dse.qs.plugins[value.PluginIndex].SceneTemplates[value.SceneTemplateIndex]
+	}
+	if err := dse.qs.persistence.CreateScene(group, value.Name, tmpl); err != nil {
 		return nil, &server.InternalError{
 			Description: "while creating scene", Inner: err}
 	}
+	dse.publishData()
 	return dse.qs.communication.ViewScenes(group), nil
 }
 
@@ -699,7 +1201,7 @@ type dataSceneEndpoint struct {
 
 func (dse dataSceneEndpoint) Handle(method httpMethods, ids []string,
 	raw []byte) (interface{}, server.Error) {
-	_, group := dse.qs.data.GroupByID(ids[0])
+	groupIndex, group := dse.qs.data.GroupByID(ids[0])
 	if group == nil {
 		return nil, &server.NotFound{Name: ids[0]}
 	}
@@ -714,14 +1216,166 @@ func (dse dataSceneEndpoint) Handle(method httpMethods, ids []string,
 		if err := dse.qs.persistence.WriteScene(group, scene); err != nil {
 			log.Println("failed to persist scene: " + err.Error())
 		}
-		// TODO: check scene index; if active scene, update stuff since index could
-		// have been changed due to reordering
 	} else {
 		dse.qs.persistence.DeleteScene(group, sceneIndex)
+		if groupIndex == dse.qs.activeGroupIndex {
+			if err := dse.removeOutputScene(sceneIndex); err != nil {
+				return nil, err
+			}
+		}
 	}
+	dse.publishData()
 	return dse.qs.communication.ViewScenes(group), nil
 }
 
+// removeOutputScene updates the scenes shown on the outputs after the scene at
+// the given index of the active group has been deleted. Outputs showing the
+// deleted scene fall back to the active scene, the indexes of later scenes move
+// down by one.
+func (env *endpointEnv) removeOutputScene(sceneIndex int) server.Error {
+	var fallback []int
+	for output, index := range env.qs.outputScenes {
+		if index == sceneIndex {
+			env.qs.outputScenes[output] = -1
+			fallback = append(fallback, output)
+		} else if index > sceneIndex {
+			env.qs.outputScenes[output] = index - 1
+		}
+	}
+	if len(fallback) > 0 {
+		req, err := env.qs.display.StartRequest(env.events.SceneChangeID, 0)
+		if err != nil {
+			return err
+		}
+		defer req.Close()
+		for _, output := range fallback {
+			sendOutputScene(env.qs, &req, output)
+			mergeAndSendOutputConfigs(env.qs, &req, output)
+			req.SetSceneTransition(output, env.qs.data.SceneTransition(
+				env.qs.activeGroupIndex, env.qs.sceneOfOutput(output)))
+		}
+		req.Commit()
+	}
+	env.publishState()
+	return nil
+}
+
+type sceneOrderEndpoint struct {
+	*endpointEnv
+}
+
+func (soe sceneOrderEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	groupIndex, group := soe.qs.data.GroupByID(ids[0])
+	if group == nil {
+		return nil, &server.NotFound{Name: ids[0]}
+	}
+	var order []string
+	if err := comms.ReceiveData(raw, &order); err != nil {
+		return nil, &server.BadRequest{Inner: err, Message: "received invalid data"}
+	}
+	moved, err := soe.qs.persistence.ReorderScenes(group, order)
+	if err != nil {
+		return nil, err
+	}
+	if groupIndex == soe.qs.activeGroupIndex {
+		// the shown scenes do not change, only their indexes.
+		for output, sceneIndex := range soe.qs.outputScenes {
+			if sceneIndex != -1 {
+				soe.qs.outputScenes[output] = moved[sceneIndex]
+			}
+		}
+		soe.publishState()
+	}
+	soe.publishData()
+	return soe.qs.communication.ViewScenes(group), nil
+}
+
+type sceneCloneEndpoint struct {
+	*endpointEnv
+}
+
+func (sce sceneCloneEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	_, group := sce.qs.data.GroupByID(ids[0])
+	if group == nil {
+		return nil, &server.NotFound{Name: ids[0]}
+	}
+	sceneIndex, scene := group.SceneByID(ids[1])
+	if scene == nil {
+		return nil, &server.NotFound{Name: ids[1]}
+	}
+	name := comms.ValidatedString{MinLen: 1, MaxLen: -1}
+	if err := comms.ReceiveData(raw, &name); err != nil {
+		return nil, &server.BadRequest{Inner: err, Message: "received invalid data"}
+	}
+	if err := sce.qs.persistence.CloneScene(
+		group, sceneIndex, name.Value); err != nil {
+		return nil, &server.InternalError{
+			Description: "while cloning scene", Inner: err}
+	}
+	sce.publishData()
+	return sce.qs.communication.ViewScenes(group), nil
+}
+
+type dataTemplatesEndpoint struct {
+	*endpointEnv
+	scenes bool
+}
+
+func (dte dataTemplatesEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	var value shared.TemplateCreationRequest
+	if err := comms.ReceiveData(raw,
+		&comms.ValidatedStruct{Value: &value}); err != nil {
+		return nil, &server.BadRequest{Inner: err, Message: "received invalid data"}
+	}
+	if value.Name == "" {
+		return nil, &server.BadRequest{Message: "name must not be empty"}
+	}
+	_, group := dte.qs.data.GroupByID(value.Group)
+	if group == nil {
+		return nil, &server.NotFound{Name: value.Group}
+	}
+	if dte.scenes {
+		sceneIndex, scene := group.SceneByID(value.Scene)
+		if scene == nil {
+			return nil, &server.NotFound{Name: value.Scene}
+		}
+		if err := dte.qs.persistence.SaveSceneTemplate(group, sceneIndex,
+			value.Name, value.Description); err != nil {
+			return nil, &server.InternalError{
+				Description: "while saving scene template", Inner: err}
+		}
+	} else if err := dte.qs.persistence.SaveGroupTemplate(group, value.Name,
+		value.Description); err != nil {
+		return nil, &server.InternalError{
+			Description: "while saving group template", Inner: err}
+	}
+	dte.publishTemplates()
+	return dte.qs.communication.ViewTemplates(dte.qs), nil
+}
+
+type dataTemplateEndpoint struct {
+	*endpointEnv
+	scenes bool
+}
+
+func (dte dataTemplateEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	var err server.Error
+	if dte.scenes {
+		err = dte.qs.persistence.DeleteSceneTemplate(ids[0])
+	} else {
+		err = dte.qs.persistence.DeleteGroupTemplate(ids[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	dte.publishTemplates()
+	return dte.qs.communication.ViewTemplates(dte.qs), nil
+}
+
 type dataHeroesEndpoint struct {
 	*endpointEnv
 }
@@ -749,32 +1403,39 @@ func (dhe dataHeroesEndpoint) Handle(method httpMethods, ids []string,
 	propagateHeroesChange(groups.HeroAdded, heroes.NumHeroes()-1, dhe.qs,
 		&req)
 	req.Commit()
+	dhe.publishHeroesChange(group)
 	return dhe.qs.communication.ViewHeroes(heroes), nil
 }
 
+// heroByID returns the group with the ID ids[0] and the index of its hero
+// with the ID ids[1].
+func (env *endpointEnv) heroByID(ids []string) (data.Group, int,
+	server.Error) {
+	_, group := env.qs.data.GroupByID(ids[0])
+	if group == nil {
+		return nil, -1, &server.NotFound{Name: ids[0]}
+	}
+	heroes := group.Heroes()
+	for i := 0; i < heroes.NumHeroes(); i++ {
+		if heroes.Hero(i).ID() == ids[1] {
+			return group, i, nil
+		}
+	}
+	return nil, -1, &server.NotFound{Name: ids[1]}
+}
+
 type dataHeroEndpoint struct {
 	*endpointEnv
 }
 
 func (dhe dataHeroEndpoint) Handle(method httpMethods, ids []string,
 	raw []byte) (interface{}, server.Error) {
-	_, group := dhe.qs.data.GroupByID(ids[0])
-	if group == nil {
-		return nil, &server.NotFound{Name: ids[0]}
+	group, heroIndex, err := dhe.heroByID(ids)
+	if err != nil {
+		return nil, err
 	}
 	heroes := group.Heroes()
-	var hero groups.Hero
-	var heroIndex int = -1
-	for i := 0; i < heroes.NumHeroes(); i++ {
-		hero = heroes.Hero(i)
-		if hero.ID() == ids[1] {
-			heroIndex = i
-			break
-		}
-	}
-	if heroIndex == -1 {
-		return nil, &server.NotFound{Name: ids[1]}
-	}
+	hero := heroes.Hero(heroIndex)
 	req, err := dhe.qs.display.StartRequest(dhe.events.HeroesChangedID, 0)
 	if err != nil {
 		return nil, err
@@ -795,50 +1456,176 @@ func (dhe dataHeroEndpoint) Handle(method httpMethods, ids []string,
 	}
 	propagateHeroesChange(action, heroIndex, dhe.qs, &req)
 	req.Commit()
+	dhe.publishHeroesChange(group)
 
 	return dhe.qs.communication.ViewHeroes(heroes), nil
 }
 
+// suffixes of files accepted as hero portrait.
+var portraitSuffixes = []string{".png", ".jpg", ".jpeg"}
+
+// heroPortraitEndpoint serves, replaces and deletes the portrait of a hero.
+// PUT takes a ResourceUploadRequest whose name is only used for the suffix.
+type heroPortraitEndpoint struct {
+	*endpointEnv
+}
+
+func (hpe heroPortraitEndpoint) maxBodySize() int64 {
+	return maxUploadBodySize
+}
+
+func (hpe heroPortraitEndpoint) Handle(method httpMethods, ids []string,
+	raw []byte) (interface{}, server.Error) {
+	group, heroIndex, err := hpe.heroByID(ids)
+	if err != nil {
+		return nil, err
+	}
+	heroes := group.Heroes()
+	hero := heroes.Hero(heroIndex)
+	if method == httpGet {
+		path := hpe.qs.persistence.HeroPortraitPath(group, hero)
+		if path == "" {
+			return nil, &server.NotFound{Name: "portrait"}
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, &server.InternalError{
+				Description: "unable to read portrait", Inner: err}
+		}
+		return fileResponse{
+			contentType: mime.TypeByExtension(filepath.Ext(path)),
+			content:     content}, nil
+	}
+	var suffix string
+	var content []byte
+	if method == httpPut {
+		var value shared.ResourceUploadRequest
+		if err := comms.ReceiveData(raw,
+			&comms.ValidatedStruct{Value: &value}); err != nil {
+			return nil, &server.BadRequest{Inner: err, Message: "received invalid data"}
+		}
+		suffix = strings.ToLower(filepath.Ext(value.Name))
+		valid := false
+		for i := range portraitSuffixes {
+			if suffix == portraitSuffixes[i] {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, &server.BadRequest{Message: fmt.Sprintf(
+				"file must have one of the suffixes %s",
+				strings.Join(portraitSuffixes, ", "))}
+		}
+		if len(value.Content) > maxResourceSize {
+			return nil, &server.BadRequest{Message: fmt.Sprintf(
+				"file too large (maximum is %d MiB)", maxResourceSize>>20)}
+		}
+		content = value.Content
+	}
+	req, err := hpe.qs.display.StartRequest(hpe.events.HeroesChangedID, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer req.Close()
+	if err := hpe.qs.persistence.SetHeroPortrait(
+		group, hero, suffix, content); err != nil {
+		return nil, &server.InternalError{
+			Description: "while writing portrait", Inner: err}
+	}
+	propagateHeroesChange(groups.HeroModified, heroIndex, hpe.qs, &req)
+	req.Commit()
+	hpe.publishHeroesChange(group)
+	return hpe.qs.communication.ViewHeroes(heroes), nil
+}
+
 func startServer(owner *QuestScreen, events display.Events,
 	port uint16) (server *http.Server, err error) {
 	server = &http.Server{Addr: ":" + strconv.Itoa(int(port))}
-	env := &endpointEnv{qs: owner, events: events}
-	mutex := &sync.Mutex{}
+	env := &endpointEnv{qs: owner, events: events, stream: newEventStream()}
+	guard := &serverGuard{auth: owner.authenticator}
 
 	sep := newStaticResourceHandler(owner)
 	http.Handle("/static/", sep)
-	http.Handle("/", &primaryFileHandler{sep.resources})
-
-	reg("StaticDataHandler", "/static", mutex,
+	http.Handle("/", &primaryFileHandler{sep.resources, owner.authenticator})
+	http.Handle("/events", guard.auth.protect("EventStream", env.stream))
+	http.Handle("/login", loginPageHandler{})
+	auth := &authEndpoints{auth: guard.auth, secure: owner.tls.Enabled}
+	http.Handle("/auth", auth)
+	http.Handle("/auth/", auth)
+
+	reg("StaticDataHandler", "/static", guard,
 		endpoint{httpGet, &staticDataEndpoint{env}})
-	reg("DataHandler", "/data", mutex, endpoint{httpGet, &dataEndpoint{env}})
-	reg("StateHandler", "/state", mutex,
+	reg("DataHandler", "/data", guard, endpoint{httpGet, &dataEndpoint{env}})
+	reg("StateHandler", "/state", guard,
 		endpoint{httpGet | httpPost, &stateEndpoint{env}})
 
 	// if no fonts are found, QuestScreen is not operable. We only provide static
 	// data (telling the client no fonts are available) and the static resources.
 	if len(owner.fonts) > 0 {
 
-		reg("BaseConfigHandler", "/config/base", mutex,
+		reg("UndoHandler", "/state/undo", guard,
+			endpoint{httpPost, historyEndpoint{endpointEnv: env, redo: false}})
+		reg("RedoHandler", "/state/redo", guard,
+			endpoint{httpPost, historyEndpoint{endpointEnv: env, redo: true}})
+		reg("BackupsHandler", "/backups", guard,
+			endpoint{httpGet | httpPost, backupsEndpoint{env}})
+		reg("BackupHandler", "/backups/", guard, idCapture{},
+			pathFragment("restore"), endpoint{httpPost, backupRestoreEndpoint{env}})
+		if owner.backups.Interval > 0 {
+			go owner.runBackupScheduler(&guard.Mutex)
+		}
+		reg("SnapshotHandler", "/display/snapshot", guard,
+			endpoint{httpGet, snapshotEndpoint{env}})
+		if owner.preview.FPS > 0 {
+			http.Handle("/display/stream", guard.auth.protect("PreviewStream",
+				&previewStream{display: &owner.display, config: owner.preview}))
+		}
+		reg("RescanHandler", "/resources/rescan", guard,
+			endpoint{httpPost, rescanEndpoint{env}})
+		owner.watchResources(func() bool {
+			guard.Lock()
+			defer guard.Unlock()
+			if err := env.rescanResources(); err != nil {
+				log.Println("[resources] rescan failed: " + err.Error())
+				return false
+			}
+			return true
+		})
+		reg("BaseConfigHandler", "/config/base", guard,
 			endpoint{httpGet | httpPut, &baseConfigEndpoint{env}})
-		reg("SystemConfigHandler", "/config/systems/", mutex,
+		reg("SystemConfigHandler", "/config/systems/", guard,
 			idCapture{}, endpoint{httpGet | httpPut, &systemConfigEndpoint{env}})
-		reg("GroupConfigHandler", "/config/groups/", mutex,
+		reg("GroupConfigHandler", "/config/groups/", guard,
 			idCapture{}, endpoint{httpGet | httpPut, &groupConfigEndpoint{env}},
 			pathFragment("scenes"), idCapture{},
 			endpoint{httpGet | httpPut, &sceneConfigEndpoint{env}})
-		reg("DataSystemsHandler", "/data/systems", mutex,
+		reg("DataSystemsHandler", "/data/systems", guard,
 			endpoint{httpPost, &dataSystemsEndpoint{env}})
-		reg("DataSystemHandler", "/data/systems/", mutex, idCapture{},
-			endpoint{httpPut | httpDelete, &systemEndpoint{env}})
-		reg("DataGroupsHandler", "/data/groups", mutex,
+		reg("DataSystemHandler", "/data/systems/", guard, idCapture{},
+			endpoint{httpPut | httpDelete, &systemEndpoint{env}},
+			pathFragment("clone"), endpoint{httpPost, systemCloneEndpoint{env}})
+		reg("DataGroupsHandler", "/data/groups", guard,
 			endpoint{httpPost, &dataGroupsEndpoint{env}})
-		reg("DataGroupHandler", "/data/groups/", mutex, idCapture{},
+		reg("DataGroupHandler", "/data/groups/", guard, idCapture{},
 			endpoint{httpPut | httpDelete, &dataGroupEndpoint{env}},
+			&branch{"scenes/order"}, endpoint{httpPost, sceneOrderEndpoint{env}},
 			&branch{"scenes"}, endpoint{httpPost, &dataScenesEndpoint{env}},
 			idCapture{}, endpoint{httpPut | httpDelete, &dataSceneEndpoint{env}},
+			pathFragment("clone"), endpoint{httpPost, sceneCloneEndpoint{env}},
 			&branch{"heroes"}, endpoint{httpPost, &dataHeroesEndpoint{env}},
-			idCapture{}, endpoint{httpPut | httpDelete, &dataHeroEndpoint{env}})
+			idCapture{}, endpoint{httpPut | httpDelete, &dataHeroEndpoint{env}},
+			pathFragment("portrait"), endpoint{httpGet | httpPut | httpDelete,
+				heroPortraitEndpoint{env}},
+			&branch{"export"}, endpoint{httpGet, groupExportEndpoint{env}},
+			&branch{"clone"}, endpoint{httpPost, groupCloneEndpoint{env}})
+		reg("DataGroupImportHandler", "/data/groups/import", guard,
+			endpoint{httpPost, groupImportEndpoint{env}})
+		reg("DataTemplatesHandler", "/data/templates/", guard,
+			&branch{"groups"}, endpoint{httpPost, dataTemplatesEndpoint{endpointEnv: env}},
+			idCapture{}, endpoint{httpDelete, dataTemplateEndpoint{endpointEnv: env}},
+			&branch{"scenes"}, endpoint{httpPost, dataTemplatesEndpoint{endpointEnv: env, scenes: true}},
+			idCapture{}, endpoint{httpDelete, dataTemplateEndpoint{endpointEnv: env, scenes: true}})
 
 		var builder strings.Builder
 		moduleIndex := shared.FirstModule
@@ -858,8 +1645,21 @@ func startServer(owner *QuestScreen, events display.Events,
 					builder.WriteByte('/')
 					builder.WriteString(strconv.Itoa(j))
 					reg(fmt.Sprintf("ResourceEndpoint(%v/%v/%v)", plugin.id, module.ID, j), builder.String(),
-						mutex, endpoint{httpGet, resourceEndpoint{
+						guard, endpoint{httpGet, resourceEndpoint{
 							endpointEnv: env, moduleIndex: moduleIndex, resourceIndex: resources.CollectionIndex(j)}})
+					files := func(scope resourceScope) resourceFileEndpoint {
+						return resourceFileEndpoint{endpointEnv: env, moduleIndex: moduleIndex,
+							resourceIndex: resources.CollectionIndex(j), scope: scope}
+					}
+					builder.WriteByte('/')
+					reg(fmt.Sprintf("ResourceFileEndpoint(%v/%v/%v)", plugin.id, module.ID, j),
+						builder.String(), guard,
+						&branch{"base"}, endpoint{httpPost, files(baseScope)}, idCapture{},
+						endpoint{httpPut | httpDelete, files(baseScope)},
+						&branch{"systems"}, idCapture{}, endpoint{httpPost, files(systemScope)},
+						idCapture{}, endpoint{httpPut | httpDelete, files(systemScope)},
+						&branch{"groups"}, idCapture{}, endpoint{httpPost, files(groupScope)},
+						idCapture{}, endpoint{httpPut | httpDelete, files(groupScope)})
 				}
 
 				for endpointIndex, path := range module.EndpointPaths {
@@ -897,12 +1697,12 @@ func startServer(owner *QuestScreen, events display.Events,
 					builder.WriteString(path)
 					location := builder.String()
 					if len(path) != 0 && path[len(path)-1] == '/' {
-						reg("ModuleEndpoint("+location[7:]+")", location, mutex,
+						reg("ModuleEndpoint("+location[7:]+")", location, guard,
 							idCapture{}, endpoint{httpPost, &moduleEndpoint{endpointEnv: env,
 								moduleIndex: moduleIndex, endpointIndex: endpointIndex,
 								pure: false}})
 					} else {
-						reg("ModuleEndpoint("+location[7:]+")", location, mutex,
+						reg("ModuleEndpoint("+location[7:]+")", location, guard,
 							endpoint{httpPost, &moduleEndpoint{endpointEnv: env,
 								moduleIndex: moduleIndex, endpointIndex: endpointIndex,
 								pure: true}})
@@ -911,11 +1711,45 @@ func startServer(owner *QuestScreen, events display.Events,
 				moduleIndex++
 			}
 		}
+		pl := &playlist{endpointEnv: env, guard: guard}
+		reg("PlaylistHandler", "/state/playlist", guard,
+			endpoint{httpGet, playlistEndpoint{pl}})
+		for _, action := range []string{"start", "stop", "next"} {
+			reg("PlaylistControlHandler("+action+")", "/state/playlist/"+action,
+				guard, endpoint{httpPost, playlistControlEndpoint{pl, action}})
+		}
+		owner.display.BindInputs(owner.inputBindings(),
+			(&inputHandler{qs: owner, guard: guard}).handle)
+	}
+
+	if owner.tls.Enabled {
+		cert, err := owner.loadCertificate()
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if owner.tls.RedirectPort != 0 {
+			redirect := &http.Server{
+				Addr:    ":" + strconv.Itoa(int(owner.tls.RedirectPort)),
+				Handler: httpsRedirect{port: port}}
+			log.Printf("Redirecting to HTTPS from port %d\n", owner.tls.RedirectPort)
+			go func() {
+				if err := redirect.ListenAndServe(); err != nil {
+					log.Println("[tls] redirect listener failed: " + err.Error())
+				}
+			}()
+		}
 	}
 
 	log.Printf("Listening on port %d\n", port)
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil {
 			if err != http.ErrServerClosed {
 				log.Fatal(err)
 			}
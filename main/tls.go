@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// validity of a generated self-signed certificate.
+const selfSignedValidity = 5 * 365 * 24 * time.Hour
+
+// loadCertificate loads the configured certificate. If none is configured,
+// the self-signed certificate in the data directory is loaded. It is generated
+// if it does not exist yet or has expired.
+func (qs *QuestScreen) loadCertificate() (tls.Certificate, error) {
+	if qs.tls.Cert != "" {
+		return tls.LoadX509KeyPair(qs.tls.Cert, qs.tls.Key)
+	}
+	dir := qs.DataDir("tls")
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil {
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Now().Before(parsed.NotAfter) {
+			return cert, nil
+		}
+		log.Println("[tls] self-signed certificate expired, generating a new one")
+	} else if !os.IsNotExist(err) {
+		log.Printf("[tls] unable to load self-signed certificate: %s\n",
+			err.Error())
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := generateCertificate(certPath, keyPath); err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Println("[tls] generated self-signed certificate " + certPath)
+	return tls.LoadX509KeyPair(certPath, keyPath)
+}
+
+// generateCertificate writes a self-signed certificate valid for localhost,
+// the host name and all current IP addresses of the host.
+func generateCertificate(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"QuestScreen"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:              []string{"localhost"},
+		BasicConstraintsValid: true,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		&key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(
+		&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certPath, pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// httpsRedirect redirects all requests to the HTTPS server on the given port.
+type httpsRedirect struct {
+	port uint16
+}
+
+func (hr httpsRedirect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	target := url.URL{Scheme: "https",
+		Host: net.JoinHostPort(host, strconv.Itoa(int(hr.port))),
+		Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	// not permanent since HTTPS may be disabled later.
+	http.Redirect(w, r, target.String(), http.StatusTemporaryRedirect)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// time to wait after a change before rescanning, so that copying multiple
+// files only causes a single rescan.
+const rescanDelay = time.Second
+
+// interval in which the file system is checked for changes if notifications
+// are not available.
+const pollInterval = 3 * time.Second
+
+// notifyWatcher watches directories via file system notifications.
+type notifyWatcher interface {
+	// run calls rescan whenever something changed. If rescan returns false,
+	// it is retried later.
+	run(rescan func() bool)
+}
+
+// ignoredFile checks whether a change to the file with the given name can be
+// ignored. Hidden files are temporary files created while persisting data or
+// uploading resources. YAML files and their backups hold persisted data, which
+// is written by QuestScreen itself.
+func ignoredFile(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".yaml") ||
+		strings.HasSuffix(name, ".bak")
+}
+
+// watchedDirs returns the directories that may contain resources or textures.
+func (qs *QuestScreen) watchedDirs() []string {
+	return []string{qs.DataDir("base"), qs.DataDir("groups"),
+		qs.DataDir("systems"), qs.DataDir("textures")}
+}
+
+// watchResources starts watching the data directory for changed resources.
+// It calls rescan whenever files have been changed.
+func (qs *QuestScreen) watchResources(rescan func() bool) {
+	dirs := qs.watchedDirs()
+	w, err := newNotifyWatcher(dirs)
+	if err != nil {
+		log.Println("[watcher] file system notifications unavailable, polling instead: " +
+			err.Error())
+		go pollResources(dirs, rescan)
+		return
+	}
+	go w.run(rescan)
+}
+
+// fingerprint calculates a hash over the names, sizes and modification times
+// of all files in the given directories. Hidden directories and ignored files
+// are skipped.
+func fingerprint(dirs []string) uint64 {
+	h := fnv.New64a()
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if path != dir && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+			} else if ignoredFile(info.Name()) {
+				return nil
+			}
+			io.WriteString(h, path)
+			io.WriteString(h, strconv.FormatInt(info.Size(), 10))
+			io.WriteString(h, strconv.FormatInt(info.ModTime().UnixNano(), 10))
+			return nil
+		})
+	}
+	return h.Sum64()
+}
+
+// pollResources periodically checks the given directories for changes and
+// calls rescan when something changed.
+func pollResources(dirs []string, rescan func() bool) {
+	last := fingerprint(dirs)
+	for range time.Tick(pollInterval) {
+		cur := fingerprint(dirs)
+		if cur != last && rescan() {
+			last = cur
+		}
+	}
+}
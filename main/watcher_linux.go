@@ -0,0 +1,127 @@
+// +build linux
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotifyWatcher watches directories via inotify. Since inotify is not
+// recursive, every subdirectory is watched separately.
+type inotifyWatcher struct {
+	fd   int
+	dirs []string
+}
+
+func newNotifyWatcher(dirs []string) (notifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{fd: fd, dirs: dirs}
+	if err := w.addWatches(); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return w, nil
+}
+
+// addWatches adds watches for all directories below the watched ones.
+// Adding a watch for a directory that is already being watched is a no-op,
+// so this is called before each rescan to watch newly created directories.
+func (w *inotifyWatcher) addWatches() error {
+	for _, dir := range w.dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			_, err = syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relevant checks whether the given buffer of inotify events contains an event
+// for a file that is not ignored.
+func relevant(buf []byte) bool {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		offset = nameStart + int(event.Len)
+		if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			return true
+		}
+		name := strings.TrimRight(string(buf[nameStart:offset]), "\x00")
+		if name != "" && !ignoredFile(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// rescanOnEvents calls rescan once no events have been received for
+// rescanDelay. before is called before each rescan.
+func rescanOnEvents(events <-chan struct{}, before func(),
+	rescan func() bool) {
+	var timeout <-chan time.Time
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+			timeout = time.After(rescanDelay)
+		case <-timeout:
+			timeout = nil
+			before()
+			if !rescan() {
+				timeout = time.After(rescanDelay)
+			}
+		}
+	}
+}
+
+func (w *inotifyWatcher) run(rescan func() bool) {
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(w.fd, buf)
+			if err != nil {
+				if err == syscall.EINTR {
+					continue
+				}
+				log.Println("[watcher] stopped watching: " + err.Error())
+				return
+			}
+			if relevant(buf[:n]) {
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	rescanOnEvents(events, func() {
+		if err := w.addWatches(); err != nil {
+			log.Println("[watcher] unable to watch new directories: " + err.Error())
+		}
+	}, rescan)
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package main
+
+import "errors"
+
+func newNotifyWatcher(dirs []string) (notifyWatcher, error) {
+	return nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,216 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// DNS resource record types used by the responder.
+const (
+	typeA    uint16 = 1
+	typePTR  uint16 = 12
+	typeTXT  uint16 = 16
+	typeAAAA uint16 = 28
+	typeSRV  uint16 = 33
+	typeANY  uint16 = 255
+)
+
+const (
+	classIN uint16 = 1
+	// set in a question's class if a unicast response is requested.
+	classUnicastResponse uint16 = 1 << 15
+	// set in a record's class if the record is unique to this host.
+	classCacheFlush uint16 = 1 << 15
+)
+
+const (
+	flagResponse      uint16 = 1 << 15
+	flagAuthoritative uint16 = 1 << 10
+	opcodeMask        uint16 = 0xF << 11
+)
+
+const headerLen = 12
+
+var errMalformed = errors.New("malformed DNS message")
+
+type question struct {
+	name            string
+	qtype           uint16
+	unicastResponse bool
+}
+
+type query struct {
+	id        uint16
+	questions []question
+}
+
+// parseQuery parses the header and questions of a DNS message. Returns an
+// error if the message is malformed or not a standard query.
+func parseQuery(msg []byte) (query, error) {
+	if len(msg) < headerLen {
+		return query{}, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&(flagResponse|opcodeMask) != 0 {
+		return query{}, errors.New("not a standard query")
+	}
+	ret := query{id: binary.BigEndian.Uint16(msg)}
+	count := int(binary.BigEndian.Uint16(msg[4:]))
+	off := headerLen
+	for i := 0; i < count; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return query{}, err
+		}
+		if next+4 > len(msg) {
+			return query{}, errMalformed
+		}
+		class := binary.BigEndian.Uint16(msg[next+2:])
+		ret.questions = append(ret.questions, question{name: name,
+			qtype:           binary.BigEndian.Uint16(msg[next:]),
+			unicastResponse: class&classUnicastResponse != 0})
+		off = next + 4
+	}
+	return ret, nil
+}
+
+// readName reads a possibly compressed domain name starting at off. The name
+// is returned in lower case with a trailing dot. Also returns the offset
+// after the name.
+func readName(msg []byte, off int) (string, int, error) {
+	var sb strings.Builder
+	next := -1
+	// every pointer must point backwards, so this bounds the iterations.
+	for jumps := 0; jumps < len(msg); {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if next == -1 {
+				next = off + 1
+			}
+			if sb.Len() == 0 {
+				sb.WriteByte('.')
+			}
+			return strings.ToLower(sb.String()), next, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errMalformed
+			}
+			if next == -1 {
+				next = off + 2
+			}
+			target := int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			if target >= off {
+				return "", 0, errMalformed
+			}
+			off = target
+			jumps++
+		case length&0xC0 != 0:
+			return "", 0, errMalformed
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errMalformed
+			}
+			sb.Write(msg[off+1 : off+1+length])
+			sb.WriteByte('.')
+			off += 1 + length
+		}
+	}
+	return "", 0, errMalformed
+}
+
+// record is a DNS resource record.
+type record struct {
+	name   string
+	rtype  uint16
+	unique bool
+	ttl    uint32
+	data   []byte
+}
+
+func appendName(buf []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return append(buf, 0)
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (r record) appendTo(buf []byte) []byte {
+	buf = appendName(buf, r.name)
+	buf = appendUint16(buf, r.rtype)
+	class := classIN
+	if r.unique {
+		class |= classCacheFlush
+	}
+	buf = appendUint16(buf, class)
+	buf = appendUint32(buf, r.ttl)
+	buf = appendUint16(buf, uint16(len(r.data)))
+	return append(buf, r.data...)
+}
+
+// buildResponse builds a response message. questions are echoed back, which
+// is required for legacy unicast responses.
+func buildResponse(id uint16, questions []question,
+	answers, additionals []record) []byte {
+	buf := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(buf, id)
+	binary.BigEndian.PutUint16(buf[2:], flagResponse|flagAuthoritative)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(questions)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(buf[10:], uint16(len(additionals)))
+	for _, q := range questions {
+		buf = appendName(buf, q.name)
+		buf = appendUint16(buf, q.qtype)
+		buf = appendUint16(buf, classIN)
+	}
+	for _, r := range answers {
+		buf = r.appendTo(buf)
+	}
+	for _, r := range additionals {
+		buf = r.appendTo(buf)
+	}
+	return buf
+}
+
+func ptrData(target string) []byte {
+	return appendName(nil, target)
+}
+
+func srvData(port uint16, target string) []byte {
+	// priority and weight are 0.
+	buf := []byte{0, 0, 0, 0}
+	buf = appendUint16(buf, port)
+	return appendName(buf, target)
+}
+
+func txtData(entries []string) []byte {
+	var buf []byte
+	for _, e := range entries {
+		if len(e) > 255 {
+			e = e[:255]
+		}
+		buf = append(buf, byte(len(e)))
+		buf = append(buf, e...)
+	}
+	if buf == nil {
+		// a TXT record must contain at least one string.
+		buf = []byte{0}
+	}
+	return buf
+}
@@ -0,0 +1,318 @@
+// Package mdns implements a minimal multicast DNS responder (RFC 6762) that
+// advertises a single service via DNS-SD (RFC 6763).
+//
+// The responder does not probe for name conflicts; it assumes the configured
+// host and instance names are unique on the local network.
+package mdns
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TTLs of advertised records as recommended by RFC 6762, section 10.
+const (
+	hostTTL    = 120
+	serviceTTL = 4500
+)
+
+const maxPacketSize = 9000
+
+// DefaultGroup is the IPv4 mDNS multicast group.
+var DefaultGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service describes the advertised service.
+type Service struct {
+	// Instance is the user-visible name of the service instance.
+	// Must not contain dots.
+	Instance string
+	// Type is the service type, e.g. "_http._tcp".
+	Type string
+	// Host is the host name without the ".local" suffix.
+	Host string
+	// Port is the port the service listens on.
+	Port uint16
+	// TXT contains the entries of the TXT record, as key=value pairs.
+	TXT []string
+}
+
+// Responder answers mDNS queries for a Service.
+type Responder struct {
+	mutex   sync.Mutex
+	service Service
+	conn    net.PacketConn
+	group   net.Addr
+	// returns the addresses the host is reachable on.
+	addrs func() []net.IP
+	done  chan struct{}
+}
+
+// NewResponder creates a responder for the given service that communicates
+// via conn. Multicast responses are sent to group. conn can be any
+// PacketConn, which allows replacing the multicast network with a local
+// stand-in.
+func NewResponder(service Service, conn net.PacketConn,
+	group net.Addr) *Responder {
+	return &Responder{service: service, conn: conn, group: group,
+		addrs: interfaceAddrs, done: make(chan struct{})}
+}
+
+// Listen creates a responder for the given service that joins the IPv4 mDNS
+// multicast group on the system's default multicast interface. Hosts with
+// multiple networks only answer queries arriving on that interface.
+func Listen(service Service) (*Responder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, DefaultGroup)
+	if err != nil {
+		return nil, err
+	}
+	return NewResponder(service, conn, DefaultGroup), nil
+}
+
+// HostName returns the fully qualified host name of the service, without
+// trailing dot.
+func (r *Responder) HostName() string {
+	return r.service.Host + ".local"
+}
+
+// Serve announces the service and answers queries until Close is called.
+func (r *Responder) Serve() error {
+	go func() {
+		// RFC 6762 requires at least two announcements one second apart.
+		r.announce(false)
+		select {
+		case <-time.After(time.Second):
+			r.announce(false)
+		case <-r.done:
+		}
+	}()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-r.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		r.handle(buf[:n], src)
+	}
+}
+
+// SetTXT replaces the entries of the TXT record and announces the change.
+func (r *Responder) SetTXT(txt []string) {
+	r.mutex.Lock()
+	r.service.TXT = txt
+	r.mutex.Unlock()
+	r.announce(false)
+}
+
+// Close announces that the service is going away and stops the responder.
+func (r *Responder) Close() error {
+	r.announce(true)
+	close(r.done)
+	return r.conn.Close()
+}
+
+// announce sends all records unsolicited. If goodbye is true, the records are
+// sent with a TTL of 0, which tells receivers to remove them.
+func (r *Responder) announce(goodbye bool) {
+	r.mutex.Lock()
+	answers := append([]record{r.servicePTR(), r.srv(), r.txt()},
+		r.addressRecords()...)
+	r.mutex.Unlock()
+	for i := range answers {
+		if goodbye {
+			answers[i].ttl = 0
+		}
+	}
+	r.send(buildResponse(0, nil, answers, nil), r.group)
+}
+
+func (r *Responder) send(msg []byte, dst net.Addr) {
+	if _, err := r.conn.WriteTo(msg, dst); err != nil {
+		log.Println("[mdns] while sending response: " + err.Error())
+	}
+}
+
+func (r *Responder) serviceName() string {
+	return strings.ToLower(r.service.Type) + ".local."
+}
+
+func (r *Responder) instanceName() string {
+	return r.service.Instance + "." + r.serviceName()
+}
+
+func (r *Responder) hostName() string {
+	return strings.ToLower(r.service.Host) + ".local."
+}
+
+func (r *Responder) servicePTR() record {
+	return record{name: r.serviceName(), rtype: typePTR, ttl: serviceTTL,
+		data: ptrData(r.instanceName())}
+}
+
+func (r *Responder) srv() record {
+	return record{name: r.instanceName(), rtype: typeSRV, unique: true,
+		ttl: hostTTL, data: srvData(r.service.Port, r.hostName())}
+}
+
+func (r *Responder) txt() record {
+	return record{name: r.instanceName(), rtype: typeTXT, unique: true,
+		ttl: serviceTTL, data: txtData(r.service.TXT)}
+}
+
+func (r *Responder) addressRecords() []record {
+	var ret []record
+	for _, ip := range r.addrs() {
+		if ip4 := ip.To4(); ip4 != nil {
+			ret = append(ret, record{name: r.hostName(), rtype: typeA,
+				unique: true, ttl: hostTTL, data: ip4})
+		} else {
+			ret = append(ret, record{name: r.hostName(), rtype: typeAAAA,
+				unique: true, ttl: hostTTL, data: ip.To16()})
+		}
+	}
+	return ret
+}
+
+func (r *Responder) filterAddresses(rtype uint16) []record {
+	var ret []record
+	for _, rec := range r.addressRecords() {
+		if rtype == typeANY || rec.rtype == rtype {
+			ret = append(ret, rec)
+		}
+	}
+	return ret
+}
+
+// answer returns the answers and additional records for a question.
+func (r *Responder) answer(q question) (answers, additionals []record) {
+	switch q.name {
+	case "_services._dns-sd._udp.local.":
+		if q.qtype == typePTR || q.qtype == typeANY {
+			answers = []record{{name: q.name, rtype: typePTR, ttl: serviceTTL,
+				data: ptrData(r.serviceName())}}
+		}
+	case r.serviceName():
+		if q.qtype == typePTR || q.qtype == typeANY {
+			answers = []record{r.servicePTR()}
+			additionals = append([]record{r.srv(), r.txt()},
+				r.addressRecords()...)
+		}
+	case strings.ToLower(r.instanceName()):
+		switch q.qtype {
+		case typeSRV:
+			answers = []record{r.srv()}
+			additionals = r.addressRecords()
+		case typeTXT:
+			answers = []record{r.txt()}
+		case typeANY:
+			answers = []record{r.srv(), r.txt()}
+			additionals = r.addressRecords()
+		}
+	case r.hostName():
+		switch q.qtype {
+		case typeA, typeAAAA, typeANY:
+			answers = r.filterAddresses(q.qtype)
+		}
+	}
+	return
+}
+
+func (r *Responder) handle(msg []byte, src net.Addr) {
+	q, err := parseQuery(msg)
+	if err != nil {
+		return
+	}
+	var answers, additionals []record
+	var answered []question
+	unicast := false
+	r.mutex.Lock()
+	for _, question := range q.questions {
+		an, ad := r.answer(question)
+		if len(an) == 0 {
+			continue
+		}
+		answers = append(answers, an...)
+		additionals = append(additionals, ad...)
+		answered = append(answered, question)
+		unicast = unicast || question.unicastResponse
+	}
+	r.mutex.Unlock()
+	if len(answers) == 0 {
+		return
+	}
+	additionals = withoutDuplicates(additionals, answers)
+
+	if udp, ok := src.(*net.UDPAddr); ok && udp.Port != DefaultGroup.Port {
+		// legacy unicast query (RFC 6762, section 6.7): reply directly with
+		// the query's ID and questions and short TTLs.
+		for i := range answers {
+			answers[i].ttl = 10
+			answers[i].unique = false
+		}
+		for i := range additionals {
+			additionals[i].ttl = 10
+			additionals[i].unique = false
+		}
+		r.send(buildResponse(q.id, answered, answers, additionals), src)
+	} else if unicast {
+		r.send(buildResponse(0, nil, answers, additionals), src)
+	} else {
+		r.send(buildResponse(0, nil, answers, additionals), r.group)
+	}
+}
+
+// withoutDuplicates returns the records in list that are neither in exclude
+// nor occur earlier in list.
+func withoutDuplicates(list, exclude []record) []record {
+	var ret []record
+	seen := func(rec record, in []record) bool {
+		for _, other := range in {
+			if other.name == rec.name && other.rtype == rec.rtype &&
+				string(other.data) == string(rec.data) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rec := range list {
+		if !seen(rec, exclude) && !seen(rec, ret) {
+			ret = append(ret, rec)
+		}
+	}
+	return ret
+}
+
+// interfaceAddrs returns the unicast addresses of all interfaces that are up
+// and support multicast.
+func interfaceAddrs() []net.IP {
+	var ret []net.IP
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Println("[mdns] while listing interfaces: " + err.Error())
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 ||
+			iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok &&
+				(ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsLinkLocalUnicast()) {
+				ret = append(ret, ipNet.IP)
+			}
+		}
+	}
+	return ret
+}
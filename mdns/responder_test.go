@@ -0,0 +1,207 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type packet struct {
+	data []byte
+	addr net.Addr
+}
+
+// testConn is a PacketConn that replaces the multicast network. Packets sent
+// to in are read by the responder, packets written by the responder are sent
+// to out.
+type testConn struct {
+	in, out chan packet
+	closed  chan struct{}
+}
+
+func newTestConn() *testConn {
+	return &testConn{in: make(chan packet), out: make(chan packet, 16),
+		closed: make(chan struct{})}
+}
+
+func (c *testConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case pkt := <-c.in:
+		return copy(p, pkt.data), pkt.addr, nil
+	case <-c.closed:
+		return 0, nil, errors.New("connection closed")
+	}
+}
+
+func (c *testConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.out <- packet{data: append([]byte(nil), p...), addr: addr}
+	return len(p), nil
+}
+
+func (c *testConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *testConn) LocalAddr() net.Addr                { return DefaultGroup }
+func (c *testConn) SetDeadline(t time.Time) error      { return nil }
+func (c *testConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *testConn) SetWriteDeadline(t time.Time) error { return nil }
+
+var testService = Service{Instance: "QuestScreen", Type: "_http._tcp",
+	Host: "questscreen", Port: 8080, TXT: []string{"path=/"}}
+
+var testIP = net.IPv4(192, 168, 1, 10)
+
+func newTestResponder() (*Responder, *testConn) {
+	conn := newTestConn()
+	r := NewResponder(testService, conn, DefaultGroup)
+	r.addrs = func() []net.IP { return []net.IP{testIP} }
+	return r, conn
+}
+
+func buildQuery(id uint16, name string, qtype uint16, unicast bool) []byte {
+	buf := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(buf, id)
+	binary.BigEndian.PutUint16(buf[4:], 1)
+	buf = appendName(buf, name)
+	buf = appendUint16(buf, qtype)
+	class := classIN
+	if unicast {
+		class |= classUnicastResponse
+	}
+	return appendUint16(buf, class)
+}
+
+type response struct {
+	id                   uint16
+	questions            int
+	answers, additionals []record
+}
+
+func parseResponse(t *testing.T, msg []byte) response {
+	t.Helper()
+	if len(msg) < headerLen {
+		t.Fatal("response too short")
+	}
+	if binary.BigEndian.Uint16(msg[2:])&flagResponse == 0 {
+		t.Fatal("response flag not set")
+	}
+	ret := response{id: binary.BigEndian.Uint16(msg),
+		questions: int(binary.BigEndian.Uint16(msg[4:]))}
+	off := headerLen
+	for i := 0; i < ret.questions; i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		off = next + 4
+	}
+	readRecords := func(count int) []record {
+		var records []record
+		for i := 0; i < count; i++ {
+			name, next, err := readName(msg, off)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next+10 > len(msg) {
+				t.Fatal("record truncated")
+			}
+			rec := record{name: name, rtype: binary.BigEndian.Uint16(msg[next:]),
+				unique: binary.BigEndian.Uint16(msg[next+2:])&classCacheFlush != 0,
+				ttl:    binary.BigEndian.Uint32(msg[next+4:])}
+			length := int(binary.BigEndian.Uint16(msg[next+8:]))
+			off = next + 10 + length
+			if off > len(msg) {
+				t.Fatal("record data truncated")
+			}
+			rec.data = msg[next+10 : off]
+			records = append(records, rec)
+		}
+		return records
+	}
+	ret.answers = readRecords(int(binary.BigEndian.Uint16(msg[6:])))
+	ret.additionals = readRecords(int(binary.BigEndian.Uint16(msg[10:])))
+	return ret
+}
+
+// receive returns the next packet written by the responder to dst, skipping
+// announcements sent to other addresses.
+func receive(t *testing.T, conn *testConn, dst net.Addr) packet {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case pkt := <-conn.out:
+			if pkt.addr.String() == dst.String() {
+				return pkt
+			}
+		case <-timeout:
+			t.Fatal("no response to " + dst.String())
+		}
+	}
+}
+
+func TestLegacyUnicastQuery(t *testing.T) {
+	r, conn := newTestResponder()
+	go r.Serve()
+	defer r.Close()
+
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 40000}
+	conn.in <- packet{data: buildQuery(0x1234, "_http._tcp.local.", typePTR,
+		false), addr: src}
+	resp := parseResponse(t, receive(t, conn, src).data)
+	if resp.id != 0x1234 || resp.questions != 1 {
+		t.Errorf("expected query ID and question to be echoed, got id %x and "+
+			"%d questions", resp.id, resp.questions)
+	}
+	if len(resp.answers) != 1 || resp.answers[0].rtype != typePTR ||
+		string(resp.answers[0].data) !=
+			string(ptrData("QuestScreen._http._tcp.local.")) {
+		t.Fatalf("expected PTR answer to the instance, got %v", resp.answers)
+	}
+	if resp.answers[0].ttl != 10 {
+		t.Errorf("expected TTL 10 for legacy unicast, got %d",
+			resp.answers[0].ttl)
+	}
+	types := make(map[uint16]bool)
+	for _, rec := range resp.additionals {
+		types[rec.rtype] = true
+	}
+	if !types[typeSRV] || !types[typeTXT] || !types[typeA] {
+		t.Errorf("expected SRV, TXT and A as additionals, got %v",
+			resp.additionals)
+	}
+}
+
+func TestMulticastQuery(t *testing.T) {
+	r, conn := newTestResponder()
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: DefaultGroup.Port}
+	r.handle(buildQuery(0, "QuestScreen.local.", typeA, false), src)
+	resp := parseResponse(t, receive(t, conn, DefaultGroup).data)
+	if len(resp.answers) != 1 || resp.answers[0].rtype != typeA ||
+		!net.IP(resp.answers[0].data).Equal(testIP) {
+		t.Fatalf("expected A record with %v, got %v", testIP, resp.answers)
+	}
+	if !resp.answers[0].unique || resp.answers[0].ttl != hostTTL {
+		t.Errorf("expected unique A record with TTL %d", hostTTL)
+	}
+
+	// the unicast-response bit requests a reply directly to the querier.
+	r.handle(buildQuery(0, "questscreen.local.", typeA, true), src)
+	receive(t, conn, src)
+}
+
+func TestUnknownNameIsIgnored(t *testing.T) {
+	r, conn := newTestResponder()
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: DefaultGroup.Port}
+	r.handle(buildQuery(0, "other.local.", typeA, false), src)
+	select {
+	case pkt := <-conn.out:
+		t.Errorf("expected no response, got %d bytes to %v", len(pkt.data),
+			pkt.addr)
+	default:
+	}
+}
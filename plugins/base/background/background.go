@@ -4,6 +4,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/QuestScreen/QuestScreen/plugins/base/shared"
 	"github.com/QuestScreen/api"
 	"github.com/QuestScreen/api/modules"
 	"github.com/QuestScreen/api/render"
@@ -11,7 +12,9 @@ import (
 	"github.com/QuestScreen/api/server"
 )
 
-type backgroundConfig struct{}
+type backgroundConfig struct {
+	Transition *shared.TransitionSelect `yaml:"transition"`
+}
 
 type request struct {
 	file resources.Resource
@@ -23,6 +26,8 @@ type Background struct {
 	curTexture, newTexture render.Image
 	curFile                resources.Resource
 	alphaMod               uint8
+	// transition settings of the running transition.
+	transition shared.Transition
 }
 
 func newRenderer(backend render.Renderer,
@@ -37,8 +42,9 @@ var Descriptor = modules.Module{
 	ID:   "background",
 	ResourceCollections: []resources.Selector{
 		{Subdirectory: "", Suffixes: nil}},
-	EndpointPaths:  []string{""},
-	DefaultConfig:  &backgroundConfig{},
+	EndpointPaths: []string{""},
+	DefaultConfig: &backgroundConfig{Transition: shared.NewTransitionSelect(
+		time.Second, shared.LinearEasing)},
 	CreateRenderer: newRenderer,
 	CreateState:    newState,
 }
@@ -81,7 +87,8 @@ func (bg *Background) InitTransition(r render.Renderer, data interface{}) time.D
 		if bg.curFile.Location != nil {
 			bg.newTexture = bg.genTexture(r, bg.curFile)
 		}
-		ret = time.Second
+		bg.transition = bg.config.Transition.Transition
+		ret = bg.transition.Length()
 	}
 	return ret
 }
@@ -89,7 +96,8 @@ func (bg *Background) InitTransition(r render.Renderer, data interface{}) time.D
 // TransitionStep advances the transition.
 func (bg *Background) TransitionStep(r render.Renderer, elapsed time.Duration) {
 	if !bg.newTexture.IsEmpty() {
-		bg.alphaMod = uint8((elapsed * 255) / time.Second)
+		bg.alphaMod = uint8(bg.transition.Progress(
+			elapsed, bg.transition.Length()) * 255)
 	}
 }
 
@@ -66,6 +66,25 @@ func (s *state) Persist(env server.Context) interface{} {
 	return s.resources[s.curIndex].Name
 }
 
+// ResourcesChanged queries the list of resources again. The current selection
+// is kept if the selected file still exists.
+func (s *state) ResourcesChanged(ctx server.Context) {
+	var selected string
+	if s.curIndex != -1 {
+		selected = s.resources[s.curIndex].Name
+	}
+	s.resources = ctx.GetResources(0)
+	s.curIndex = -1
+	if selected != "" {
+		for i := range s.resources {
+			if s.resources[i].Name == selected {
+				s.curIndex = i
+				break
+			}
+		}
+	}
+}
+
 func (s *state) PureEndpoint(index int) modules.PureEndpoint {
 	if index != 0 {
 		panic("Endpoint index out of bounds")
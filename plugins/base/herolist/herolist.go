@@ -1,8 +1,11 @@
 package herolist
 
 import (
+	"log"
+	"net/url"
 	"time"
 
+	"github.com/QuestScreen/QuestScreen/plugins/base/shared"
 	"github.com/QuestScreen/api"
 	"github.com/QuestScreen/api/config"
 	"github.com/QuestScreen/api/modules"
@@ -13,6 +16,10 @@ import (
 type heroData struct {
 	name, desc string
 	visible    bool
+	// nil if the hero has no portrait.
+	portrait *url.URL
+	// values of the hero's attributes, formatted as single line.
+	attrs string
 }
 
 type displayedHero struct {
@@ -34,6 +41,7 @@ type mConfig struct {
 	NameFont   *config.FontSelect       `yaml:"nameFont"`
 	DescrFont  *config.FontSelect       `yaml:"descrFont"`
 	Background *config.BackgroundSelect `yaml:"background"`
+	Transition *shared.TransitionSelect `yaml:"transition"`
 }
 
 type heroRequest struct {
@@ -51,25 +59,39 @@ type fullRequest struct {
 }
 
 // HeroList is a module for displaying a list of heroes.
+//
+// On landscape outputs, the hero boxes are stacked downwards along the west
+// edge. On portrait outputs, they form a strip along the south edge instead.
 type HeroList struct {
-	config                      *mConfig
-	heroes                      []displayedHero
-	curGlobalVisible            bool
-	curHero                     int32
-	curXOffset, curYOffset      int32
+	config           *mConfig
+	heroes           []displayedHero
+	curGlobalVisible bool
+	curHero          int32
+	// offset of a box sliding in or out of the edge it is docked to.
+	curSlideOffset int32
+	// space opened in the stack of boxes for a box that is shown or hidden.
+	curStackOffset              int32
 	contentWidth, contentHeight int32
+	portrait                    bool
 	status                      heroStatus
 	alphaMod                    uint8
+	// transition settings of the running transition.
+	transition shared.Transition
+	// height of the content if the rendered lines do not require more space.
+	minContentHeight int32
 }
 
-const duration = time.Second / 2
-
 func newRenderer(r render.Renderer,
 	ms server.MessageSender) (modules.Renderer, error) {
 	frame := r.OutputSize()
-	return &HeroList{curGlobalVisible: false, curXOffset: 0,
-		curYOffset: 0, contentWidth: frame.Width / 4,
-		contentHeight: frame.Height / 10, status: resting}, nil
+	if frame.Height > frame.Width {
+		return &HeroList{curGlobalVisible: false, contentWidth: frame.Width / 5,
+			contentHeight: frame.Height / 16, minContentHeight: frame.Height / 16,
+			portrait: true, status: resting}, nil
+	}
+	return &HeroList{curGlobalVisible: false, contentWidth: frame.Width / 4,
+		contentHeight: frame.Height / 10, minContentHeight: frame.Height / 10,
+		status: resting}, nil
 }
 
 // Descriptor describes the HeroList module.
@@ -83,7 +105,9 @@ var Descriptor = modules.Module{
 		DescrFont: config.NewFontSelect(0, api.ContentFont, api.RegularFont,
 			api.RGBA{R: 0, G: 0, B: 0, A: 255}),
 		Background: config.NewBackgroundSelect(
-			api.RGBA{R: 255, G: 255, B: 255, A: 255}.AsBackground())},
+			api.RGBA{R: 255, G: 255, B: 255, A: 255}.AsBackground()),
+		Transition: shared.NewTransitionSelect(time.Second/2,
+			shared.CubicEasing)},
 	CreateRenderer: newRenderer, CreateState: newState,
 }
 
@@ -95,29 +119,109 @@ func (l *HeroList) boxHeight(borderWidth int32) int32 {
 	return l.contentHeight + 4*borderWidth
 }
 
-func (l *HeroList) buildHeroBox(r render.Renderer, h heroData) render.Image {
+// slideLength returns the distance a box moves when it slides in or out of the
+// edge it is docked to.
+func (l *HeroList) slideLength(borderWidth int32) int32 {
+	if l.portrait {
+		return l.boxHeight(borderWidth)
+	}
+	return l.boxWidth(borderWidth)
+}
+
+// stackLength returns the length of a box in the direction the boxes are
+// stacked in.
+func (l *HeroList) stackLength(borderWidth int32) int32 {
+	if l.portrait {
+		return l.boxWidth(borderWidth)
+	}
+	return l.boxHeight(borderWidth)
+}
+
+// stackSpace returns the space a box that is being shown or hidden opens in
+// the stack.
+func (l *HeroList) stackSpace(borderWidth int32) int32 {
+	if l.portrait {
+		return l.boxWidth(borderWidth) + l.contentWidth/4
+	}
+	return l.boxHeight(borderWidth) + l.contentHeight/4
+}
+
+// heroTexts holds the rendered text lines of a hero box. attrs is empty if the
+// hero has no attribute values.
+type heroTexts struct {
+	name, attrs, descr render.Image
+}
+
+func (l *HeroList) renderTexts(r render.Renderer, h heroData) heroTexts {
+	ret := heroTexts{name: r.RenderText(h.name, l.config.NameFont.Font),
+		descr: r.RenderText(h.desc, l.config.DescrFont.Font)}
+	if h.attrs != "" {
+		ret.attrs = r.RenderText(h.attrs, l.config.DescrFont.Font)
+	}
+	return ret
+}
+
+// height returns the height required to show all lines.
+func (t *heroTexts) height() int32 {
+	return t.name.Height + t.attrs.Height + t.descr.Height
+}
+
+func (t *heroTexts) free(r render.Renderer) {
+	r.FreeImage(&t.name)
+	r.FreeImage(&t.attrs)
+	r.FreeImage(&t.descr)
+}
+
+func (l *HeroList) buildHeroBox(r render.Renderer, h heroData,
+	t *heroTexts) render.Image {
 	unit := r.Unit()
 	// boxWidth/boxHeight are with borders, CreateCanvas takes inner width/height
-	// so we substract the borders.
-	canvas, frame := r.CreateCanvas(l.boxWidth(unit)-unit,
-		l.boxHeight(unit)-2*unit, l.config.Background.Background,
-		render.North|render.East|render.South)
+	// so we substract the borders. There is no border at the docked edge.
+	var canvas render.Canvas
+	var frame render.Rectangle
+	if l.portrait {
+		canvas, frame = r.CreateCanvas(l.boxWidth(unit)-2*unit,
+			l.boxHeight(unit)-unit, l.config.Background.Background,
+			render.North|render.East|render.West)
+	} else {
+		canvas, frame = r.CreateCanvas(l.boxWidth(unit)-unit,
+			l.boxHeight(unit)-2*unit, l.config.Background.Background,
+			render.North|render.East|render.South)
+	}
 	// by positioning the content in the center, we'll have 2 units margin at
 	// each side (1 unit of those 2 is border, where applicable)
 	frame = frame.Position(l.contentWidth, l.contentHeight, render.Center,
 		render.Middle)
-	// render the text into textures
-	nameImg := r.RenderText(h.name, l.config.NameFont.Font)
-	defer r.FreeImage(&nameImg)
-	descrImg := r.RenderText(h.desc, l.config.DescrFont.Font)
-	defer r.FreeImage(&descrImg)
-	// print them into the box at top/bottom inside the margin
-	nameFrame := frame.Position(nameImg.Width, nameImg.Height, render.Left,
-		render.Top)
-	nameImg.Draw(r, nameFrame, 255)
-	descrFrame := frame.Position(descrImg.Width, descrImg.Height, render.Left,
-		render.Bottom)
-	descrImg.Draw(r, descrFrame, 255)
+	if h.portrait != nil {
+		img, err := r.LoadImageFile(h.portrait, false)
+		if err != nil {
+			log.Printf("unable to load portrait of %s: %s\n", h.name, err)
+		} else if !img.IsEmpty() {
+			defer r.FreeImage(&img)
+			// the portrait is scaled to the content height but takes at most a
+			// third of the content width. It is drawn at the left, with a margin
+			// of one unit to the text.
+			width, height := img.Width*l.contentHeight/img.Height, l.contentHeight
+			if maxWidth := l.contentWidth / 3; width > maxWidth {
+				width, height = maxWidth, img.Height*maxWidth/img.Width
+			}
+			var portraitFrame render.Rectangle
+			portraitFrame, frame = frame.Carve(render.West, width+unit)
+			img.Draw(r, portraitFrame.Position(width, height,
+				render.Left, render.Middle), 255)
+		}
+	}
+	// print the name at the top, the attributes below it and the description
+	// at the bottom inside the margin
+	nameFrame, rest := frame.Carve(render.North, t.name.Height)
+	t.name.Draw(r, nameFrame.Position(t.name.Width, t.name.Height,
+		render.Left, render.Top), 255)
+	if !t.attrs.IsEmpty() {
+		t.attrs.Draw(r, rest.Position(t.attrs.Width, t.attrs.Height,
+			render.Left, render.Top), 255)
+	}
+	t.descr.Draw(r, frame.Position(t.descr.Width, t.descr.Height,
+		render.Left, render.Bottom), 255)
 	return canvas.Finish()
 }
 
@@ -132,7 +236,8 @@ func (l *HeroList) InitTransition(
 			} else {
 				l.status = hidingAll
 			}
-			return duration
+			l.transition = l.config.Transition.Transition
+			return l.transition.Length()
 		}
 	case *heroRequest:
 		if l.heroes[req.index].visible != req.visible {
@@ -142,7 +247,8 @@ func (l *HeroList) InitTransition(
 				l.status = hidingHero
 			}
 			l.curHero = req.index
-			return duration
+			l.transition = l.config.Transition.Transition
+			return l.transition.Length()
 		}
 	default:
 		panic("HeroList.InitTransition called with unexpected data type")
@@ -152,30 +258,30 @@ func (l *HeroList) InitTransition(
 
 // TransitionStep advances the transition
 func (l *HeroList) TransitionStep(r render.Renderer, elapsed time.Duration) {
-	pos := render.TransitionCurve{Duration: duration}.Cubic(elapsed)
+	pos := l.transition.Progress(elapsed, l.transition.Length())
 	unit := r.Unit()
 	switch l.status {
 	case showingAll:
-		l.curXOffset = int32((1.0 - pos) * float32(l.boxWidth(unit)))
+		l.curSlideOffset = int32((1.0 - pos) * float32(l.slideLength(unit)))
 		l.alphaMod = uint8(pos * 255)
 	case hidingAll:
-		l.curXOffset = int32(pos * float32(l.boxWidth(unit)))
+		l.curSlideOffset = int32(pos * float32(l.slideLength(unit)))
 		l.alphaMod = uint8((1.0 - pos) * 255)
 	case showingHero:
-		l.curXOffset = int32((1.0 - pos) * float32((l.boxWidth(unit))))
-		l.curYOffset = int32(pos * float32(l.boxHeight(unit)+l.contentHeight/4))
+		l.curSlideOffset = int32((1.0 - pos) * float32((l.slideLength(unit))))
+		l.curStackOffset = int32(pos * float32(l.stackSpace(unit)))
 		l.alphaMod = uint8(pos * 255)
 	case hidingHero:
-		l.curXOffset = int32(pos * float32(l.boxWidth(unit)))
-		l.curYOffset = int32((1.0 - pos) * float32(l.boxHeight(unit)+l.contentHeight/4))
+		l.curSlideOffset = int32(pos * float32(l.slideLength(unit)))
+		l.curStackOffset = int32((1.0 - pos) * float32(l.stackSpace(unit)))
 		l.alphaMod = uint8((1.0 - pos) * 255)
 	}
 }
 
 // FinishTransition finalizes the transition
 func (l *HeroList) FinishTransition(r render.Renderer) {
-	l.curXOffset = 0
-	l.curYOffset = 0
+	l.curSlideOffset = 0
+	l.curStackOffset = 0
 	switch l.status {
 	case showingHero, hidingHero:
 		l.heroes[l.curHero].visible = l.status == showingHero
@@ -195,8 +301,24 @@ func (l *HeroList) Render(r render.Renderer) {
 	unit := r.Unit()
 
 	frame := r.OutputSize()
-	_, frame = frame.Carve(render.North, frame.Height/7)
-	frame, _ = frame.Carve(render.West, l.boxWidth(unit))
+	// the edge from which boxes are stacked.
+	stackEdge := render.North
+	if l.portrait {
+		frame, _ = frame.Carve(render.South, l.boxHeight(unit))
+		_, frame = frame.Carve(render.West, unit*4)
+		stackEdge = render.West
+	} else {
+		_, frame = frame.Carve(render.North, frame.Height/7)
+		frame, _ = frame.Carve(render.West, l.boxWidth(unit))
+	}
+	// moves the target rectangle of a box out of the docked edge.
+	slide := func(rect *render.Rectangle) {
+		if l.portrait {
+			rect.Y -= l.curSlideOffset
+		} else {
+			rect.X -= l.curSlideOffset
+		}
+	}
 
 	for i := range l.heroes {
 		if !l.heroes[i].visible && (l.curHero != int32(i) ||
@@ -204,18 +326,18 @@ func (l *HeroList) Render(r render.Renderer) {
 			continue
 		}
 		var targetRect render.Rectangle
-		targetRect, frame = frame.Carve(render.North, l.boxHeight(unit))
+		targetRect, frame = frame.Carve(stackEdge, l.stackLength(unit))
 
 		if l.status == showingAll || l.status == hidingAll {
-			targetRect.X -= l.curXOffset
-			_, frame = frame.Carve(render.North, unit*4)
+			slide(&targetRect)
+			_, frame = frame.Carve(stackEdge, unit*4)
 			l.heroes[i].box.Draw(r, targetRect, l.alphaMod)
 		} else if (l.status == showingHero || l.status == hidingHero) && l.curHero == int32(i) {
-			targetRect.X -= l.curXOffset
-			_, frame = frame.Carve(render.North, l.curYOffset-l.boxHeight(unit))
+			slide(&targetRect)
+			_, frame = frame.Carve(stackEdge, l.curStackOffset-l.stackLength(unit))
 			l.heroes[i].box.Draw(r, targetRect, l.alphaMod)
 		} else {
-			_, frame = frame.Carve(render.North, unit*4)
+			_, frame = frame.Carve(stackEdge, unit*4)
 			l.heroes[i].box.Draw(r, targetRect, 255)
 		}
 	}
@@ -240,8 +362,18 @@ func (l *HeroList) Rebuild(r render.Renderer, data interface{}, configVal interf
 		}
 		l.curGlobalVisible = req.global
 	}
+	// all boxes have the same size, which must fit the lines of each hero.
+	texts := make([]heroTexts, len(l.heroes))
+	l.contentHeight = l.minContentHeight
+	for i := range l.heroes {
+		texts[i] = l.renderTexts(r, l.heroes[i].heroData)
+		if height := texts[i].height(); height > l.contentHeight {
+			l.contentHeight = height
+		}
+	}
 	for i := range l.heroes {
-		l.heroes[i].box = l.buildHeroBox(r, l.heroes[i].heroData)
+		l.heroes[i].box = l.buildHeroBox(r, l.heroes[i].heroData, &texts[i])
+		texts[i].free(r)
 	}
 
 	l.status = resting
@@ -1,6 +1,8 @@
 package herolist
 
 import (
+	"strings"
+
 	"github.com/QuestScreen/QuestScreen/plugins/base/shared"
 	"github.com/QuestScreen/api/comms"
 	"github.com/QuestScreen/api/groups"
@@ -73,6 +75,16 @@ func (s *state) CreateRendererData(ctx server.Context) interface{} {
 		h := hl.Hero(i)
 		states[i] = heroData{name: h.Name(), desc: h.Description(),
 			visible: s.heroVisible[i]}
+		if ext, ok := h.(shared.Hero); ok {
+			states[i].portrait = ext.Portrait()
+			attrs := make([]string, 0, ext.NumAttributes())
+			for j := 0; j < ext.NumAttributes(); j++ {
+				if name, value := ext.Attribute(j); value != "" {
+					attrs = append(attrs, name+" "+value)
+				}
+			}
+			states[i].attrs = strings.Join(attrs, " · ")
+		}
 	}
 	return &fullRequest{heroes: states, global: s.globalVisible}
 }
@@ -4,6 +4,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/QuestScreen/QuestScreen/plugins/base/shared"
 	"github.com/QuestScreen/api"
 	"github.com/QuestScreen/api/modules"
 	"github.com/QuestScreen/api/render"
@@ -11,7 +12,9 @@ import (
 	"github.com/QuestScreen/api/server"
 )
 
-type config struct{}
+type config struct {
+	Transition *shared.TransitionSelect `yaml:"transition"`
+}
 
 type textureData struct {
 	tex           render.Image
@@ -68,10 +71,10 @@ type Overlays struct {
 	// textures)
 	activeBorderWidth int32
 	alphaMod          uint8
+	// transition settings of the running transition.
+	transition shared.Transition
 }
 
-const duration = time.Second
-
 func newRenderer(r render.Renderer,
 	ms server.MessageSender) (modules.Renderer, error) {
 	return &Overlays{status: resting, shownTexWidth: 0, curActive: -1}, nil
@@ -83,8 +86,9 @@ var Descriptor = modules.Module{
 	ID:   "overlays",
 	ResourceCollections: []resources.Selector{
 		{Subdirectory: "", Suffixes: nil}},
-	EndpointPaths:  []string{""},
-	DefaultConfig:  &config{},
+	EndpointPaths: []string{""},
+	DefaultConfig: &config{Transition: shared.NewTransitionSelect(
+		time.Second, shared.CubicEasing)},
 	CreateRenderer: newRenderer, CreateState: newState,
 }
 
@@ -185,12 +189,13 @@ func (o *Overlays) InitTransition(r render.Renderer,
 			return -1
 		}
 	}
-	return duration
+	o.transition = o.config.Transition.Transition
+	return o.transition.Length()
 }
 
 // TransitionStep advances the transition.
 func (o *Overlays) TransitionStep(r render.Renderer, elapsed time.Duration) {
-	pos := render.TransitionCurve{Duration: duration}.Cubic(elapsed)
+	pos := o.transition.Progress(elapsed, o.transition.Length())
 	o.curInactiveScale = o.startScale + pos*(o.targetScale-o.startScale)
 	o.curXOffset = o.startXOffset + int32(pos*float32(o.targetXOffset-o.startXOffset))
 
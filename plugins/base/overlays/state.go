@@ -78,6 +78,22 @@ func (s *state) Persist(ctx server.Context) interface{} {
 	return ret
 }
 
+// ResourcesChanged queries the list of resources again. Resources stay visible
+// if their file still exists.
+func (s *state) ResourcesChanged(ctx server.Context) {
+	visible := make(map[string]struct{})
+	for i := range s.items {
+		if s.visible[i] {
+			visible[s.items[i].Name] = struct{}{}
+		}
+	}
+	s.items = ctx.GetResources(0)
+	s.visible = make([]bool, len(s.items))
+	for i := range s.items {
+		_, s.visible[i] = visible[s.items[i].Name]
+	}
+}
+
 func (s *state) PureEndpoint(index int) modules.PureEndpoint {
 	if index != 0 {
 		panic("Endpoint index out of range")
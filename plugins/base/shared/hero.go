@@ -0,0 +1,24 @@
+package shared
+
+import (
+	"net/url"
+
+	"github.com/QuestScreen/api/groups"
+)
+
+// Hero extends groups.Hero with the portrait and the attributes of a hero.
+// The heroes QuestScreen gives to modules via groups.HeroList implement it, so
+// modules can access the additional data via type assertion.
+type Hero interface {
+	groups.Hero
+	// Portrait returns the location of the hero's portrait image, or nil if
+	// the hero has no portrait.
+	Portrait() *url.URL
+	// NumAttributes returns the number of attributes declared by the system of
+	// the hero's group.
+	NumAttributes() int
+	// Attribute returns the name of the attribute at the given index, which
+	// must be between 0 (included) and NumAttributes() (excluded), and the
+	// hero's value for it. The value is empty if it has not been set.
+	Attribute(index int) (name, value string)
+}
@@ -0,0 +1,129 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/QuestScreen/api/comms"
+	"github.com/QuestScreen/api/server"
+)
+
+// Easing describes how a transition progresses over time.
+type Easing int
+
+const (
+	// LinearEasing progresses at constant speed.
+	LinearEasing Easing = iota
+	// CubicEasing accelerates at the start and decelerates at the end.
+	CubicEasing
+	// EaseIn accelerates at the start and stops abruptly.
+	EaseIn
+	// EaseOut starts abruptly and decelerates at the end.
+	EaseOut
+	// NoEasing skips the transition; changes are shown immediately.
+	NoEasing
+)
+
+var easingNames = [...]string{"linear", "cubic", "ease-in", "ease-out", "none"}
+
+// String returns the name of the easing as used in the YAML files.
+func (e Easing) String() string {
+	if e < LinearEasing || e > NoEasing {
+		return "unknown"
+	}
+	return easingNames[e]
+}
+
+// maximum duration of a transition that may be configured.
+const maxTransitionDuration = time.Minute
+
+// Transition describes the duration and easing curve of a module's
+// transitions.
+type Transition struct {
+	Duration time.Duration `json:"duration"`
+	Easing   Easing        `json:"easing"`
+}
+
+// Length returns the duration of the transition, which is 0 if the transition
+// is skipped.
+func (t Transition) Length() time.Duration {
+	if t.Easing == NoEasing {
+		return 0
+	}
+	return t.Duration
+}
+
+// Scaled returns the given part of the transition's duration, given as fraction
+// of reference. This is used by modules whose transitions consist of multiple
+// phases.
+func (t Transition) Scaled(part, reference time.Duration) time.Duration {
+	return time.Duration(int64(t.Length()) * int64(part) / int64(reference))
+}
+
+// Progress returns the progress of a transition of the given duration after
+// elapsed time, as value between 0.0 and 1.0.
+func (t Transition) Progress(elapsed, duration time.Duration) float32 {
+	if duration <= 0 || elapsed >= duration {
+		return 1
+	}
+	if elapsed <= 0 {
+		return 0
+	}
+	x := float64(elapsed) / float64(duration)
+	switch t.Easing {
+	case LinearEasing:
+		return float32(x)
+	case EaseIn:
+		return float32(math.Pow(x, 3))
+	case EaseOut:
+		return float32(1 - math.Pow(1-x, 3))
+	case NoEasing:
+		return 1
+	default:
+		return float32(-2.0*math.Pow(x, 3) + 3.0*math.Pow(x, 2))
+	}
+}
+
+// TransitionSelect is a config item that allows the user to set the duration
+// and easing of a module's transitions.
+type TransitionSelect struct {
+	Transition
+}
+
+// NewTransitionSelect creates a new TransitionSelect item with the given
+// values.
+func NewTransitionSelect(duration time.Duration,
+	easing Easing) *TransitionSelect {
+	return &TransitionSelect{Transition: Transition{
+		Duration: duration, Easing: easing}}
+}
+
+// Receive loads a transition from a JSON input
+// `{"duration": <nanoseconds>, "easing": <number>}`
+func (t *TransitionSelect) Receive(
+	input json.RawMessage, ctx server.Context) error {
+	// the duration is checked by hand since nanoseconds do not fit into the
+	// int of ValidatedInt on 32-bit platforms.
+	value := struct {
+		Duration int64              `json:"duration"`
+		Easing   comms.ValidatedInt `json:"easing"`
+	}{Easing: comms.ValidatedInt{Min: 0, Max: int(NoEasing)}}
+	if err := comms.ReceiveData(input, &value); err != nil {
+		return err
+	}
+	duration := time.Duration(value.Duration)
+	if duration < 0 || duration > maxTransitionDuration {
+		return fmt.Errorf("transition duration %v outside of range 0..%v",
+			duration, maxTransitionDuration)
+	}
+	t.Transition = Transition{Duration: duration,
+		Easing: Easing(value.Easing.Value)}
+	return nil
+}
+
+// Send returns the object itself.
+func (t *TransitionSelect) Send(ctx server.Context) interface{} {
+	return t.Transition
+}
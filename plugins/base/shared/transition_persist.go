@@ -0,0 +1,42 @@
+// +build !js
+
+package shared
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/QuestScreen/api/server"
+	"gopkg.in/yaml.v3"
+)
+
+type persistedTransition struct {
+	Duration time.Duration `yaml:"duration"`
+	Easing   string        `yaml:"easing"`
+}
+
+// Load loads a transition from a YAML input
+// `{duration: <duration>, easing: <name>}`
+func (t *TransitionSelect) Load(input *yaml.Node, ctx server.Context) error {
+	var value persistedTransition
+	if err := input.Decode(&value); err != nil {
+		return err
+	}
+	if value.Duration < 0 || value.Duration > maxTransitionDuration {
+		return fmt.Errorf("transition duration %v outside of range 0..%v",
+			value.Duration, maxTransitionDuration)
+	}
+	t.Duration = value.Duration
+	for i := range easingNames {
+		if easingNames[i] == value.Easing {
+			t.Easing = Easing(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown easing \"%s\"", value.Easing)
+}
+
+// Persist returns a view that gives the easing as name.
+func (t *TransitionSelect) Persist(ctx server.Context) interface{} {
+	return &persistedTransition{Duration: t.Duration, Easing: t.Easing.String()}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/QuestScreen/QuestScreen/plugins/base/shared"
 	"github.com/QuestScreen/api"
 	"github.com/QuestScreen/api/config"
 	"github.com/QuestScreen/api/modules"
@@ -14,6 +15,7 @@ import (
 type titleConfig struct {
 	Font       *config.FontSelect       `yaml:"font"`
 	Background *config.BackgroundSelect `yaml:"background"`
+	Transition *shared.TransitionSelect `yaml:"transition"`
 }
 
 type changeRequest struct {
@@ -28,11 +30,18 @@ type Title struct {
 	newTitle     render.Image
 	curYOffset   int32
 	swapped      bool
+	// transition settings of the running transition.
+	transition shared.Transition
+	// duration of moving the title out or in, and of the pause between.
+	singleDuration, waitTime time.Duration
 }
 
+// the default durations of the transition's phases. A configured duration is
+// split into the phases in the same ratio.
 const (
-	singleDuration = time.Second / 3
-	waitTime       = time.Millisecond * 100
+	defaultSingleDuration = time.Second / 3
+	defaultWaitTime       = time.Millisecond * 100
+	defaultDuration       = 2*defaultSingleDuration + defaultWaitTime
 )
 
 func newRenderer(
@@ -48,10 +57,22 @@ var Descriptor = modules.Module{
 	DefaultConfig: &titleConfig{Font: config.NewFontSelect(0, api.HeadingFont,
 		api.BoldFont, api.RGBA{R: 0, G: 0, B: 0, A: 255}),
 		Background: config.NewBackgroundSelect(
-			api.RGBA{R: 255, G: 255, B: 255, A: 255}.AsBackground())},
+			api.RGBA{R: 255, G: 255, B: 255, A: 255}.AsBackground()),
+		Transition: shared.NewTransitionSelect(defaultDuration,
+			shared.CubicEasing)},
 	CreateRenderer: newRenderer, CreateState: newState,
 }
 
+// maxTitleWidth returns the width the title is scaled down to if it is wider.
+// Portrait outputs have little horizontal space, so the title may span almost
+// their whole width there.
+func maxTitleWidth(window render.Rectangle) int32 {
+	if window.Height > window.Width {
+		return window.Width * 9 / 10
+	}
+	return window.Width * 2 / 3
+}
+
 func (t *Title) genTitleTexture(r render.Renderer, text string) render.Image {
 	tex := r.RenderText(text, t.Font.Font)
 	if tex.IsEmpty() {
@@ -60,12 +81,12 @@ func (t *Title) genTitleTexture(r render.Renderer, text string) render.Image {
 	}
 	defer r.FreeImage(&tex)
 
-	window := r.OutputSize()
+	maxWidth := maxTitleWidth(r.OutputSize())
 	resWidth, resHeight := tex.Width, tex.Height
-	if resWidth > window.Width*2/3 {
-		scaleFactor := float32(window.Width*2/3) / float32(resWidth)
+	if resWidth > maxWidth {
+		scaleFactor := float32(maxWidth) / float32(resWidth)
 		resHeight = int32(float32(resHeight) * scaleFactor)
-		resWidth = window.Width * 2 / 3
+		resWidth = maxWidth
 	}
 	unit := r.Unit()
 	canvas, inner := r.CreateCanvas(resWidth+4*unit, resHeight+4*unit,
@@ -87,30 +108,39 @@ func (t *Title) InitTransition(r render.Renderer,
 		t.newTitle = t.genTitleTexture(r, t.curTitleText)
 	}
 	t.swapped = false
-	return singleDuration*2 + waitTime
+	t.transition = t.Transition.Transition
+	t.singleDuration = t.transition.Scaled(defaultSingleDuration,
+		defaultDuration)
+	t.waitTime = t.transition.Length() - 2*t.singleDuration
+	return t.transition.Length()
+}
+
+// swap replaces the current title with the new one.
+func (t *Title) swap(r render.Renderer) {
+	if !t.swapped {
+		r.FreeImage(&t.curTitle)
+		t.curTitle = t.newTitle
+		t.newTitle = render.Image{}
+		t.swapped = true
+	}
 }
 
 // TransitionStep advances the transition.
 func (t *Title) TransitionStep(r render.Renderer, elapsed time.Duration) {
-	if elapsed < time.Second/3 {
+	if elapsed < t.singleDuration {
 		if !t.curTitle.IsEmpty() {
-			pos := render.TransitionCurve{Duration: singleDuration}.Cubic(elapsed)
+			pos := t.transition.Progress(elapsed, t.singleDuration)
 			t.curYOffset = int32(pos * float32(t.curTitle.Height) * 1.1)
 		}
-	} else if elapsed < time.Second/3+time.Millisecond*100 {
+	} else if elapsed < t.singleDuration+t.waitTime {
 		if !t.curTitle.IsEmpty() {
 			t.curYOffset = t.curTitle.Height + 1
 		}
 	} else {
-		if !t.swapped {
-			r.FreeImage(&t.curTitle)
-			t.curTitle = t.newTitle
-			t.newTitle = render.Image{}
-			t.swapped = true
-		}
+		t.swap(r)
 		if !t.curTitle.IsEmpty() {
-			pos := render.TransitionCurve{Duration: singleDuration}.Cubic(
-				elapsed - singleDuration - waitTime)
+			pos := t.transition.Progress(
+				elapsed-t.singleDuration-t.waitTime, t.singleDuration)
 			t.curYOffset =
 				int32((1.0 - pos) * float32(t.curTitle.Height) * 1.1)
 		}
@@ -119,6 +149,7 @@ func (t *Title) TransitionStep(r render.Renderer, elapsed time.Duration) {
 
 // FinishTransition finalizes the transition.
 func (t *Title) FinishTransition(r render.Renderer) {
+	t.swap(r)
 	t.curYOffset = 0
 }
 
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/QuestScreen/QuestScreen/plugins/base/shared"
+	"github.com/QuestScreen/api/server"
+	"github.com/QuestScreen/api/web/config"
+)
+
+// NewTransitionSelect creates a new TransitionSelect widget and initializes it.
+func NewTransitionSelect(ctx server.Context) config.Widget {
+	ret := new(TransitionSelect)
+	ret.askewInit()
+	return ret
+}
+
+// SetEditHandler sets the object that should receive Edited() events.
+func (ts *TransitionSelect) SetEditHandler(editHandler config.EditHandler) {
+	ts.editHandler = editHandler
+}
+
+// Receive loads the data given via input.
+func (ts *TransitionSelect) Receive(input json.RawMessage,
+	ctx server.Context) error {
+	if err := json.Unmarshal(input, &ts.data); err != nil {
+		return err
+	}
+	ts.Reset()
+	return nil
+}
+
+// Reset resets the UI to the values that have last been queried via Send().
+// If the values have never been queried, the UI is reset to the initial
+// data the state object was loaded with.
+func (ts *TransitionSelect) Reset() {
+	ts.duration.Set(strconv.FormatFloat(ts.data.Duration.Seconds(), 'f', -1, 64))
+	ts.easing.Set(int(ts.data.Easing))
+}
+
+// SetEnabled enables or disables the GUI.
+func (ts *TransitionSelect) SetEnabled(value bool) {
+	ts.durationDisabled.Set(!value)
+	ts.easingDisabled.Set(!value)
+}
+
+// Send returns a shared.Transition object containing the currently selected
+// values. An invalid duration keeps the previous value.
+func (ts *TransitionSelect) Send(ctx server.Context) interface{} {
+	if seconds, err := strconv.ParseFloat(ts.duration.Get(), 64); err == nil &&
+		seconds >= 0 {
+		ts.data.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	ts.data.Easing = shared.Easing(ts.easing.Get())
+	return &ts.data
+}
+
+func (ts *TransitionSelect) edited() {
+	ts.editHandler.Edited()
+}
@@ -0,0 +1,363 @@
+package qrcode
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+var levels = [...]Level{Low, Medium, Quartile, High}
+
+// format information after masking, indexed by level and mask pattern,
+// as listed in ISO/IEC 18004 Annex C.
+var knownFormatInformation = [4][8]string{
+	{"111011111000100", "111001011110011", "111110110101010", "111100010011101",
+		"110011000101111", "110001100011000", "110110001000001", "110100101110110"},
+	{"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+		"100010111111001", "100000011001110", "100111110010111", "100101010100000"},
+	{"011010101011111", "011000001101000", "011111100110001", "011101000000110",
+		"010010010110100", "010000110000011", "010111011011010", "010101111101101"},
+	{"001011010001001", "001001110111110", "001110011100111", "001100111010000",
+		"000011101100010", "000001001010101", "000110100001100", "000100000111011"},
+}
+
+func TestFormatInformation(t *testing.T) {
+	for i, level := range levels {
+		for mask := 0; mask < 8; mask++ {
+			expected, _ := strconv.ParseInt(knownFormatInformation[i][mask], 2, 32)
+			if actual := formatInformation(level, mask); actual != int(expected) {
+				t.Errorf("level %d, mask %d: expected %015b, got %015b",
+					level, mask, expected, actual)
+			}
+		}
+	}
+}
+
+// version information as listed in ISO/IEC 18004 Annex D.
+var knownVersionInformation = [...]int{0x07C94, 0x085BC, 0x09A99, 0x0A4D3,
+	0x0BBF6, 0x0C762, 0x0D847, 0x0E60D, 0x0F928, 0x10B78, 0x1145D, 0x12A17,
+	0x13532, 0x149A6, 0x15683, 0x168C9, 0x177EC, 0x18EC4, 0x191E1, 0x1AFAB,
+	0x1B08E, 0x1CC1A, 0x1D33F, 0x1ED75, 0x1F250, 0x209D5, 0x216F0, 0x228BA,
+	0x2379F, 0x24B0B, 0x2542E, 0x26A64, 0x27541, 0x28C69}
+
+func TestVersionInformation(t *testing.T) {
+	for i, expected := range knownVersionInformation {
+		version := i + 7
+		if actual := versionInformation(version); actual != expected {
+			t.Errorf("version %d: expected %05X, got %05X", version, expected,
+				actual)
+		}
+	}
+}
+
+func TestGFMultiply(t *testing.T) {
+	for _, tc := range []struct{ x, y, expected byte }{
+		{0x00, 0x53, 0x00}, {0x01, 0x53, 0x53}, {0x80, 0x02, 0x1D},
+		{0x02, 0x80, 0x1D}, {0x8E, 0x02, 0x01}, {0xFF, 0xFF, 0xE2},
+	} {
+		if actual := gfMultiply(tc.x, tc.y); actual != tc.expected {
+			t.Errorf("%02X * %02X: expected %02X, got %02X", tc.x, tc.y,
+				tc.expected, actual)
+		}
+	}
+}
+
+// exponents of α of the generator polynomials' coefficients, highest power
+// first, as listed in ISO/IEC 18004 Annex A.
+var knownGenerators = map[int][]int{
+	7:  {0, 87, 229, 146, 149, 238, 102, 21},
+	10: {0, 251, 67, 46, 61, 118, 70, 64, 94, 32, 45},
+	13: {0, 74, 152, 176, 100, 86, 100, 106, 104, 130, 218, 206, 140, 78},
+	15: {0, 8, 183, 61, 91, 202, 37, 51, 58, 58, 237, 140, 124, 5, 99, 105},
+	16: {0, 120, 104, 107, 109, 102, 161, 76, 3, 91, 191, 147, 169, 182, 194,
+		225, 120},
+	17: {0, 43, 139, 206, 78, 43, 239, 123, 206, 214, 147, 24, 99, 150, 39,
+		243, 163, 136},
+	18: {0, 215, 234, 158, 94, 184, 97, 118, 170, 79, 187, 152, 148, 252, 179,
+		5, 98, 96, 153},
+	20: {0, 17, 60, 79, 50, 61, 163, 26, 187, 202, 180, 221, 225, 83, 239, 156,
+		164, 212, 212, 188, 190},
+	22: {0, 210, 171, 247, 242, 93, 230, 14, 109, 221, 53, 200, 74, 8, 172, 98,
+		80, 219, 134, 160, 105, 165, 231},
+	24: {0, 229, 121, 135, 48, 211, 117, 251, 126, 159, 180, 169, 152, 192,
+		226, 228, 218, 111, 0, 117, 232, 87, 96, 227, 21},
+	26: {0, 173, 125, 158, 2, 103, 182, 118, 17, 145, 201, 111, 28, 165, 53,
+		161, 21, 245, 142, 13, 102, 48, 227, 153, 145, 218, 70},
+	28: {0, 168, 223, 200, 104, 224, 234, 108, 180, 110, 190, 195, 147, 205, 27,
+		232, 201, 21, 43, 245, 87, 42, 195, 212, 119, 242, 37, 9, 123},
+	30: {0, 41, 173, 145, 152, 216, 31, 179, 182, 50, 48, 110, 86, 239, 96, 222,
+		125, 42, 173, 226, 193, 224, 130, 156, 37, 251, 216, 238, 40, 192, 180},
+}
+
+func TestReedSolomonDivisor(t *testing.T) {
+	var exp [255]byte
+	exp[0] = 1
+	for i := 1; i < len(exp); i++ {
+		exp[i] = gfMultiply(exp[i-1], 0x02)
+	}
+	// every generator used by a version and level must be covered.
+	for _, level := range levels {
+		for version := minVersion; version <= maxVersion; version++ {
+			if _, ok := knownGenerators[eccCodewordsPerBlock[level][version]]; !ok {
+				t.Fatalf("missing generator of degree %d",
+					eccCodewordsPerBlock[level][version])
+			}
+		}
+	}
+	for degree, exponents := range knownGenerators {
+		divisor := reedSolomonDivisor(degree)
+		if len(divisor) != degree {
+			t.Errorf("degree %d: got %d coefficients", degree, len(divisor))
+			continue
+		}
+		for i, e := range exponents[1:] {
+			if divisor[i] != exp[e] {
+				t.Errorf("degree %d, coefficient %d: expected α^%d = %02X, got %02X",
+					degree, i+1, e, exp[e], divisor[i])
+			}
+		}
+	}
+}
+
+func TestReedSolomonRemainder(t *testing.T) {
+	// "HELLO WORLD" in alphanumeric mode, version 1-M.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17,
+		236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	actual := reedSolomonRemainder(data, reedSolomonDivisor(10))
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+// number of bytes fitting into a QR code in byte mode, indexed by version and
+// level.
+var knownCapacities = map[int][4]int{
+	1:  {17, 14, 11, 7},
+	2:  {32, 26, 20, 14},
+	7:  {154, 122, 86, 64},
+	9:  {230, 180, 130, 98},
+	10: {271, 213, 151, 119},
+	20: {858, 666, 482, 382},
+	40: {2953, 2331, 1663, 1273},
+}
+
+func testData(length int) []byte {
+	ret := make([]byte, length)
+	for i := range ret {
+		ret[i] = byte(i*7 + 3)
+	}
+	return ret
+}
+
+func TestCapacity(t *testing.T) {
+	for version, capacities := range knownCapacities {
+		for i, level := range levels {
+			c, err := Encode(testData(capacities[i]), level)
+			if err != nil {
+				t.Errorf("version %d, level %d: %s", version, level, err)
+				continue
+			}
+			if c.version != version || c.Size != version*4+17 {
+				t.Errorf("version %d, level %d: got version %d with size %d",
+					version, level, c.version, c.Size)
+			}
+			c, err = Encode(testData(capacities[i]+1), level)
+			if version == maxVersion {
+				if err != ErrTooLong {
+					t.Errorf("level %d: expected ErrTooLong, got %v", level, err)
+				}
+			} else if err != nil || c.version != version+1 {
+				t.Errorf("version %d, level %d: exceeding the capacity did not "+
+					"select the next version", version, level)
+			}
+		}
+	}
+}
+
+// maskedAt returns whether the module at the given column and row is
+// inverted by the given mask pattern.
+func maskedAt(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (y+x)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (y+x)%3 == 0
+	case 4:
+		return (y/2+x/3)%2 == 0
+	case 5:
+		return (y*x)%2+(y*x)%3 == 0
+	case 6:
+		return ((y*x)%2+(y*x)%3)%2 == 0
+	default:
+		return ((y+x)%2+(y*x)%3)%2 == 0
+	}
+}
+
+// decode reads the given code as a scanner would and returns the encoded
+// bytes.
+func decode(t *testing.T, c *Code, level Level) []byte {
+	t.Helper()
+	// both copies of the format information, most significant bit first.
+	var first, second int
+	for i := 14; i >= 0; i-- {
+		var x, y int
+		switch {
+		case i < 6:
+			x, y = 8, i
+		case i < 8:
+			x, y = 8, i+1
+		case i == 8:
+			x, y = 7, 8
+		default:
+			x, y = 14-i, 8
+		}
+		first = first<<1 | boolBit(c.Dark(x, y))
+		if i < 8 {
+			x, y = c.Size-1-i, 8
+		} else {
+			x, y = 8, c.Size-15+i
+		}
+		second = second<<1 | boolBit(c.Dark(x, y))
+	}
+	if first != second {
+		t.Fatalf("format information copies differ: %015b, %015b", first, second)
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatInformation(level, m) == first {
+			mask = m
+		}
+	}
+	if mask == -1 {
+		t.Fatalf("invalid format information %015b", first)
+	}
+	if !c.Dark(8, c.Size-8) {
+		t.Error("missing dark module")
+	}
+	if c.version >= 7 {
+		var first, second int
+		for i := 17; i >= 0; i-- {
+			first = first<<1 | boolBit(c.Dark(c.Size-11+i%3, i/3))
+			second = second<<1 | boolBit(c.Dark(i/3, c.Size-11+i%3))
+		}
+		if first != versionInformation(c.version) || first != second {
+			t.Fatalf("invalid version information %018b, %018b", first, second)
+		}
+	}
+
+	// read the codewords in zigzag order, starting at the bottom right.
+	var bits []bool
+	upward := true
+	for right := c.Size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for k := 0; k < c.Size; k++ {
+			y := k
+			if upward {
+				y = c.Size - 1 - k
+			}
+			for x := right; x > right-2; x-- {
+				if !c.isFunction[y*c.Size+x] {
+					bits = append(bits, c.Dark(x, y) != maskedAt(mask, x, y))
+				}
+			}
+		}
+		upward = !upward
+	}
+	if len(bits) != numRawDataModules(c.version) {
+		t.Fatalf("expected %d data modules, got %d",
+			numRawDataModules(c.version), len(bits))
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i := range codewords {
+		for _, bit := range bits[i*8 : i*8+8] {
+			codewords[i] = codewords[i]<<1 | byte(boolBit(bit))
+		}
+	}
+
+	// de-interleave the blocks and check their error correction.
+	numBlocks := numErrorCorrectionBlocks[level][c.version]
+	eccLen := eccCodewordsPerBlock[level][c.version]
+	numLong := len(codewords) % numBlocks
+	shortLen := len(codewords)/numBlocks - eccLen
+	data := make([][]byte, numBlocks)
+	pos := 0
+	for i := 0; i <= shortLen; i++ {
+		for j := range data {
+			if i < shortLen || j >= numBlocks-numLong {
+				data[j] = append(data[j], codewords[pos])
+				pos++
+			}
+		}
+	}
+	ecc := make([][]byte, numBlocks)
+	for i := 0; i < eccLen; i++ {
+		for j := range ecc {
+			ecc[j] = append(ecc[j], codewords[pos])
+			pos++
+		}
+	}
+	var stream []byte
+	for j := range data {
+		if !bytes.Equal(reedSolomonRemainder(data[j],
+			reedSolomonDivisor(eccLen)), ecc[j]) {
+			t.Fatalf("invalid error correction of block %d", j)
+		}
+		stream = append(stream, data[j]...)
+	}
+
+	// parse the byte mode segment.
+	reader := bitReader{data: stream}
+	if mode := reader.read(4); mode != 0x4 {
+		t.Fatalf("expected byte mode, got %04b", mode)
+	}
+	ret := make([]byte, reader.read(charCountBits(c.version)))
+	for i := range ret {
+		ret[i] = byte(reader.read(8))
+	}
+	return ret
+}
+
+func boolBit(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (br *bitReader) read(length int) (ret int) {
+	for ; length > 0; length-- {
+		ret = ret<<1 | int(br.data[br.pos>>3]>>uint(7-br.pos&7)&1)
+		br.pos++
+	}
+	return
+}
+
+func TestEncode(t *testing.T) {
+	for version, capacities := range knownCapacities {
+		for i, level := range levels {
+			for _, length := range []int{capacities[i], capacities[i] / 2} {
+				input := testData(length)
+				c, err := Encode(input, level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if output := decode(t, c, level); !bytes.Equal(input, output) {
+					t.Errorf("version %d, level %d, %d bytes: decoded data differs",
+						version, level, length)
+				}
+			}
+		}
+	}
+}
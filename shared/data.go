@@ -10,6 +10,21 @@ type ModuleConfig []interface{}
 type System struct {
 	Name string `json:"name"`
 	ID   string `json:"id"`
+	// attributes of the heroes of groups using this system, in display order.
+	HeroAttributes []HeroAttribute `json:"heroAttributes"`
+}
+
+// HeroAttribute describes an attribute of heroes declared by a system.
+type HeroAttribute struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// HeroAttributeValue is the value of a hero attribute for a certain hero.
+type HeroAttributeValue struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
 }
 
 // Hero describes a hero in a group.
@@ -17,6 +32,11 @@ type Hero struct {
 	Name        string `json:"name"`
 	ID          string `json:"id"`
 	Description string `json:"description"`
+	// true if the hero has a portrait, which can be queried separately.
+	Portrait bool `json:"portrait"`
+	// values of the attributes declared by the group's system, in the order
+	// given by the system.
+	Attributes []HeroAttributeValue `json:"attributes"`
 }
 
 // Scene describes a scene of a group.
@@ -45,8 +65,14 @@ type Module struct {
 // TemplateDescr describes an available template for a system, group or scene.
 type TemplateDescr struct {
 	Name, Description string
+	// ID is only set for templates saved by the user.
+	ID string `json:",omitempty"`
 }
 
+// UserTemplatesID is the ID of the pseudo-plugin that lists the templates saved
+// by the user. It is always the last item in the list of plugins.
+const UserTemplatesID = "user"
+
 // Plugin describes a loaded plugin.
 type Plugin struct {
 	Name            string `json:"name"`
@@ -70,3 +96,41 @@ type State struct {
 	ActiveGroup int
 	ActiveScene int
 }
+
+// Backup describes a snapshot of the data directory.
+type Backup struct {
+	ID string `json:"id"`
+	// creation time as Unix timestamp in seconds
+	Created int64 `json:"created"`
+	// size of the snapshot archive in bytes
+	Size int64 `json:"size"`
+}
+
+// AuthStatus describes the access rights of a client.
+type AuthStatus struct {
+	// Enabled is false if authentication is disabled. Every client then has
+	// the "gm" role.
+	Enabled bool `json:"enabled"`
+	// Role is "gm", "player" or "" if the client is not authenticated.
+	Role string `json:"role"`
+	// Token is only set when a new token has been issued to the client.
+	Token string `json:"token,omitempty"`
+}
+
+// Device describes a client that has been issued a token.
+type Device struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// PlaylistState describes the playlist of the active group.
+type PlaylistState struct {
+	// Running is true while the playlist rotates through its scenes.
+	Running bool `json:"running"`
+	// Entry is the index of the current entry, -1 if the playlist is not
+	// running.
+	Entry int `json:"entry"`
+	// Scenes lists the IDs of the scenes of the playlist's entries.
+	Scenes []string `json:"scenes"`
+}
@@ -0,0 +1,40 @@
+package shared
+
+import "encoding/json"
+
+// Names of the events sent by the server via its /events stream.
+// Each event's data is a JSON value whose structure depends on the event name.
+const (
+	// StateEvent is sent when the active group or scene changes or when the
+	// state of the active scene's modules has been rebuilt.
+	// Its data is a StateResponse.
+	StateEvent = "state"
+	// DataEvent is sent when a system, group, scene or hero has been created,
+	// modified or deleted. Its data is a Data object.
+	DataEvent = "data"
+	// ModuleEvent is sent when the state of a module in the active scene has
+	// been changed via one of its endpoints. Its data is a ModuleStateUpdate.
+	ModuleEvent = "module"
+	// ConfigEvent is sent when a configuration has been updated.
+	// Its data is a ConfigUpdate.
+	ConfigEvent = "config"
+	// TemplatesEvent is sent when a user template has been saved or deleted.
+	// Its data is the list of plugins as given in Static.
+	TemplatesEvent = "templates"
+)
+
+// ModuleStateUpdate is the data of a ModuleEvent.
+type ModuleStateUpdate struct {
+	ModuleIndex ModuleIndex `json:"moduleIndex"`
+	// the module's new state as it would be contained in StateResponse.
+	State json.RawMessage `json:"state"`
+}
+
+// ConfigUpdate is the data of a ConfigEvent.
+type ConfigUpdate struct {
+	// path of the updated config below /config, e.g. "base" or
+	// "groups/<group-id>/scenes/<scene-id>"
+	Path string `json:"path"`
+	// the new configuration as it would be returned by GET on Path.
+	Modules json.RawMessage `json:"modules"`
+}
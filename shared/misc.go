@@ -1,5 +1,10 @@
 package shared
 
+import (
+	"fmt"
+	"time"
+)
+
 // ModuleIndex identifies a module internally.
 // This is not the index of a module inside a plugin.
 type ModuleIndex int
@@ -17,3 +22,43 @@ type Message struct {
 	// text to display
 	Text string `json:"text"`
 }
+
+// SceneTransitionStyle defines how the display changes from one scene to the
+// next.
+type SceneTransitionStyle int
+
+const (
+	// CutTransition shows the next scene immediately.
+	CutTransition SceneTransitionStyle = iota
+	// FadeTransition cross-fades from the previous scene to the next one.
+	FadeTransition
+	// SlideTransition slides the previous scene out to the west, uncovering the
+	// next one.
+	SlideTransition
+)
+
+var sceneTransitionNames = [...]string{"cut", "fade", "slide"}
+
+// String returns the name of the style as used in the YAML files.
+func (s SceneTransitionStyle) String() string {
+	if s < CutTransition || s > SlideTransition {
+		return "unknown"
+	}
+	return sceneTransitionNames[s]
+}
+
+// ParseSceneTransitionStyle returns the style with the given name.
+func ParseSceneTransitionStyle(name string) (SceneTransitionStyle, error) {
+	for i := range sceneTransitionNames {
+		if sceneTransitionNames[i] == name {
+			return SceneTransitionStyle(i), nil
+		}
+	}
+	return CutTransition, fmt.Errorf("unknown scene transition \"%s\"", name)
+}
+
+// SceneTransition describes the transition to a scene.
+type SceneTransition struct {
+	Style    SceneTransitionStyle `json:"style"`
+	Duration time.Duration        `json:"duration"`
+}
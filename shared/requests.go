@@ -6,6 +6,8 @@ import "encoding/json"
 // the modification of a system.
 type SystemModificationRequest struct {
 	Name string `json:"name"`
+	// if given, replaces the hero attributes declared by the system.
+	HeroAttributes []HeroAttribute `json:"heroAttributes,omitempty"`
 }
 
 // GroupCreationRequest is sent from the client to the server to request the
@@ -14,6 +16,8 @@ type GroupCreationRequest struct {
 	Name               string `json:"name"`
 	PluginIndex        int    `json:"pluginIndex"`
 	GroupTemplateIndex int    `json:"groupTemplateIndex"`
+	// ID of the template if it is a user template, whose index is not stable.
+	TemplateID string `json:"templateID"`
 }
 
 // GroupModificationRequest is sent from the client to the server to request
@@ -23,12 +27,35 @@ type GroupModificationRequest struct {
 	SystemIndex int    `json:"systemIndex"`
 }
 
+// GroupCloneRequest is sent from the client to the server to request the
+// creation of a copy of a group. The flags select which parts of the group are
+// copied besides its config and scenes.
+type GroupCloneRequest struct {
+	Name      string `json:"name"`
+	Heroes    bool   `json:"heroes"`
+	State     bool   `json:"state"`
+	Resources bool   `json:"resources"`
+}
+
 // SceneCreationRequest is sent from the client to the server to request the
 // creation of a scene.
 type SceneCreationRequest struct {
 	Name               string `json:"name"`
 	PluginIndex        int    `json:"pluginIndex"`
 	SceneTemplateIndex int    `json:"sceneTemplateIndex"`
+	// ID of the template if it is a user template, whose index is not stable.
+	TemplateID string `json:"templateID"`
+}
+
+// TemplateCreationRequest is sent from the client to the server to request
+// saving a group or scene as template.
+type TemplateCreationRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// ID of the group that is saved or that contains the scene that is saved.
+	Group string `json:"group"`
+	// ID of the scene that is saved. Empty for group templates.
+	Scene string `json:"scene"`
 }
 
 // SceneModificationRequest is sent from the client to the server to request the
@@ -43,15 +70,47 @@ type SceneModificationRequest struct {
 type HeroModificationRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	// attribute ID -> value. Attributes with an empty value are removed. If nil,
+	// the attributes are not modified.
+	Attributes map[string]string `json:"attributes"`
 }
 
 type StateRequest struct {
 	Action string `json:"action"`
 	Index  int    `json:"index"`
+	// Output is the index of the output a scene is set for. 0 is the primary
+	// output, which always shows the active scene.
+	Output int `json:"output"`
 }
 
 type StateResponse struct {
 	ActiveGroup int               `json:"activeGroup"`
 	ActiveScene int               `json:"activeScene"`
 	Modules     []json.RawMessage `json:"modules"`
+	// OutputScenes contains, for each output, the index of the scene it shows,
+	// or -1 if it shows the active scene.
+	OutputScenes []int `json:"outputScenes"`
+}
+
+// ResourceUploadRequest is sent from the client to the server to upload a
+// resource file.
+type ResourceUploadRequest struct {
+	Name string `json:"name"`
+	// content of the file, base64-encoded in JSON.
+	Content []byte `json:"content"`
+}
+
+// LoginRequest is sent from the client to the server to request a GM token.
+type LoginRequest struct {
+	Password string `json:"password"`
+	// name of the device, used to identify it in the list of devices.
+	Name string `json:"name"`
+}
+
+// PairingRequest is sent from the client to the server to request a player
+// token with the pairing code shown on the welcome screen.
+type PairingRequest struct {
+	Code string `json:"code"`
+	// name of the device, used to identify it in the list of devices.
+	Name string `json:"name"`
 }
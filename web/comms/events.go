@@ -0,0 +1,36 @@
+package comms
+
+import "syscall/js"
+
+// EventHandler processes the JSON data of an event received from the server's
+// /events stream.
+type EventHandler func(data []byte)
+
+// Subscribe connects to the server's /events stream and calls the handler
+// registered for an event's name each time such an event is received.
+// Handlers are called in their own goroutine so that they may use Fetch.
+//
+// The browser automatically reconnects if the connection is lost. Since events
+// may have been missed in the meantime, resync is called after each successful
+// reconnect.
+func Subscribe(handlers map[string]EventHandler, resync func()) {
+	source := js.Global().Get("EventSource").New("/events")
+	for name, handler := range handlers {
+		h := handler
+		source.Call("addEventListener", name, js.FuncOf(
+			func(this js.Value, args []js.Value) interface{} {
+				data := []byte(args[0].Get("data").String())
+				go h(data)
+				return nil
+			}))
+	}
+	connected := false
+	source.Call("addEventListener", "open", js.FuncOf(
+		func(this js.Value, args []js.Value) interface{} {
+			if connected {
+				go resync()
+			}
+			connected = true
+			return nil
+		}))
+}
@@ -92,7 +92,9 @@ func (o *base) addSystem() {
 func (o *base) addGroup() {
 	TemplateSelect(&site.Popup, GroupTemplate, func(pluginIndex int, templateIndex int, name string) {
 		if err := comms.Fetch(api.Post, "data/groups", shared.GroupCreationRequest{
-			name, pluginIndex, templateIndex}, &web.Data.Groups); err != nil {
+			Name: name, PluginIndex: pluginIndex, GroupTemplateIndex: templateIndex,
+			TemplateID: web.StaticData.Plugins[pluginIndex].GroupTemplates[templateIndex].ID},
+			&web.Data.Groups); err != nil {
 			panic(err)
 		}
 		site.Refresh("")
@@ -186,7 +188,9 @@ func (o *group) createScene() {
 	TemplateSelect(&site.Popup, SceneTemplate, func(pluginIndex, templateIndex int, name string) {
 		if err := comms.Fetch(api.Post, "data/groups/"+o.data.ID+"/scenes",
 			shared.SceneCreationRequest{
-				name, pluginIndex, templateIndex}, &o.data.Scenes); err != nil {
+				Name: name, PluginIndex: pluginIndex, SceneTemplateIndex: templateIndex,
+				TemplateID: web.StaticData.Plugins[pluginIndex].SceneTemplates[templateIndex].ID},
+			&o.data.Scenes); err != nil {
 			panic(err)
 		}
 		site.Refresh("g-" + o.data.ID)
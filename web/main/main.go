@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/QuestScreen/QuestScreen/shared"
 	"github.com/QuestScreen/QuestScreen/web"
 	"github.com/QuestScreen/QuestScreen/web/comms"
 	"github.com/QuestScreen/QuestScreen/web/config"
@@ -25,7 +29,7 @@ func main() {
 	web.StaticData.Messages = loader.tmp.Messages
 	headerDisabled := false
 	for _, msg := range web.StaticData.Messages {
-		if msg.ModuleIndex == -1 {
+		if msg.ModuleIndex == -1 && msg.IsError {
 			headerDisabled = true
 			break
 		}
@@ -54,5 +58,58 @@ func main() {
 	// check if a session is already active and if so, load the session view.
 	session.CheckSession()
 
+	// keep in sync with changes made by other clients.
+	comms.Subscribe(map[string]comms.EventHandler{
+		shared.StateEvent:     session.StateChanged,
+		shared.DataEvent:      dataChanged,
+		shared.ModuleEvent:    session.ModuleChanged,
+		shared.ConfigEvent:    configChanged,
+		shared.TemplatesEvent: templatesChanged,
+	}, resync)
+
 	askew.KeepAlive()
 }
+
+func dataChanged(raw []byte) {
+	var data shared.Data
+	if err := json.Unmarshal(raw, &data); err != nil {
+		api.Log(api.LogError, "unable to read data event: "+err.Error())
+		return
+	}
+	// skip events caused by our own changes, which are already known.
+	if cur, err := json.Marshal(&web.Data); err == nil {
+		if next, err := json.Marshal(&data); err == nil && bytes.Equal(cur, next) {
+			return
+		}
+	}
+	web.Data = data
+	site.Reload()
+}
+
+func configChanged(raw []byte) {
+	if site.CurrentPage() == site.ConfigPage {
+		site.Reload()
+	}
+}
+
+func templatesChanged(raw []byte) {
+	var plugins []shared.Plugin
+	if err := json.Unmarshal(raw, &plugins); err != nil {
+		api.Log(api.LogError, "unable to read templates event: "+err.Error())
+		return
+	}
+	// the list of plugins is static, only the user templates change.
+	if len(plugins) == len(web.StaticData.Plugins) {
+		web.StaticData.Plugins = plugins
+	}
+}
+
+// resync reloads all data after the event stream has been reconnected since
+// events may have been lost in the meantime.
+func resync() {
+	if err := comms.Fetch(api.Get, "/data", nil, &web.Data); err != nil {
+		api.Log(api.LogError, "unable to reload data: "+err.Error())
+		return
+	}
+	session.CheckSession()
+}
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"bytes"
 	"encoding/json"
 
 	"github.com/QuestScreen/QuestScreen/shared"
@@ -124,6 +125,51 @@ func CheckSession() {
 	site.ShowHome()
 }
 
+// StateChanged handles a state event sent by the server, which happens when
+// another client changed the active group or scene.
+func StateChanged(raw []byte) {
+	var state shared.StateResponse
+	if err := json.Unmarshal(raw, &state); err != nil {
+		api.Log(api.LogError, "unable to read state event: "+err.Error())
+		return
+	}
+	if state.ActiveGroup == p.ActiveGroup && state.ActiveScene == p.ActiveScene {
+		p.modules = state.Modules
+		if site.CurrentPage() == site.SessionPage {
+			site.Reload()
+		}
+		return
+	}
+	if state.ActiveGroup == -1 {
+		site.UpdateSession(-1, -1)
+	} else {
+		p.loadState(&state)
+	}
+	switch site.CurrentPage() {
+	case site.InfoPage, site.SessionPage:
+		site.ShowHome()
+	}
+}
+
+// ModuleChanged handles a module event sent by the server, which happens when
+// a module's state in the active scene has been changed.
+func ModuleChanged(raw []byte) {
+	var update shared.ModuleStateUpdate
+	if err := json.Unmarshal(raw, &update); err != nil {
+		api.Log(api.LogError, "unable to read module event: "+err.Error())
+		return
+	}
+	index := int(update.ModuleIndex)
+	if p.ActiveGroup == -1 || index >= len(p.modules) ||
+		bytes.Equal(p.modules[index], update.State) {
+		return
+	}
+	p.modules[index] = update.State
+	if site.CurrentPage() == site.SessionPage {
+		site.Reload()
+	}
+}
+
 // Register registers this page with the site.
 func Register() {
 	site.RegisterPage(site.SessionPage, &p)
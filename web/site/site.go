@@ -124,8 +124,9 @@ const (
 
 type siteContent struct {
 	shared.State
-	pages   [4]Page
-	curPage PageKind
+	pages     [4]Page
+	curPage   PageKind
+	curViewID string
 }
 
 func (sc *siteContent) page() Page {
@@ -238,6 +239,7 @@ func Refresh(id string) {
 }
 
 func loadView(v View, parent, name string) {
+	site.curViewID = v.ID()
 	controls := NoControls
 	switch site.curPage {
 	case ConfigPage:
@@ -258,6 +260,24 @@ func loadView(v View, parent, name string) {
 	}(controls)
 }
 
+// CurrentPage returns the kind of the page currently being displayed.
+func CurrentPage() PageKind {
+	return site.curPage
+}
+
+// Reload regenerates the current page and view from web.Data. It is to be
+// called when the data has been modified by another client.
+//
+// If the current page is a CommitablePage with uncommited changes, it will not
+// be reloaded so that the user's changes are not discarded.
+func Reload() {
+	if _, ok := site.page().(CommitablePage); ok &&
+		top.commitablePageEdited.Get() {
+		return
+	}
+	Refresh(site.curViewID)
+}
+
 func UpdateSession(groupIndex, sceneIndex int) {
 	if (site.State.ActiveGroup == -1) != (groupIndex == -1) {
 		if groupIndex == -1 {